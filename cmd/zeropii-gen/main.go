@@ -0,0 +1,584 @@
+// Command zeropii-gen generates EncryptPII, DecryptPII, MaskPII and
+// PseudonymizePII methods for structs with pii tags, so models can be
+// protected without reflection. The generated methods list the tagged fields
+// statically and hand each one to utils.EncryptPIIFields,
+// utils.DecryptPIIFields, utils.MaskPIIFields or utils.PseudonymizePIIFields,
+// which apply exactly what utils.EncryptStructPII, utils.DecryptStructPII,
+// utils.SanitizeCustomerData and utils.PseudonymizePII apply to the same
+// field.
+//
+// Usage, from a file of the package declaring the types:
+//
+//	//go:generate go run zeropii/cmd/zeropii-gen -type Customer
+//
+// The methods are written to <first type>_pii.go unless -output is given.
+// Tags are parsed and validated at generation time, so an invalid tag fails
+// generation and the generated code carries the parsed descriptors.
+// Nested structs, pointers, slices, arrays and maps declared in the package
+// are followed; types from other packages are assumed to hold no PII, and
+// interface fields are rejected because only reflection can follow them.
+// Unlike the reflection walker, generated code does not track visited
+// pointers, so data must not contain pointer cycles or shared pointers.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"zeropii/utils"
+)
+
+const (
+	generatedHeader = "// Code generated by zeropii-gen; DO NOT EDIT."
+	utilsImport     = "zeropii/utils"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of struct types to generate methods for")
+	output := flag.String("output", "", "output file; default <dir>/<first type>_pii.go")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("zeropii-gen: ")
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = filepath.Join(dir, strings.ToLower(types[0])+"_pii.go")
+	}
+
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(outputPath, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the formatted source of the methods for types declared in
+// the package in dir.
+func generate(dir string, typeNames []string) ([]byte, error) {
+	pkg, err := loadPackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{pkg: pkg, queued: map[string]bool{}}
+	for _, name := range typeNames {
+		if err := g.addRecord(strings.TrimSpace(name)); err != nil {
+			return nil, err
+		}
+	}
+	// Generating a walker may queue the structs it reaches
+	for i := 0; i < len(g.walkers); i++ {
+		if err := g.generateWalker(g.walkers[i]); err != nil {
+			return nil, err
+		}
+	}
+	return g.source()
+}
+
+// pkgInfo holds the type declarations of the package being generated for.
+type pkgInfo struct {
+	name  string
+	types map[string]ast.Expr
+}
+
+// loadPackage parses the non-test, non-generated Go files in dir.
+func loadPackage(dir string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &pkgInfo{types: map[string]ast.Expr{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if isGenerated(file) {
+			continue
+		}
+		pkg.name = file.Name.Name
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				pkg.types[typeSpec.Name.Name] = typeSpec.Type
+			}
+		}
+	}
+	if pkg.name == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return pkg, nil
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if comment.Text == generatedHeader {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type generator struct {
+	pkg     *pkgInfo
+	records bytes.Buffer
+	methods bytes.Buffer
+	// walkers lists the struct types that need a visitPII method
+	walkers []string
+	queued  map[string]bool
+	usesFmt bool
+}
+
+// addRecord writes the exported methods of the struct type name.
+func (g *generator) addRecord(name string) error {
+	st, ok := g.pkg.types[name].(*ast.StructType)
+	if !ok {
+		return fmt.Errorf("%s is not a struct type declared in package %s", name, g.pkg.name)
+	}
+	recv := receiverName(name)
+	recordID := strconv.Quote("")
+	if id := recordIDField(st); id != "" {
+		recordID = recv + "." + id
+	}
+
+	fmt.Fprintf(&g.records, `
+// EncryptPII encrypts the fields of the %[2]s tagged pii:"true", as
+// utils.EncryptStructPII does.
+func (%[1]s *%[2]s) EncryptPII(keyring *utils.Keyring) error {
+	return utils.EncryptPIIFields(keyring, %[3]s, %[1]s.walkPII)
+}
+
+// DecryptPII decrypts the fields of the %[2]s tagged pii:"true", as
+// utils.DecryptStructPII does.
+func (%[1]s *%[2]s) DecryptPII(keyring *utils.Keyring) error {
+	return utils.DecryptPIIFields(keyring, %[3]s, %[1]s.walkPII)
+}
+
+// MaskPII masks the fields of the %[2]s that viewer may not see, as
+// utils.SanitizeCustomerData does.
+func (%[1]s *%[2]s) MaskPII(viewer utils.Viewer) {
+	utils.MaskPIIFields(viewer, %[1]s.walkPII)
+}
+
+// PseudonymizePII replaces the fields of the %[2]s tagged pii:"true" with
+// their pseudonyms under pseudonymizer, as utils.PseudonymizePII does.
+func (%[1]s *%[2]s) PseudonymizePII(pseudonymizer *utils.Pseudonymizer) {
+	utils.PseudonymizePIIFields(pseudonymizer, %[1]s.walkPII)
+}
+
+func (%[1]s *%[2]s) walkPII(visit func(utils.PIIField) error) error {
+	return %[1]s.visitPII("", visit)
+}
+`, recv, name, recordID)
+
+	g.queue(name)
+	return nil
+}
+
+// recordIDField returns the name of the string field stored as _id, which
+// binds ciphertexts to their record.
+func recordIDField(st *ast.StructType) string {
+	for _, field := range st.Fields.List {
+		if ident, ok := field.Type.(*ast.Ident); !ok || ident.Name != "string" || field.Tag == nil {
+			continue
+		}
+		bsonName, _, _ := strings.Cut(structTag(field).Get("bson"), ",")
+		if bsonName == "_id" && len(field.Names) > 0 {
+			return field.Names[0].Name
+		}
+	}
+	return ""
+}
+
+func (g *generator) queue(name string) {
+	if !g.queued[name] {
+		g.queued[name] = true
+		g.walkers = append(g.walkers, name)
+	}
+}
+
+// generateWalker writes the visitPII method of the struct type name, which
+// calls visit with every tagged string reachable from it. prefix is the
+// path of the struct followed by a dot, or empty for the record itself.
+func (g *generator) generateWalker(name string) error {
+	st := g.pkg.types[name].(*ast.StructType)
+	recv := receiverName(name)
+
+	w := &walkerWriter{g: g}
+	if err := w.structFields(recv, st, path{dynamic: "prefix"}, name); err != nil {
+		return err
+	}
+	fmt.Fprintf(&g.methods, "\nfunc (%s *%s) visitPII(prefix string, visit func(utils.PIIField) error) error {\n%sreturn nil\n}\n",
+		recv, name, w.body.String())
+	return nil
+}
+
+// path is a field path being built at generation time: a Go expression
+// computed at run time followed by a literal suffix.
+type path struct {
+	dynamic string
+	literal string
+}
+
+func (p path) join(segment string) path {
+	return path{dynamic: p.dynamic, literal: p.literal + segment}
+}
+
+func (p path) String() string {
+	switch {
+	case p.dynamic == "":
+		return strconv.Quote(p.literal)
+	case p.literal == "":
+		return p.dynamic
+	default:
+		return p.dynamic + " + " + strconv.Quote(p.literal)
+	}
+}
+
+type walkerWriter struct {
+	g     *generator
+	body  bytes.Buffer
+	depth int
+}
+
+// structFields writes the walk of the fields of st, reachable as expr.
+func (w *walkerWriter) structFields(expr string, st *ast.StructType, p path, where string) error {
+	for _, field := range st.Fields.List {
+		piiValue := structTag(field).Get("pii")
+		descriptor, err := utils.ParsePIITag(piiValue)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", where, strings.Join(fieldNames(field), ", "), err)
+		}
+		tagged := descriptor.Enabled
+
+		for _, name := range fieldNames(field) {
+			// Unexported fields cannot be set
+			if !ast.IsExported(name) {
+				continue
+			}
+			if !w.g.mayContainPII(field.Type, tagged, map[visitKey]bool{}) {
+				continue
+			}
+			leaf := leafField{
+				name:  name,
+				tag:   descriptor,
+				index: siblingIndex(expr, st, name),
+				where: where + "." + name,
+			}
+			if err := w.value(expr+"."+name, field.Type, tagged, p.join(name), leaf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// leafField describes the struct field a value belongs to.
+type leafField struct {
+	name  string
+	tag   utils.PIIDescriptor
+	index string // address of the sibling <name>Index field, if any
+	where string // Type.Field, for errors
+}
+
+// value writes the walk of the addressable expression expr of type typ.
+func (w *walkerWriter) value(expr string, typ ast.Expr, tagged bool, p path, leaf leafField) error {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if t.Name == "string" {
+			if tagged {
+				w.visit(addressOf(expr), p, leaf)
+			}
+			return nil
+		}
+		if t.Name == "any" || t.Name == "error" {
+			return w.value(expr, &ast.InterfaceType{}, tagged, p, leaf)
+		}
+		decl, ok := w.g.pkg.types[t.Name]
+		if !ok {
+			// Other predeclared types hold no strings
+			return nil
+		}
+		if _, ok := decl.(*ast.StructType); ok {
+			w.g.queue(t.Name)
+			fmt.Fprintf(&w.body, "if err := %s.visitPII(%s, visit); err != nil {\nreturn err\n}\n", trimDeref(expr), p.join("."))
+			return nil
+		}
+		if ident, ok := decl.(*ast.Ident); ok && ident.Name == "string" {
+			if tagged {
+				w.visit("(*string)(&"+expr+")", p, leaf)
+			}
+			return nil
+		}
+		return w.value(expr, decl, tagged, p, leaf)
+
+	case *ast.StructType:
+		return w.structFields(expr, t, p.join("."), leaf.where)
+
+	case *ast.StarExpr:
+		fmt.Fprintf(&w.body, "if %s != nil {\n", trimDeref(expr))
+		if err := w.value("(*"+trimDeref(expr)+")", t.X, tagged, p, leaf); err != nil {
+			return err
+		}
+		w.body.WriteString("}\n")
+		return nil
+
+	case *ast.ArrayType:
+		i := w.name("idx")
+		fmt.Fprintf(&w.body, "for %s := range %s {\n", i, expr)
+		w.depth++
+		if err := w.value(expr+"["+i+"]", t.Elt, tagged, p, leaf); err != nil {
+			return err
+		}
+		w.depth--
+		w.body.WriteString("}\n")
+		return nil
+
+	case *ast.MapType:
+		w.g.usesFmt = true
+		key, val := w.name("key"), w.name("val")
+		fmt.Fprintf(&w.body, "for _, %s := range utils.SortedKeys(%s) {\n%s := %s[%s]\n", key, expr, val, expr, key)
+		w.depth++
+		keyPath := path{dynamic: p.join(".").String() + " + fmt.Sprint(" + key + ")"}
+		if err := w.value(val, t.Value, tagged, keyPath, leaf); err != nil {
+			return err
+		}
+		w.depth--
+		// Map values are copies; store the walked value back
+		fmt.Fprintf(&w.body, "%s[%s] = %s\n}\n", expr, key, val)
+		return nil
+
+	case *ast.InterfaceType:
+		return fmt.Errorf("%s: interface fields cannot be walked without reflection; use the utils functions for this type", leaf.where)
+
+	case *ast.ParenExpr:
+		return w.value(expr, t.X, tagged, p, leaf)
+	}
+	return fmt.Errorf("%s: unsupported field type", leaf.where)
+}
+
+func (w *walkerWriter) visit(addr string, p path, leaf leafField) {
+	index := ""
+	if leaf.index != "" {
+		index = ", Index: " + leaf.index
+	}
+	fmt.Fprintf(&w.body, "if err := visit(utils.PIIField{Path: %s, Name: %q, Tag: %s, Value: %s%s}); err != nil {\nreturn err\n}\n",
+		p, leaf.name, descriptorLiteral(leaf.tag), addr, index)
+}
+
+// descriptorLiteral returns a utils.PIIDescriptor literal of d, setting only
+// its non-zero fields, so generated code never parses tags at run time.
+func descriptorLiteral(d utils.PIIDescriptor) string {
+	var fields []string
+	if d.Enabled {
+		fields = append(fields, "Enabled: true")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"Category", string(d.Category)},
+		{"Mask", string(d.Mask)},
+		{"Enc", string(d.Enc)},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.name+": "+strconv.Quote(field.value))
+		}
+	}
+	if d.Index {
+		fields = append(fields, "Index: true")
+	}
+	if d.Level != "" {
+		fields = append(fields, "Level: "+strconv.Quote(string(d.Level)))
+	}
+	return "utils.PIIDescriptor{" + strings.Join(fields, ", ") + "}"
+}
+
+// name returns a loop variable name unique within nested loops.
+func (w *walkerWriter) name(base string) string {
+	if w.depth == 0 {
+		return base
+	}
+	return base + strconv.Itoa(w.depth)
+}
+
+// mayContainPII reports whether a value of type typ, held by a field that is
+// tagged or not, can lead to a tagged string, following utils' plan rules.
+func (g *generator) mayContainPII(typ ast.Expr, tagged bool, visiting map[visitKey]bool) bool {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if t.Name == "string" {
+			return tagged
+		}
+		if t.Name == "any" || t.Name == "error" {
+			return true
+		}
+		decl, ok := g.pkg.types[t.Name]
+		if !ok {
+			return false
+		}
+		key := visitKey{t.Name, tagged}
+		if visiting[key] {
+			return false
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+		return g.mayContainPII(decl, tagged, visiting)
+
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			// Invalid tags are reported by structFields
+			descriptor, err := utils.ParsePIITag(structTag(field).Get("pii"))
+			fieldTagged := err != nil || descriptor.Enabled
+			for _, name := range fieldNames(field) {
+				if ast.IsExported(name) && g.mayContainPII(field.Type, fieldTagged, visiting) {
+					return true
+				}
+			}
+		}
+		return false
+
+	case *ast.StarExpr:
+		return g.mayContainPII(t.X, tagged, visiting)
+	case *ast.ArrayType:
+		return g.mayContainPII(t.Elt, tagged, visiting)
+	case *ast.MapType:
+		return g.mayContainPII(t.Value, tagged, visiting)
+	case *ast.ParenExpr:
+		return g.mayContainPII(t.X, tagged, visiting)
+	case *ast.InterfaceType:
+		return true
+	}
+	// Types from other packages, functions and channels
+	return false
+}
+
+type visitKey struct {
+	name   string
+	tagged bool
+}
+
+// source assembles and formats the generated file.
+func (g *generator) source() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n\npackage %s\n\nimport (\n", generatedHeader, g.pkg.name)
+	imports := []string{utilsImport}
+	if g.usesFmt {
+		imports = append(imports, "fmt")
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		fmt.Fprintf(&buf, "%q\n", path)
+	}
+	buf.WriteString(")\n")
+	buf.Write(g.records.Bytes())
+	buf.Write(g.methods.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// fieldNames returns the names a struct field declares; an embedded field is
+// named after its type.
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) > 0 {
+		names := make([]string, len(field.Names))
+		for i, name := range field.Names {
+			names[i] = name.Name
+		}
+		return names
+	}
+	typ := field.Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return []string{t.Name}
+	case *ast.SelectorExpr:
+		return []string{t.Sel.Name}
+	}
+	return nil
+}
+
+// siblingIndex returns the address of the <name>Index string field of st,
+// reachable as expr, or "" if st has none.
+func siblingIndex(expr string, st *ast.StructType, name string) string {
+	for _, field := range st.Fields.List {
+		ident, ok := field.Type.(*ast.Ident)
+		if !ok || ident.Name != "string" {
+			continue
+		}
+		for _, fieldName := range field.Names {
+			if fieldName.Name == name+"Index" {
+				return "&" + expr + "." + fieldName.Name
+			}
+		}
+	}
+	return ""
+}
+
+func structTag(field *ast.Field) reflect.StructTag {
+	if field.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(tag)
+}
+
+// addressOf returns the address of the addressable expression expr.
+func addressOf(expr string) string {
+	if deref := trimDeref(expr); deref != expr {
+		return deref
+	}
+	return "&" + expr
+}
+
+// trimDeref turns "(*p)" back into "p" where a pointer is accepted as well.
+func trimDeref(expr string) string {
+	if strings.HasPrefix(expr, "(*") && strings.HasSuffix(expr, ")") {
+		return expr[2 : len(expr)-1]
+	}
+	return expr
+}
+
+func receiverName(typeName string) string {
+	return strings.ToLower(typeName[:1])
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGeneratedFilesUpToDate fails when a model's pii tags changed without
+// re-running go generate, which is how hand-written methods drifted before.
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	for _, tc := range []struct {
+		dir, typeName, file string
+	}{
+		{"../../models", "Customer", "customer_pii.go"},
+		{"../../utils/internal/piifixture", "Record", "record_pii.go"},
+	} {
+		t.Run(tc.typeName, func(t *testing.T) {
+			want, err := generate(tc.dir, []string{tc.typeName})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(tc.dir, tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s is out of date; run go generate in %s", tc.file, tc.dir)
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsInterfaceFields(t *testing.T) {
+	dir := t.TempDir()
+	src := "package m\n\ntype Event struct {\n\tID      string `bson:\"_id\"`\n\tPayload interface{}\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "event.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := generate(dir, []string{"Event"})
+	if err == nil || !strings.Contains(err.Error(), "Event.Payload") {
+		t.Fatalf("generate error = %v, want an error naming Event.Payload", err)
+	}
+}
+
+func TestGenerateRejectsInvalidTags(t *testing.T) {
+	dir := t.TempDir()
+	src := "package m\n\ntype Event struct {\n\tID    string `bson:\"_id\"`\n\tEmail string `pii:\"category=mail\"`\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "event.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := generate(dir, []string{"Event"})
+	if err == nil || !strings.Contains(err.Error(), "Event.Email") {
+		t.Fatalf("generate error = %v, want an error naming Event.Email", err)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+	"zeropii/db"
+	"zeropii/models"
+	"zeropii/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type grantConsentRequest struct {
+	ApplicationName  string     `json:"application_name" binding:"required"`
+	Purposes         []string   `json:"purposes" binding:"required"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	CollectionMethod string     `json:"collection_method"`
+}
+
+type withdrawConsentRequest struct {
+	CollectionMethod string `json:"collection_method"`
+}
+
+// consentHistory is the consent history of a customer for one application.
+type consentHistory struct {
+	Application string                 `json:"application"`
+	Active      bool                   `json:"active"`
+	Consents    []models.ConsentDetail `json:"consents"`
+}
+
+// Grant an application consent to a customer's PII for some access purposes
+// (admin only)
+func grantConsent(c *gin.Context) {
+	id := c.Param("id")
+	role := c.GetHeader("x-viewer-role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	var req grantConsentRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy := utils.CurrentAccessPolicy()
+	if len(req.Purposes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one purpose is required"})
+		return
+	}
+	for _, purpose := range req.Purposes {
+		if !policy.HasPurpose(purpose) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown access purpose", "purpose": purpose})
+			return
+		}
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consents, ok := findConsents(ctx, c, "grant_consent", id)
+	if !ok {
+		return
+	}
+
+	consent := models.ConsentDetail{
+		ApplicationName: req.ApplicationName,
+		ConsentGiven:    true,
+		ConsentDate:     now,
+		Purposes:        req.Purposes,
+		ExpiresAt:       req.ExpiresAt,
+	}
+	termination := "On withdrawal"
+	if req.ExpiresAt != nil {
+		termination = "On withdrawal or at " + req.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	recordConsentChange(ctx, c, "grant_consent", id, role, consents, consent, req.CollectionMethod, termination)
+}
+
+// Withdraw an application's consent to a customer's PII; reads by the
+// application are limited from the next request on (admin only)
+func withdrawConsent(c *gin.Context) {
+	id := c.Param("id")
+	application := c.Param("application")
+	role := c.GetHeader("x-viewer-role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	var req withdrawConsentRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consents, ok := findConsents(ctx, c, "withdraw_consent", id)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	latest, found := models.LatestConsent(consents, application)
+	if !found || !latest.Active(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Application has no active consent"})
+		return
+	}
+
+	consent := models.ConsentDetail{
+		ApplicationName: application,
+		ConsentDate:     now,
+		Purposes:        latest.Purposes,
+		WithdrawnAt:     &now,
+	}
+	recordConsentChange(ctx, c, "withdraw_consent", id, role, consents, consent, req.CollectionMethod, "Withdrawn at "+now.Format(time.RFC3339))
+}
+
+// recordConsentChange appends consent to the customer's consent history,
+// stores a signed receipt for it and audits the change as made by role
+func recordConsentChange(ctx context.Context, c *gin.Context, operation, id, role string, consents []models.ConsentDetail, consent models.ConsentDetail, collectionMethod, termination string) {
+	status := utils.ConsentGranted
+	if !consent.ConsentGiven {
+		status = utils.ConsentWithdrawn
+	}
+	previous, hasPrevious := models.LatestConsent(consents, consent.ApplicationName)
+	consent.Version = previous.Version + 1
+	consent.ReceiptID = uuid.New().String()
+
+	if collectionMethod == "" {
+		collectionMethod = "api"
+	}
+	receipt := consentReceipt(id, consent, status, collectionMethod, termination)
+	if hasPrevious {
+		receipt.PreviousReceiptID = previous.ReceiptID
+	}
+	signed, err := receiptIssuer.Issue(receipt)
+	if err != nil {
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", id).Msg("Failed to sign consent receipt")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+		return
+	}
+
+	// The consent takes effect before its receipt is stored, so a receipt
+	// never records a change that did not happen
+	switch err := db.AppendCustomerConsent(ctx, id, consent); {
+	case errors.Is(err, db.ErrCustomerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	case errors.Is(err, db.ErrConsentConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Consent changed concurrently; retry the request"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", id).Msg("Failed to record consent")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+		return
+	}
+
+	record := &db.ConsentReceiptRecord{
+		ID:          consent.ReceiptID,
+		CustomerID:  id,
+		Application: consent.ApplicationName,
+		Status:      status,
+		Version:     consent.Version,
+		Receipt:     signed,
+		CreatedAt:   consent.ConsentDate,
+	}
+	if err := db.InsertConsentReceipt(ctx, record); err != nil {
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", id).Msg("Failed to store consent receipt")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Consent recorded but its receipt could not be stored"})
+		return
+	}
+
+	event := &db.AuditEvent{
+		Operation:   operation,
+		CustomerID:  id,
+		Role:        role,
+		Application: consent.ApplicationName,
+		Outcome:     status,
+		Details:     gin.H{"receipt_id": consent.ReceiptID, "version": consent.Version, "collection_method": collectionMethod},
+	}
+	if err := db.RecordAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", id).Msg("Failed to record audit event")
+	}
+
+	log.Info().
+		Str("operation", operation).
+		Str("customer_id", id).
+		Str("application", consent.ApplicationName).
+		Int("version", consent.Version).
+		Msg("Consent " + status)
+
+	c.JSON(http.StatusCreated, gin.H{"consent": consent, "receipt_id": consent.ReceiptID, "receipt": signed})
+}
+
+// consentReceipt describes a consent change of the customer id as a Kantara
+// consent receipt, listing for each purpose the PII categories of the
+// customer model that the access policy lets it reach
+func consentReceipt(id string, consent models.ConsentDetail, status, collectionMethod, termination string) *utils.ConsentReceipt {
+	policy := utils.CurrentAccessPolicy()
+	held := utils.PIICategoriesOf(models.Customer{}, utils.SensitivityLow)
+
+	purposes := make([]utils.ReceiptPurpose, 0, len(consent.Purposes))
+	for i, purpose := range consent.Purposes {
+		categories := []utils.PIICategory{}
+		for _, category := range held {
+			if policy.Allows(purpose, category) {
+				categories = append(categories, category)
+			}
+		}
+		purposes = append(purposes, utils.ReceiptPurpose{
+			Purpose:         purpose,
+			PurposeCategory: []string{purpose},
+			ConsentType:     "EXPLICIT",
+			PIICategory:     categories,
+			PrimaryPurpose:  i == 0,
+			Termination:     termination,
+		})
+	}
+
+	sensitive := utils.PIICategoriesOf(models.Customer{}, utils.SensitivityHigh)
+	return &utils.ConsentReceipt{
+		ConsentTimestamp: consent.ConsentDate.Unix(),
+		CollectionMethod: collectionMethod,
+		ConsentReceiptID: consent.ReceiptID,
+		PIIPrincipalID:   id,
+		Services:         []utils.ReceiptService{{Service: consent.ApplicationName, Purposes: purposes}},
+		Sensitive:        len(sensitive) > 0,
+		SPICat:           sensitive,
+		ConsentStatus:    status,
+	}
+}
+
+// List a customer's consent history per application, optionally for one
+// application (admin only)
+func listConsents(c *gin.Context) {
+	id := c.Param("id")
+	if c.GetHeader("x-viewer-role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consents, ok := findConsents(ctx, c, "list_consents", id)
+	if !ok {
+		return
+	}
+
+	byApplication := map[string][]models.ConsentDetail{}
+	for _, consent := range consents {
+		if application := c.Query("application"); application != "" && consent.ApplicationName != application {
+			continue
+		}
+		byApplication[consent.ApplicationName] = append(byApplication[consent.ApplicationName], consent)
+	}
+
+	now := time.Now()
+	histories := make([]consentHistory, 0, len(byApplication))
+	for application, history := range byApplication {
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].ConsentDate.Before(history[j].ConsentDate)
+		})
+		latest, _ := models.LatestConsent(history, application)
+		histories = append(histories, consentHistory{
+			Application: application,
+			Active:      latest.Active(now),
+			Consents:    history,
+		})
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Application < histories[j].Application
+	})
+
+	c.JSON(http.StatusOK, histories)
+}
+
+// findConsents loads a customer's consents, answering the request itself if
+// that fails
+func findConsents(ctx context.Context, c *gin.Context, operation, id string) ([]models.ConsentDetail, bool) {
+	consents, err := db.FindCustomerConsents(ctx, id)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return nil, false
+	case err != nil:
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", id).Msg("Failed to load customer consents")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load consents"})
+		return nil, false
+	}
+	return consents, true
+}
+
+// Fetch a signed consent receipt by ID (admin only)
+func getConsentReceipt(c *gin.Context) {
+	if c.GetHeader("x-viewer-role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	record, err := db.FindConsentReceipt(ctx, c.Param("receiptID"))
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Consent receipt not found"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", "get_consent_receipt").Msg("Failed to load consent receipt")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load consent receipt"})
+		return
+	}
+
+	// Receipts signed with an earlier key are served unverified
+	response := gin.H{"record": record, "verified": false}
+	if receipt, err := utils.VerifyConsentReceipt(record.Receipt, receiptIssuer.PublicKey()); err == nil {
+		response["verified"] = true
+		response["consent_receipt"] = receipt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Publish the key consent receipts are verified with, as a JWK set
+func getConsentReceiptKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": []map[string]string{receiptIssuer.JWK()}})
+}
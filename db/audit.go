@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var auditCollection *mongo.Collection
+
+// AuditEvent is an append-only record of a sensitive operation on a customer.
+type AuditEvent struct {
+	ID          string                 `json:"id" bson:"_id"`
+	Operation   string                 `json:"operation" bson:"operation"`
+	CustomerID  string                 `json:"customer_id" bson:"customer_id"`
+	Role        string                 `json:"role" bson:"role"`
+	Purpose     string                 `json:"purpose,omitempty" bson:"purpose,omitempty"`
+	Application string                 `json:"application,omitempty" bson:"application,omitempty"`
+	Outcome     string                 `json:"outcome" bson:"outcome"`
+	Details     map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	Timestamp   time.Time              `json:"timestamp" bson:"timestamp"`
+}
+
+// RecordAuditEvent appends an event to the audit log, assigning its ID and timestamp.
+func RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	event.ID = uuid.New().String()
+	event.Timestamp = time.Now().UTC()
+	_, err := auditCollection.InsertOne(ctx, event)
+	return err
+}
+
+// FindAuditEvents returns a customer's audit events, oldest first.
+func FindAuditEvents(ctx context.Context, customerID string) ([]AuditEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	cur, err := auditCollection.Find(ctx, bson.M{"customer_id": customerID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AuditEvent{}
+	if err := cur.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+	"zeropii/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var receiptCollection *mongo.Collection
+
+var (
+	// ErrCustomerNotFound is returned when a consent is recorded for a
+	// customer that does not exist or whose PII has been shredded.
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrConsentConflict is returned when another change to the same
+	// application's consent was recorded first.
+	ErrConsentConflict = errors.New("consent changed concurrently")
+)
+
+// ConsentReceiptRecord is a signed consent receipt as stored and served.
+type ConsentReceiptRecord struct {
+	ID          string `json:"id" bson:"_id"`
+	CustomerID  string `json:"customer_id" bson:"customer_id"`
+	Application string `json:"application" bson:"application"`
+	Status      string `json:"status" bson:"status"`
+	Version     int    `json:"version" bson:"version"`
+	// Receipt is the receipt signed as a compact JWS
+	Receipt   string    `json:"receipt" bson:"receipt"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// AppendCustomerConsent adds consent to the consent history of the customer
+// id. Earlier entries are never changed; the latest entry for an application
+// decides whether it has consent. The append only happens while no entry for
+// the application has consent.Version or a later one, so two concurrent
+// changes cannot share a version and previous receipt; the later one fails
+// with ErrConsentConflict.
+func AppendCustomerConsent(ctx context.Context, id string, consent models.ConsentDetail) error {
+	active := bson.M{"_id": id, "shredded_at": bson.M{"$exists": false}}
+	filter := bson.M{
+		"_id":         id,
+		"shredded_at": bson.M{"$exists": false},
+		"consents": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"application_name": consent.ApplicationName,
+			"version":          bson.M{"$gte": consent.Version},
+		}}},
+	}
+	result, err := customerCollection.UpdateOne(ctx, filter,
+		bson.M{"$push": bson.M{"consents": consent}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	count, err := customerCollection.CountDocuments(ctx, active, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrCustomerNotFound
+	}
+	return ErrConsentConflict
+}
+
+// InsertConsentReceipt stores a signed consent receipt.
+func InsertConsentReceipt(ctx context.Context, record *ConsentReceiptRecord) error {
+	_, err := receiptCollection.InsertOne(ctx, record)
+	return err
+}
+
+// FindConsentReceipt returns the receipt with the given ID, or
+// mongo.ErrNoDocuments if there is none.
+func FindConsentReceipt(ctx context.Context, id string) (*ConsentReceiptRecord, error) {
+	var record ConsentReceiptRecord
+	if err := receiptCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
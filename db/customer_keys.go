@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+	"zeropii/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// customerKey is a per-customer key document. Once shredded, Key is removed
+// and ShreddedAt is set, leaving a tombstone.
+//
+// This collection is what makes crypto-shredding work: it must be kept out of
+// long-retention backups (or backed up with a retention no longer than the
+// erasure deadline), otherwise a restored key would resurrect the customer.
+type customerKey struct {
+	CustomerID string            `bson:"_id"`
+	Key        *utils.WrappedKey `bson:"key,omitempty"`
+	CreatedAt  time.Time         `bson:"created_at"`
+	UpdatedAt  time.Time         `bson:"updated_at"`
+	ShreddedAt *time.Time        `bson:"shredded_at,omitempty"`
+}
+
+// CustomerKeyStore is the MongoDB implementation of utils.CustomerKeyStore.
+type CustomerKeyStore struct {
+	collection *mongo.Collection
+}
+
+// NewCustomerKeyStore stores customer keys in collection.
+func NewCustomerKeyStore(collection *mongo.Collection) *CustomerKeyStore {
+	return &CustomerKeyStore{collection: collection}
+}
+
+func (s *CustomerKeyStore) GetCustomerKey(ctx context.Context, customerID string) (*utils.WrappedKey, error) {
+	var doc customerKey
+	err := s.collection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.ErrCustomerKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.ShreddedAt != nil || doc.Key == nil {
+		return nil, utils.ErrKeyShredded
+	}
+	return doc.Key, nil
+}
+
+func (s *CustomerKeyStore) PutCustomerKey(ctx context.Context, customerID string, key *utils.WrappedKey) error {
+	now := time.Now()
+	_, err := s.collection.InsertOne(ctx, customerKey{
+		CustomerID: customerID,
+		Key:        key,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	return err
+}
+
+func (s *CustomerKeyStore) UpdateCustomerKey(ctx context.Context, customerID string, key *utils.WrappedKey) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": customerID, "shredded_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"key": key, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.ErrCustomerKeyNotFound
+	}
+	return nil
+}
+
+func (s *CustomerKeyStore) DeleteCustomerKey(ctx context.Context, customerID string) error {
+	now := time.Now()
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": customerID},
+		bson.M{
+			"$unset": bson.M{"key": ""},
+			"$set":   bson.M{"shredded_at": now, "updated_at": now},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.ErrCustomerKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"zeropii/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindCustomersByBlindIndex returns the customers whose blind index field
+// (e.g. "email_index") equals index. PII in the results is still encrypted.
+func FindCustomersByBlindIndex(ctx context.Context, field, index string) ([]models.Customer, error) {
+	cur, err := customerCollection.Find(ctx, bson.M{field: index})
+	if err != nil {
+		return nil, err
+	}
+
+	customers := []models.Customer{}
+	if err := cur.All(ctx, &customers); err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+// FindCustomerConsents returns the consents of the customer id, or
+// mongo.ErrNoDocuments if there is no such customer.
+func FindCustomerConsents(ctx context.Context, id string) ([]models.ConsentDetail, error) {
+	var customer models.Customer
+	opts := options.FindOne().SetProjection(bson.M{"consents": 1})
+	if err := customerCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&customer); err != nil {
+		return nil, err
+	}
+	return customer.Consents, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"os"
@@ -32,5 +33,31 @@ func InitMongoDB() {
 	if err2 != nil {
 		log.Fatal().Err(err2).Msg("Failed to connect to MongoDB: %v")
 	}
-	customerCollection = client.Database("zeropii").Collection("customer")
+	Database = client.Database("zeropii")
+	customerCollection = Database.Collection("customer")
+	auditCollection = Database.Collection("audit_log")
+	receiptCollection = Database.Collection("consent_receipts")
+
+	// Blind index lookups
+	_, err = customerCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email_index", Value: 1}}},
+		{Keys: bson.D{{Key: "phone_index", Value: 1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create customer blind indexes")
+	}
+
+	// Token vault deduplication
+	_, err = Database.Collection("token_vault").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "fingerprint", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create token vault index")
+	}
+}
+
+// CustomerCollection returns the customer collection initialized by InitMongoDB
+func CustomerCollection() *mongo.Collection {
+	return customerCollection
 }
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"zeropii/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TokenStore is the MongoDB implementation of utils.TokenStore. The token is
+// the document _id and the fingerprint has a unique index, created by
+// InitMongoDB, so both collisions surface as utils.ErrTokenExists.
+type TokenStore struct {
+	collection *mongo.Collection
+}
+
+// NewTokenStore stores vault entries in collection.
+func NewTokenStore(collection *mongo.Collection) *TokenStore {
+	return &TokenStore{collection: collection}
+}
+
+func (s *TokenStore) GetToken(ctx context.Context, token string) (*utils.TokenEntry, error) {
+	return s.findOne(ctx, bson.M{"_id": token})
+}
+
+func (s *TokenStore) FindTokenByFingerprint(ctx context.Context, fingerprint string) (*utils.TokenEntry, error) {
+	return s.findOne(ctx, bson.M{"fingerprint": fingerprint})
+}
+
+func (s *TokenStore) PutToken(ctx context.Context, entry *utils.TokenEntry) error {
+	_, err := s.collection.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return utils.ErrTokenExists
+	}
+	return err
+}
+
+// ForgetFingerprints sets the fingerprint of each of the owner's entries to
+// "forgotten:" and its token, which keeps the unique index satisfied.
+func (s *TokenStore) ForgetFingerprints(ctx context.Context, owner string) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"owner": owner}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"fingerprint": bson.M{"$concat": bson.A{"forgotten:", "$_id"}}}}},
+	})
+	return err
+}
+
+func (s *TokenStore) DeleteTokens(ctx context.Context, owner string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"owner": owner})
+	return err
+}
+
+func (s *TokenStore) findOne(ctx context.Context, filter bson.M) (*utils.TokenEntry, error) {
+	var entry utils.TokenEntry
+	err := s.collection.FindOne(ctx, filter).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
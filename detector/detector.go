@@ -0,0 +1,227 @@
+// Package detector finds PII in free text, such as notes, addresses typed
+// into the wrong field and log messages, which pii tags cannot describe.
+package detector
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"zeropii/utils"
+)
+
+// Type is the kind of PII a span holds.
+type Type string
+
+const (
+	TypeEmail    Type = "email"
+	TypePhone    Type = "phone"
+	TypePAN      Type = "pan"
+	TypeAadhaar  Type = "aadhaar"
+	TypePassport Type = "passport"
+	TypeCard     Type = "credit_card"
+	TypeIBAN     Type = "iban"
+	TypeIP       Type = "ip_address"
+	TypeDOB      Type = "dob"
+
+	// Found only from the names of JSON keys, having no value rules
+	TypeName    Type = "name"
+	TypeAddress Type = "address"
+	TypeSSN     Type = "ssn"
+)
+
+// Types lists every type the detector finds.
+var Types = []Type{
+	TypeEmail, TypePhone, TypePAN, TypeAadhaar, TypePassport, TypeCard, TypeIBAN, TypeIP, TypeDOB,
+	TypeName, TypeAddress, TypeSSN,
+}
+
+// categories is the PII category of each type, as used by pii tags.
+var categories = map[Type]utils.PIICategory{
+	TypeEmail:    utils.CategoryEmail,
+	TypePhone:    utils.CategoryPhone,
+	TypePAN:      utils.CategoryNationalID,
+	TypeAadhaar:  utils.CategoryNationalID,
+	TypePassport: utils.CategoryPassport,
+	TypeCard:     utils.CategoryFinancial,
+	TypeIBAN:     utils.CategoryFinancial,
+	TypeIP:       utils.CategoryOther,
+	TypeDOB:      utils.CategoryDOB,
+	TypeName:     utils.CategoryName,
+	TypeAddress:  utils.CategoryAddress,
+	TypeSSN:      utils.CategoryNationalID,
+}
+
+// Category returns the PII category of values of type t, so they can be
+// masked and governed like tagged fields of that category.
+func (t Type) Category() utils.PIICategory {
+	if category, ok := categories[t]; ok {
+		return category
+	}
+	return utils.CategoryOther
+}
+
+// Span is PII found in a text. Start and End are byte offsets, so the PII is
+// text[Start:End].
+type Span struct {
+	Type  Type   `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Value string `json:"value"`
+	// Confidence is how likely the span is PII of Type, between 0 and 1.
+	// Checksums and surrounding keywords such as "passport" raise it.
+	Confidence float64 `json:"confidence"`
+}
+
+// Detector scans text for the PII of some types.
+type Detector struct {
+	types         map[Type]bool
+	rules         []*rule
+	minConfidence float64
+}
+
+// New returns a detector of the given types, or of all types if none are given.
+func New(types ...Type) *Detector {
+	if len(types) == 0 {
+		types = Types
+	}
+	d := &Detector{types: map[Type]bool{}}
+	for _, t := range types {
+		d.types[t] = true
+	}
+	for _, r := range rules {
+		if d.types[r.typ] {
+			d.rules = append(d.rules, r)
+		}
+	}
+	return d
+}
+
+// WithMinConfidence returns a copy of d that drops spans less likely than
+// confidence to be PII.
+func (d *Detector) WithMinConfidence(confidence float64) *Detector {
+	copied := *d
+	copied.minConfidence = confidence
+	return &copied
+}
+
+var defaultDetector = New()
+
+// Detect returns the PII of every type found in text.
+func Detect(text string) []Span {
+	return defaultDetector.Detect(text)
+}
+
+// Detect returns the PII found in text, in order of position. Where
+// candidates overlap, such as a number that could be a phone number or part
+// of a card number, the most likely one is kept.
+func (d *Detector) Detect(text string) []Span {
+	var candidates []Span
+	for _, r := range d.rules {
+		for _, loc := range r.candidates(text) {
+			start, end := loc[0], loc[1]
+			if !bounded(text, start, end) {
+				continue
+			}
+			confidence := r.score(text[start:end])
+			if confidence == 0 {
+				continue
+			}
+			if r.keywords != nil && confidence < r.withKeyword && hasKeyword(text[:start], r.keywords) {
+				confidence = r.withKeyword
+			}
+			if confidence < d.minConfidence {
+				continue
+			}
+			candidates = append(candidates, Span{Type: r.typ, Start: start, End: end, Value: text[start:end], Confidence: confidence})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		if a.End-a.Start != b.End-b.Start {
+			return a.End-a.Start > b.End-b.Start
+		}
+		return a.Start < b.Start
+	})
+	spans := []Span{}
+	for _, candidate := range candidates {
+		overlaps := false
+		for _, span := range spans {
+			if candidate.Start < span.End && span.Start < candidate.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			spans = append(spans, candidate)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans
+}
+
+// bounded reports whether text[start:end] is not part of a longer word or
+// number
+func bounded(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	return !isWordRune(before) && !isWordRune(after)
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// keywordWindow is how many bytes before a match are searched for keywords
+const keywordWindow = 40
+
+// hasKeyword reports whether one of keywords, as a whole word, ends the text
+// before a match, ignoring case
+func hasKeyword(before string, keywords []string) bool {
+	before = strings.ToLower(before)
+	from := 0
+	if len(before) > keywordWindow {
+		from = len(before) - keywordWindow
+	}
+	for _, keyword := range keywords {
+		for i := from; ; {
+			at := strings.Index(before[i:], keyword)
+			if at < 0 {
+				break
+			}
+			start, end := i+at, i+at+len(keyword)
+			if bounded(before, start, end) {
+				return true
+			}
+			i = start + 1
+		}
+	}
+	return false
+}
+
+// rule finds the PII of one type.
+type rule struct {
+	typ     Type
+	pattern *regexp.Regexp
+	// find, if set, returns the locations of candidates in place of pattern,
+	// for PII that pattern alone would join to the numbers around it
+	find func(text string) [][]int
+	// score returns the confidence that a match is PII, or 0 to reject it
+	score func(match string) float64
+	// keywords raise the confidence of a match they precede to withKeyword
+	keywords    []string
+	withKeyword float64
+}
+
+// candidates returns the locations in text of the matches of r
+func (r *rule) candidates(text string) [][]int {
+	if r.find != nil {
+		return r.find(text)
+	}
+	return r.pattern.FindAllStringIndex(text, -1)
+}
@@ -0,0 +1,127 @@
+package detector_test
+
+import (
+	"testing"
+
+	"zeropii/detector"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text string
+		typ  detector.Type
+		want string
+	}{
+		{"mail ethan.hunt@example.co.in today", detector.TypeEmail, "ethan.hunt@example.co.in"},
+		{"reach me on +44 20 7946 0958", detector.TypePhone, "+44 20 7946 0958"},
+		{"mobile 98765-43210", detector.TypePhone, "98765-43210"},
+		{"call +91 98765 43210 after six", detector.TypePhone, "+91 98765 43210"},
+		{"PAN is ABCPE1234F.", detector.TypePAN, "ABCPE1234F"},
+		{"aadhaar 2345 6789 0124", detector.TypeAadhaar, "2345 6789 0124"},
+		{"passport no. N1234567", detector.TypePassport, "N1234567"},
+		{"paid with 4111 1111 1111 1111", detector.TypeCard, "4111 1111 1111 1111"},
+		{"IBAN: GB82 WEST 1234 5698 7654 32", detector.TypeIBAN, "GB82 WEST 1234 5698 7654 32"},
+		{"iban DE89370400440532013000", detector.TypeIBAN, "DE89370400440532013000"},
+		{"login from 203.0.113.9", detector.TypeIP, "203.0.113.9"},
+		{"login from 2001:db8::1", detector.TypeIP, "2001:db8::1"},
+		{"born on 23/07/1987", detector.TypeDOB, "23/07/1987"},
+		{"DOB: 23 jul 1987", detector.TypeDOB, "23 jul 1987"},
+	}
+	for _, tt := range tests {
+		spans := detector.Detect(tt.text)
+		if len(spans) != 1 || spans[0].Type != tt.typ || spans[0].Value != tt.want || tt.text[spans[0].Start:spans[0].End] != tt.want {
+			t.Errorf("Detect(%q) = %+v, want one %s span %q", tt.text, spans, tt.typ, tt.want)
+		}
+	}
+}
+
+func TestDetectRejectsInvalidChecksums(t *testing.T) {
+	for _, text := range []string{
+		"order 2345 6789 0123 shipped",     // Aadhaar failing Verhoeff
+		"ref 4111 1111 1111 1112",          // card failing Luhn
+		"IBAN GB82 WEST 1234 5698 7654 33", // IBAN failing mod-97
+		"version 300.1.2.4",
+		"meeting on 23/07/2999",
+		"ticket ABCPE1234FG and 98765432101",
+	} {
+		if spans := detector.Detect(text); len(spans) != 0 {
+			t.Errorf("Detect(%q) = %+v, want nothing", text, spans)
+		}
+	}
+}
+
+func TestDetectConfidence(t *testing.T) {
+	// Keywords before a match raise its confidence
+	bare := detector.Detect("ref N1234567")
+	keyword := detector.Detect("passport N1234567")
+	if len(bare) != 1 || len(keyword) != 1 || bare[0].Confidence >= keyword[0].Confidence {
+		t.Fatalf("passport confidence without keyword %+v, with keyword %+v", bare, keyword)
+	}
+	if spans := detector.New().WithMinConfidence(0.5).Detect("ref N1234567"); len(spans) != 0 {
+		t.Errorf("WithMinConfidence(0.5) kept %+v", spans)
+	}
+}
+
+func TestDetectAdjacentCards(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"cc 4111111111111111 5500000000000004", []string{"4111111111111111", "5500000000000004"}},
+		{"card 4111111111111111 9876543210", []string{"4111111111111111"}},
+		{"card 4111 1111 1111 1111 9876543210", []string{"4111 1111 1111 1111"}},
+		{"cards 4111-1111-1111-1111 and 5500 0000 0000 0004", []string{"4111-1111-1111-1111", "5500 0000 0000 0004"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, span := range detector.New(detector.TypeCard).Detect(tt.text) {
+			got = append(got, span.Value)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Detect(%q) found cards %q, want %q", tt.text, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Detect(%q) found cards %q, want %q", tt.text, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// The card is still found next to a phone number
+	spans := detector.Detect("card 4111111111111111 9876543210")
+	if len(spans) == 0 || spans[0].Type != detector.TypeCard || spans[0].Value != "4111111111111111" {
+		t.Errorf("Detect = %+v, want the card first", spans)
+	}
+}
+
+func TestDetectKeywordsAreWholeWords(t *testing.T) {
+	tests := []struct {
+		bare, text string
+	}{
+		{"ref ABCPE1234F", "company ABCPE1234F"},
+		{"ref 203.0.113.9", "shipping 203.0.113.9"},
+		{"ref 203.0.113.9", "description 203.0.113.9"},
+	}
+	for _, tt := range tests {
+		bare, spans := detector.Detect(tt.bare), detector.Detect(tt.text)
+		if len(bare) != 1 || len(spans) != 1 || spans[0].Confidence != bare[0].Confidence {
+			t.Errorf("Detect(%q) = %+v, want the confidence of %+v", tt.text, spans, bare)
+		}
+	}
+	if spans := detector.Detect("PAN: ABCPE1234F"); len(spans) != 1 || spans[0].Confidence != 0.95 {
+		t.Errorf("Detect after a keyword = %+v, want confidence 0.95", spans)
+	}
+}
+
+func TestDetectTypes(t *testing.T) {
+	text := "ethan@example.com, +91 98765 43210, 4111111111111111"
+	spans := detector.New(detector.TypeEmail, detector.TypeCard).Detect(text)
+	if len(spans) != 2 || spans[0].Type != detector.TypeEmail || spans[1].Type != detector.TypeCard {
+		t.Fatalf("Detect = %+v, want an email and a card", spans)
+	}
+	if all := detector.Detect(text); len(all) != 3 {
+		t.Fatalf("Detect = %+v, want three spans", all)
+	}
+}
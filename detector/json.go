@@ -0,0 +1,303 @@
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"zeropii/utils"
+)
+
+// Sources of a Finding.
+const (
+	SourceKey         = "key"           // the name of the value's key
+	SourceValue       = "value"         // the value itself
+	SourceKeyAndValue = "key_and_value" // both agree
+)
+
+// Finding is PII found in a JSON document. It does not hold the PII, so
+// findings can be logged and returned to callers.
+type Finding struct {
+	// Path is the JSON Pointer (RFC 6901) of the string or number holding
+	// the PII, e.g. "/contacts/0/email".
+	Path     string            `json:"path"`
+	Type     Type              `json:"type"`
+	Category utils.PIICategory `json:"category"`
+	Source   string            `json:"source"`
+	// Start and End are the byte offsets of the PII in the value; a finding
+	// from a key name covers the whole value.
+	Start      int     `json:"start"`
+	End        int     `json:"end"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Masker returns value, the PII of a finding, masked.
+type Masker func(finding Finding, value string) string
+
+// MaskFinding masks value with the default mask of the finding's category,
+// as a field tagged with that category would be masked.
+func MaskFinding(finding Finding, value string) string {
+	return utils.MaskValue(value, finding.Tag().Mask)
+}
+
+// Tag describes the PII of the finding as a pii tag of its category would,
+// with the category's default mask.
+func (f Finding) Tag() utils.PIIDescriptor {
+	tag, err := utils.ParsePIITag("category=" + string(f.Category))
+	if err != nil {
+		return utils.PIIDescriptor{Enabled: true, Category: utils.CategoryOther, Mask: utils.MaskFull}
+	}
+	return tag
+}
+
+// keyRule recognizes the keys of values of one type, by their normalized
+// name: lowercase with everything but letters and digits removed, so
+// "E-Mail", "e_mail" and "eMail" are all "email".
+type keyRule struct {
+	typ Type
+	// exact names, and substrings of names
+	exact    []string
+	contains []string
+	// confidence that a value under a matching key is PII
+	confidence float64
+}
+
+// keyRules are tried in order, so more specific names such as "ipaddress"
+// and "emailaddress" come before "address".
+var keyRules = []keyRule{
+	{typ: TypeEmail, contains: []string{"email"}, exact: []string{"mail"}, confidence: 0.8},
+	{typ: TypeIP, exact: []string{"ip", "ipaddr", "ipaddress", "clientip", "remoteip", "remoteaddr", "sourceip"}, confidence: 0.8},
+	{typ: TypePhone, contains: []string{"phone", "mobile", "msisdn", "telephone"}, exact: []string{"tel", "cell", "whatsapp"}, confidence: 0.8},
+	{typ: TypeAadhaar, contains: []string{"aadhaar", "aadhar"}, exact: []string{"uid", "uidai"}, confidence: 0.8},
+	{typ: TypePAN, exact: []string{"pan", "panno", "pannumber", "pancard", "panid"}, confidence: 0.8},
+	{typ: TypeSSN, contains: []string{"ssn", "socialsecurity"}, confidence: 0.8},
+	{typ: TypePassport, contains: []string{"passport"}, confidence: 0.8},
+	{typ: TypeCard, contains: []string{"cardnumber", "cardno", "creditcard", "debitcard", "ccnumber"}, exact: []string{"cc"}, confidence: 0.8},
+	{typ: TypeIBAN, contains: []string{"iban"}, confidence: 0.8},
+	{typ: TypeDOB, contains: []string{"dateofbirth", "birthdate", "birthday"}, exact: []string{"dob", "born"}, confidence: 0.8},
+	{typ: TypeName, exact: []string{"name", "fullname", "firstname", "lastname", "middlename", "surname", "givenname", "familyname", "customername", "holdername", "accountholder"}, confidence: 0.6},
+	{typ: TypeAddress, contains: []string{"address", "street"}, exact: []string{"city", "zip", "zipcode", "postcode", "postalcode", "pincode", "line1", "line2"}, confidence: 0.6},
+}
+
+// unconfirmedConfidence is the confidence of a value under a key naming a
+// type the value rules check, when the value fails them
+const unconfirmedConfidence = 0.5
+
+// keyRuleOf returns the rule matching key of a type d finds
+func (d *Detector) keyRuleOf(key string) (keyRule, bool) {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, key)
+	for _, rule := range keyRules {
+		if !d.types[rule.typ] {
+			continue
+		}
+		for _, exact := range rule.exact {
+			if name == exact {
+				return rule, true
+			}
+		}
+		for _, substring := range rule.contains {
+			if strings.Contains(name, substring) {
+				return rule, true
+			}
+		}
+	}
+	return keyRule{}, false
+}
+
+// hasValueRules reports whether d checks values of type t
+func (d *Detector) hasValueRules(t Type) bool {
+	for _, r := range d.rules {
+		if r.typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+var errTrailingData = errors.New("unexpected data after the JSON document")
+
+// ScanJSON returns the PII found in a JSON document of any shape.
+func ScanJSON(data []byte) ([]Finding, error) {
+	return defaultDetector.ScanJSON(data)
+}
+
+// RedactJSON returns a JSON document with the PII found in it masked by
+// mask, or by MaskFinding if mask is nil, and the findings.
+func RedactJSON(data []byte, mask Masker) ([]byte, []Finding, error) {
+	return defaultDetector.RedactJSON(data, mask)
+}
+
+// ScanJSON returns the PII found in the strings and numbers of a JSON
+// document, in document order with object keys sorted. Values are scanned
+// with the detector's rules, and values under keys such as "email" or
+// "ssn", or nested in objects and arrays under them, are reported whole.
+// Where key and value agree, the confidences of both are combined.
+func (d *Detector) ScanJSON(data []byte) ([]Finding, error) {
+	_, findings, err := d.walkJSON(data, nil)
+	return findings, err
+}
+
+// RedactJSON is ScanJSON, also returning the document with the PII masked by
+// mask, or by MaskFinding if mask is nil. PII found in part of a string is
+// masked in place, and numbers holding PII are replaced by masked strings.
+func (d *Detector) RedactJSON(data []byte, mask Masker) ([]byte, []Finding, error) {
+	if mask == nil {
+		mask = MaskFinding
+	}
+	doc, findings, err := d.walkJSON(data, mask)
+	if err != nil {
+		return nil, nil, err
+	}
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return redacted, findings, nil
+}
+
+// walkJSON decodes data and scans it, masking the PII found with mask unless
+// it is nil
+func (d *Detector) walkJSON(data []byte, mask Masker) (interface{}, []Finding, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as written, so long ones such as card numbers are not
+	// rounded and untouched numbers are written back unchanged
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, nil, errTrailingData
+	}
+
+	w := &jsonWalker{detector: d, mask: mask, findings: []Finding{}}
+	doc = w.walk(doc, "", nil)
+	return doc, w.findings, nil
+}
+
+type jsonWalker struct {
+	detector *Detector
+	mask     Masker
+	findings []Finding
+}
+
+// walk scans v at path, where key is the rule of the nearest enclosing key
+// that has one, and returns v, masked if the walker masks
+func (w *jsonWalker) walk(v interface{}, path string, key *keyRule) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			inner := key
+			if rule, ok := w.detector.keyRuleOf(name); ok {
+				inner = &rule
+			}
+			v[name] = w.walk(v[name], path+"/"+escapePointer(name), inner)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = w.walk(v[i], path+"/"+strconv.Itoa(i), key)
+		}
+	case string:
+		if masked, ok := w.scan(v, path, key); ok {
+			return masked
+		}
+	case json.Number:
+		if masked, ok := w.scan(v.String(), path, key); ok {
+			return masked
+		}
+	}
+	return v
+}
+
+// scan records the findings in value and returns value masked, and whether
+// it changed
+func (w *jsonWalker) scan(value, path string, key *keyRule) (string, bool) {
+	if strings.TrimSpace(value) == "" {
+		return value, false
+	}
+	d := w.detector
+	var findings []Finding
+	confirmed := false
+	for _, span := range d.Detect(value) {
+		finding := Finding{
+			Path:       path,
+			Type:       span.Type,
+			Category:   span.Type.Category(),
+			Source:     SourceValue,
+			Start:      span.Start,
+			End:        span.End,
+			Confidence: span.Confidence,
+		}
+		if key != nil && key.typ == span.Type {
+			confirmed = true
+			finding.Source = SourceKeyAndValue
+			finding.Confidence = combine(span.Confidence, key.confidence)
+		}
+		findings = append(findings, finding)
+	}
+
+	// A value under a key that names PII is PII as a whole, whatever its
+	// value rules found in it
+	var whole *Finding
+	if key != nil && !confirmed {
+		confidence := key.confidence
+		if d.hasValueRules(key.typ) {
+			confidence = unconfirmedConfidence
+		}
+		if confidence >= d.minConfidence {
+			whole = &Finding{
+				Path:       path,
+				Type:       key.typ,
+				Category:   key.typ.Category(),
+				Source:     SourceKey,
+				End:        len(value),
+				Confidence: confidence,
+			}
+			findings = append([]Finding{*whole}, findings...)
+		}
+	}
+	w.findings = append(w.findings, findings...)
+
+	if w.mask == nil || len(findings) == 0 {
+		return value, false
+	}
+	if whole != nil {
+		return w.mask(*whole, value), true
+	}
+	// Spans do not overlap; mask them from the end so offsets stay valid
+	masked := value
+	for i := len(findings) - 1; i >= 0; i-- {
+		f := findings[i]
+		masked = masked[:f.Start] + w.mask(f, masked[f.Start:f.End]) + masked[f.End:]
+	}
+	return masked, true
+}
+
+// combine returns the confidence that a value is PII when two independent
+// signals, its key and its value, say so with confidences a and b
+func combine(a, b float64) float64 {
+	return math.Round((1-(1-a)*(1-b))*100) / 100
+}
+
+// escapePointer escapes a key as a JSON Pointer reference token
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
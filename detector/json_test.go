@@ -0,0 +1,122 @@
+package detector_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeropii/detector"
+)
+
+const scanDocument = `{
+	"customer": {
+		"E-Mail": "ethan.hunt@example.com",
+		"full_name": "Ethan Hunt",
+		"phones": ["+91 98765 43210", 9876543210],
+		"ssn": "078-05-1120",
+		"a/b": "order 42"
+	},
+	"notes": "PAN ABCPE1234F, card 4111 1111 1111 1111",
+	"amount": 1250,
+	"verified": true
+}`
+
+func TestScanJSON(t *testing.T) {
+	findings, err := detector.ScanJSON([]byte(scanDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type found struct {
+		path   string
+		typ    detector.Type
+		source string
+	}
+	var got []found
+	for _, f := range findings {
+		got = append(got, found{f.Path, f.Type, f.Source})
+		if f.Confidence <= 0 || f.Confidence > 1 {
+			t.Errorf("finding %+v has confidence out of range", f)
+		}
+	}
+	want := []found{
+		{"/customer/E-Mail", detector.TypeEmail, detector.SourceKeyAndValue},
+		{"/customer/full_name", detector.TypeName, detector.SourceKey},
+		{"/customer/phones/0", detector.TypePhone, detector.SourceKeyAndValue},
+		{"/customer/phones/1", detector.TypePhone, detector.SourceKeyAndValue},
+		{"/customer/ssn", detector.TypeSSN, detector.SourceKey},
+		{"/notes", detector.TypePAN, detector.SourceValue},
+		{"/notes", detector.TypeCard, detector.SourceValue},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ScanJSON found\n%+v\nwant\n%+v", got, want)
+	}
+
+	// A key and a value agreeing are more convincing than the value alone
+	email := detector.Detect("ethan.hunt@example.com")[0]
+	if findings[0].Confidence <= email.Confidence {
+		t.Errorf("key and value confidence %v is not above value confidence %v", findings[0].Confidence, email.Confidence)
+	}
+	if notes := findings[5]; notes.Start != 4 || notes.End != 14 {
+		t.Errorf("PAN finding at %d:%d, want 4:14", notes.Start, notes.End)
+	}
+}
+
+func TestScanJSONEscapesPointers(t *testing.T) {
+	findings, err := detector.ScanJSON([]byte(`{"a/b": {"x~y": ["ethan@example.com"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Path != "/a~1b/x~0y/0" {
+		t.Fatalf("ScanJSON = %+v, want one finding at /a~1b/x~0y/0", findings)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	redacted, findings, err := detector.RedactJSON([]byte(scanDocument), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 7 {
+		t.Fatalf("RedactJSON found %d findings, want 7", len(findings))
+	}
+
+	var doc struct {
+		Customer struct {
+			Email    string        `json:"E-Mail"`
+			FullName string        `json:"full_name"`
+			Phones   []interface{} `json:"phones"`
+			SSN      string        `json:"ssn"`
+			Other    string        `json:"a/b"`
+		} `json:"customer"`
+		Notes    string      `json:"notes"`
+		Amount   json.Number `json:"amount"`
+		Verified bool        `json:"verified"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(redacted)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	c := doc.Customer
+	if c.Email != "e*********@example.com" || c.FullName != "REDACTED" || c.SSN != "REDACTED" || c.Other != "order 42" {
+		t.Errorf("customer redacted to %+v", c)
+	}
+	if !reflect.DeepEqual(c.Phones, []interface{}{"*******210", "*******210"}) {
+		t.Errorf("phones redacted to %v", c.Phones)
+	}
+	if doc.Notes != "PAN REDACTED, card REDACTED" {
+		t.Errorf("notes redacted to %q", doc.Notes)
+	}
+	if doc.Amount != "1250" || !doc.Verified {
+		t.Errorf("values without PII changed: %+v", doc)
+	}
+}
+
+func TestScanJSONRejectsInvalidDocuments(t *testing.T) {
+	for _, data := range []string{``, `{"a": }`, `{} {}`} {
+		if _, err := detector.ScanJSON([]byte(data)); err == nil {
+			t.Errorf("ScanJSON(%q) accepted an invalid document", data)
+		}
+	}
+}
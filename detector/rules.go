@@ -0,0 +1,274 @@
+package detector
+
+import (
+	"math/big"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"zeropii/utils"
+)
+
+// rules are the rules of every type, tried in this order
+var rules = []*rule{
+	{
+		typ:     TypeEmail,
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+		score:   constant(0.95),
+	},
+	{
+		// E.164: a + and 8 to 15 digits, optionally grouped with spaces or dashes
+		typ:         TypePhone,
+		pattern:     regexp.MustCompile(`\+[1-9](?:[ \-]?[0-9]){7,14}`),
+		score:       constant(0.85),
+		keywords:    phoneKeywords,
+		withKeyword: 0.95,
+	},
+	{
+		// Indian mobile numbers start with 6 to 9, optionally after +91, 91 or 0
+		typ:         TypePhone,
+		pattern:     regexp.MustCompile(`(?:(?:\+91|91|0)[ \-]?)?[6-9][0-9]{4}[ \-]?[0-9]{5}`),
+		score:       scoreIndianPhone,
+		keywords:    phoneKeywords,
+		withKeyword: 0.9,
+	},
+	{
+		// The fourth character of a PAN is the type of holder
+		typ:         TypePAN,
+		pattern:     regexp.MustCompile(`[A-Z]{3}[ABCFGHLJPT][A-Z][0-9]{4}[A-Z]`),
+		score:       constant(0.85),
+		keywords:    []string{"pan", "permanent account"},
+		withKeyword: 0.95,
+	},
+	{
+		typ:         TypeAadhaar,
+		pattern:     regexp.MustCompile(`[2-9][0-9]{3}[ \-]?[0-9]{4}[ \-]?[0-9]{4}`),
+		score:       scoreAadhaar,
+		keywords:    []string{"aadhaar", "aadhar", "uidai", "uid"},
+		withKeyword: 0.95,
+	},
+	{
+		// Indian passports: a letter and seven digits. Other numbers look
+		// the same, so the keyword matters most
+		typ:         TypePassport,
+		pattern:     regexp.MustCompile(`[A-Z][0-9]{7}`),
+		score:       constant(0.4),
+		keywords:    []string{"passport"},
+		withKeyword: 0.9,
+	},
+	{
+		// Numbers written next to a card are often grouped like it, so the
+		// runs of groups are split into candidates
+		typ:         TypeCard,
+		pattern:     cardRun,
+		find:        findCards,
+		score:       scoreCard,
+		keywords:    []string{"card", "credit", "debit", "visa", "mastercard", "amex", "rupay"},
+		withKeyword: 0.95,
+	},
+	{
+		// Written compact or in groups of four
+		typ:     TypeIBAN,
+		pattern: regexp.MustCompile(`[A-Z]{2}[0-9]{2}(?:[A-Z0-9]{11,30}|(?: [A-Z0-9]{4}){2,7}(?: [A-Z0-9]{1,4})?)`),
+		score:   scoreIBAN,
+	},
+	{
+		typ:         TypeIP,
+		pattern:     regexp.MustCompile(`[0-9]{1,3}(?:\.[0-9]{1,3}){3}|[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`),
+		score:       scoreIP,
+		keywords:    []string{"ip", "address", "host", "client"},
+		withKeyword: 0.9,
+	},
+	{
+		typ:         TypeDOB,
+		pattern:     regexp.MustCompile(`(?i)[0-9]{1,2}[/\-.][0-9]{1,2}[/\-.][0-9]{4}|[0-9]{4}-[0-9]{2}-[0-9]{2}|[0-9]{1,2} (?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?,? [0-9]{4}`),
+		score:       scoreDOB,
+		keywords:    []string{"dob", "d.o.b", "birth", "born"},
+		withKeyword: 0.9,
+	},
+}
+
+var phoneKeywords = []string{"phone", "mobile", "mob", "tel", "call", "contact", "whatsapp", "sms"}
+
+func constant(confidence float64) func(string) float64 {
+	return func(string) float64 { return confidence }
+}
+
+// digits returns the digits of s
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// scoreIndianPhone scores a bare ten digit number lower than one with a
+// country or trunk prefix
+func scoreIndianPhone(match string) float64 {
+	if len(digits(match)) > 10 {
+		return 0.85
+	}
+	return 0.6
+}
+
+func scoreAadhaar(match string) float64 {
+	if !consistentSeparators(match) || !utils.VerhoeffValid(digits(match)) {
+		return 0
+	}
+	return 0.8
+}
+
+// consistentSeparators reports whether the groups of a number are all
+// separated by the same character, or not at all
+func consistentSeparators(match string) bool {
+	var separator rune
+	for _, r := range match {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+		if separator != 0 && r != separator {
+			return false
+		}
+		separator = r
+	}
+	return true
+}
+
+// cardRun matches a run of digit groups separated by single spaces or dashes
+var cardRun = regexp.MustCompile(`[0-9]+(?:[ \-][0-9]+)*`)
+
+var digitGroup = regexp.MustCompile(`[0-9]+`)
+
+// findCards returns every stretch of consecutive groups of a run holding 13
+// to 19 digits, as a card number could be any of them
+func findCards(text string) [][]int {
+	var locs [][]int
+	for _, run := range cardRun.FindAllStringIndex(text, -1) {
+		var groups [][]int
+		for _, group := range digitGroup.FindAllStringIndex(text[run[0]:run[1]], -1) {
+			groups = append(groups, []int{run[0] + group[0], run[0] + group[1]})
+		}
+		for i := range groups {
+			count := 0
+			for j := i; j < len(groups); j++ {
+				count += groups[j][1] - groups[j][0]
+				if count > 19 {
+					break
+				}
+				if count >= 13 {
+					locs = append(locs, []int{groups[i][0], groups[j][1]})
+				}
+			}
+		}
+	}
+	return locs
+}
+
+// scoreCard accepts numbers passing the Luhn check, and is more confident of
+// those starting like the cards of a known network
+func scoreCard(match string) float64 {
+	number := digits(match)
+	if len(number) < 13 || len(number) > 19 || !consistentSeparators(match) || !luhnValid(number) {
+		return 0
+	}
+	for _, prefix := range cardPrefixes {
+		if strings.HasPrefix(number, prefix) {
+			return 0.9
+		}
+	}
+	return 0.5
+}
+
+// cardPrefixes start the numbers of Visa, Mastercard, American Express,
+// Discover, JCB, Diners Club and RuPay cards
+var cardPrefixes = []string{
+	"4", "51", "52", "53", "54", "55", "22", "23", "24", "25", "26", "27",
+	"34", "37", "6011", "65", "64", "35", "36", "300", "301", "302", "303",
+	"304", "305", "38", "60", "81", "82", "508", "353", "356",
+}
+
+// luhnValid reports whether number, a string of digits, passes the Luhn check
+func luhnValid(number string) bool {
+	sum := 0
+	for i := 0; i < len(number); i++ {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// scoreIBAN accepts IBANs passing the ISO 13616 mod-97 check
+func scoreIBAN(match string) float64 {
+	iban := strings.ReplaceAll(match, " ", "")
+	if len(iban) < 15 || len(iban) > 34 || !ibanValid(iban) {
+		return 0
+	}
+	return 0.95
+}
+
+// ibanValid moves the country code and check digits to the end, replaces
+// letters with 10 to 35 and checks that the number is 1 modulo 97
+func ibanValid(iban string) bool {
+	var numeric strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			numeric.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// scoreIP accepts IPv4 and IPv6 addresses, and is less confident of
+// loopback and unspecified addresses, which identify no one
+func scoreIP(match string) float64 {
+	addr, err := netip.ParseAddr(match)
+	if err != nil {
+		return 0
+	}
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return 0.3
+	}
+	if addr.Is4() {
+		return 0.8
+	}
+	return 0.85
+}
+
+// dobLayouts are the date formats scoreDOB parses
+var dobLayouts = []string{
+	"02/01/2006", "2/1/2006", "02-01-2006", "2-1-2006", "02.01.2006", "2.1.2006",
+	"2006-01-02", "2 Jan 2006", "2 January 2006", "2 Jan. 2006", "2 Jan, 2006", "2 January, 2006",
+}
+
+// scoreDOB accepts dates a living person could have been born on. Without a
+// keyword such as "born" a date is as likely to be any other date. Month
+// names are parsed ignoring case.
+func scoreDOB(match string) float64 {
+	now := time.Now()
+	for _, layout := range dobLayouts {
+		t, err := time.Parse(layout, match)
+		if err != nil {
+			continue
+		}
+		if t.After(now) || t.Year() < now.Year()-120 {
+			return 0
+		}
+		return 0.3
+	}
+	return 0
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"zeropii/db"
+	"zeropii/models"
+	"zeropii/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxDocumentSize is the largest document scan accepted for upload
+const maxDocumentSize = 20 << 20
+
+// Upload a KYC document scan; it is stored encrypted and attached to the
+// customer. Uploads are gated like downloads: admin only, with a purpose
+// allowing documents and the customer's consent
+func uploadDocument(c *gin.Context) {
+	id := c.Param("id")
+	viewer, ok := viewerFrom(c)
+	if !ok {
+		return
+	}
+	if !canViewDocuments(viewer) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role and a purpose allowing documents are required"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart file field \"file\" is required"})
+		return
+	}
+	if file.Size > maxDocumentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document is too large"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var customer models.Customer
+	err = customerCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&customer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("operation", "upload_document").Msg("Failed to load customer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+	if customer.ShreddedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+		return
+	}
+	if customer.DataKey == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer predates per-customer keys; run re-encryption first"})
+		return
+	}
+	viewer.Consented = models.HasConsent(customer.Consents, viewer.Application, viewer.Purpose, time.Now())
+	if consentRequired(viewer) {
+		refuseWithoutConsent(c, "upload_document", id, viewer)
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		log.Error().Err(err).Str("operation", "upload_document").Msg("Failed to open uploaded file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+	defer src.Close()
+
+	ref, err := documents.Upload(ctx, id, src, file.Size, file.Header.Get("Content-Type"))
+	if err != nil {
+		if errors.Is(err, utils.ErrKeyShredded) {
+			c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+			return
+		}
+		log.Error().Err(err).Str("operation", "upload_document").Str("customer_id", id).Msg("Failed to store document")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+
+	doc := models.Docs{
+		DocType:        c.PostForm("doc_type"),
+		ExpirationDate: c.PostForm("expiration_date"),
+		IssuedCountry:  c.PostForm("issued_country"),
+		ImageUrl:       ref,
+	}
+	_, err = customerCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "shredded_at": bson.M{"$exists": false}},
+		bson.M{"$push": bson.M{"documents": doc}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		log.Error().Err(err).Str("operation", "upload_document").Str("customer_id", id).Msg("Failed to attach document")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+
+	_, documentID, _ := utils.ParseDocumentRef(ref)
+	event := &db.AuditEvent{
+		Operation:   "upload_document",
+		CustomerID:  id,
+		Role:        viewer.Role,
+		Purpose:     viewer.Purpose,
+		Application: viewer.Application,
+		Outcome:     "stored",
+		Details:     gin.H{"document_id": documentID, "size": file.Size},
+	}
+	// The scan is already stored, so a failed audit write is logged rather
+	// than reported as a failed upload
+	if err := db.RecordAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("operation", "upload_document").Str("customer_id", id).Msg("Failed to record audit event")
+	}
+
+	log.Info().
+		Str("operation", "upload_document").
+		Str("customer_id", id).
+		Str("document_id", documentID).
+		Int64("size", file.Size).
+		Msg("Document stored")
+
+	c.JSON(http.StatusCreated, gin.H{"document_id": documentID, "image_url": documentURL(id, documentID)})
+}
+
+// Stream a decrypted document scan (admin only)
+func getDocument(c *gin.Context) {
+	id := c.Param("id")
+	viewer := utils.Viewer{
+		Role:        c.GetHeader("x-viewer-role"),
+		Purpose:     c.GetHeader("x-access-purpose"),
+		Application: c.GetHeader("x-application-name"),
+	}
+	if !canViewDocuments(viewer) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role and a purpose allowing documents are required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// Scans cannot be masked, so they are only released with consent
+	consents, err := db.FindCustomerConsents(ctx, id)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", "get_document").Str("customer_id", id).Msg("Failed to load customer consents")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+		return
+	}
+	viewer.Consented = models.HasConsent(consents, viewer.Application, viewer.Purpose, time.Now())
+	if consentRequired(viewer) {
+		refuseWithoutConsent(c, "view_document", id, viewer)
+		return
+	}
+
+	ref := utils.DocumentRef(id, c.Param("documentID"))
+	document, err := documents.Open(ctx, ref)
+	switch {
+	case errors.Is(err, utils.ErrBlobNotFound), errors.Is(err, utils.ErrInvalidBlobKey), errors.Is(err, utils.ErrInvalidDocumentRef):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	case errors.Is(err, utils.ErrKeyShredded):
+		c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", "get_document").Str("customer_id", id).Msg("Failed to open document")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+		return
+	}
+	defer document.Body.Close()
+
+	event := &db.AuditEvent{
+		Operation:   "view_document",
+		CustomerID:  id,
+		Role:        viewer.Role,
+		Purpose:     viewer.Purpose,
+		Application: viewer.Application,
+		Outcome:     "revealed",
+		Details:     gin.H{"document_id": c.Param("documentID")},
+	}
+	if err := db.RecordAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("operation", "get_document").Msg("Failed to record audit event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+		return
+	}
+
+	contentType := document.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// A corrupt blob fails mid-stream; the client then receives fewer bytes
+	// than the Content-Length and must discard the download
+	c.DataFromReader(http.StatusOK, document.Size, contentType, document.Body, nil)
+}
+
+// canViewDocuments returns true if the viewer may download document scans:
+// admins whose purpose justifies access to documents
+func canViewDocuments(viewer utils.Viewer) bool {
+	return viewer.Role == "admin" && utils.CurrentAccessPolicy().Allows(viewer.Purpose, utils.CategoryDocument)
+}
+
+// documentURL is the API path serving a customer's document
+func documentURL(customerID, documentID string) string {
+	return "/api/v1/onboarding/customers/" + customerID + "/documents/" + documentID
+}
+
+// resolveDocumentRefs replaces internal document references with download
+// URLs for viewers allowed to view documents with the customer's consent, and
+// hides them from everyone else
+func resolveDocumentRefs(customer *models.Customer, viewer utils.Viewer) {
+	for i := range customer.Documents {
+		doc := &customer.Documents[i]
+		if !canViewDocuments(viewer) || consentRequired(viewer) {
+			doc.ImageUrl = ""
+			continue
+		}
+		if customerID, documentID, err := utils.ParseDocumentRef(doc.ImageUrl); err == nil {
+			doc.ImageUrl = documentURL(customerID, documentID)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"zeropii/models"
+	"zeropii/utils"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reencryptJobID = "customer_reencryption"
+
+var ErrJobRunning = errors.New("re-encryption job is already running")
+
+// ReencryptProgress is the checkpointed state of a re-encryption run.
+type ReencryptProgress struct {
+	ID        string    `json:"-" bson:"_id"`
+	KeyID     string    `json:"key_id" bson:"key_id"`
+	KEKKeyID  string    `json:"kek_key_id" bson:"kek_key_id"`
+	LastID    string    `json:"last_id" bson:"last_id"`
+	Scanned   int64     `json:"scanned" bson:"scanned"`
+	Migrated  int64     `json:"migrated" bson:"migrated"`
+	Conflicts int64     `json:"conflicts" bson:"conflicts"`
+	Failed    int64     `json:"failed" bson:"failed"`
+	Running   bool      `json:"running" bson:"-"`
+	Completed bool      `json:"completed" bson:"completed"`
+	StartedAt time.Time `json:"started_at" bson:"started_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Reencryptor migrates customer documents whose PII is still encrypted
+// directly with a keyring key onto envelope encryption with a per-record data
+// key, moves data keys onto the customer's own key, and re-wraps customer
+// keys whose KEK has since been rotated. Fields of migrated customers still
+// encrypted with a retired keyring key, such as deterministic fields, or not
+// yet bound to their record and field, are re-encrypted with the active key
+// and bound, so ENCRYPTION_REQUIRE_BINDING can be turned on once a run
+// completes. It walks the collection in _id order one batch at
+// a time and checkpoints after every batch, so a restarted run resumes where
+// the previous one stopped.
+type Reencryptor struct {
+	customers   *mongo.Collection
+	checkpoints *mongo.Collection
+	keyring     *utils.Keyring
+	keys        *utils.CustomerKeyManager
+	tokens      *utils.TokenVault
+	batchSize   int64
+
+	mu       sync.Mutex
+	progress ReencryptProgress
+	running  bool
+}
+
+// NewReencryptor creates a re-encryption job over the customer collection,
+// storing its checkpoint in the checkpoints collection. Tokenized fields of
+// migrated customers are swapped for tokens from the tokens vault.
+func NewReencryptor(customers, checkpoints *mongo.Collection, keyring *utils.Keyring, keys *utils.CustomerKeyManager, tokens *utils.TokenVault, batchSize int64) *Reencryptor {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &Reencryptor{
+		customers:   customers,
+		checkpoints: checkpoints,
+		keyring:     keyring,
+		keys:        keys,
+		tokens:      tokens,
+		batchSize:   batchSize,
+	}
+}
+
+// Progress returns a snapshot of the current or last run.
+func (r *Reencryptor) Progress() ReencryptProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	progress := r.progress
+	progress.Running = r.running
+	return progress
+}
+
+// Start runs the job in the background. It returns ErrJobRunning if a run is
+// already in progress.
+func (r *Reencryptor) Start(ctx context.Context) error {
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return ErrJobRunning
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	go func() {
+		if err := r.run(ctx); err != nil {
+			log.Error().
+				Err(err).
+				Str("operation", "reencrypt_customers").
+				Msg("Re-encryption job stopped")
+		}
+	}()
+	return nil
+}
+
+// Run runs the job to completion in the calling goroutine.
+func (r *Reencryptor) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return ErrJobRunning
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	return r.run(ctx)
+}
+
+func (r *Reencryptor) run(ctx context.Context) error {
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	progress, err := r.loadCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	r.setProgress(progress)
+
+	log.Info().
+		Str("operation", "reencrypt_customers").
+		Str("key_id", progress.KeyID).
+		Str("kek_key_id", progress.KEKKeyID).
+		Str("resume_after", progress.LastID).
+		Msg("Re-encryption job started")
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := r.nextBatch(ctx, progress.LastID)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			r.migrate(ctx, &batch[i], &progress)
+			progress.LastID = batch[i].ID
+		}
+
+		progress.UpdatedAt = time.Now()
+		if err := r.saveCheckpoint(ctx, progress); err != nil {
+			return err
+		}
+		r.setProgress(progress)
+
+		log.Info().
+			Str("operation", "reencrypt_customers").
+			Int64("scanned", progress.Scanned).
+			Int64("migrated", progress.Migrated).
+			Int64("conflicts", progress.Conflicts).
+			Int64("failed", progress.Failed).
+			Msg("Re-encryption batch complete")
+	}
+
+	progress.Completed = true
+	progress.UpdatedAt = time.Now()
+	if err := r.saveCheckpoint(ctx, progress); err != nil {
+		return err
+	}
+	r.setProgress(progress)
+
+	log.Info().
+		Str("operation", "reencrypt_customers").
+		Int64("scanned", progress.Scanned).
+		Int64("migrated", progress.Migrated).
+		Int64("conflicts", progress.Conflicts).
+		Int64("failed", progress.Failed).
+		Msg("Re-encryption job finished")
+	return nil
+}
+
+// migrate re-encrypts a single customer and writes it back only if nobody
+// else modified it since it was read.
+func (r *Reencryptor) migrate(ctx context.Context, customer *models.Customer, progress *ReencryptProgress) {
+	progress.Scanned++
+
+	// Erased customers stay undecryptable
+	if customer.ShreddedAt != nil {
+		return
+	}
+
+	provider, err := r.keys.ProviderFor(ctx, customer.ID)
+	if err != nil {
+		if !errors.Is(err, utils.ErrKeyShredded) {
+			progress.Failed++
+			log.Error().
+				Err(err).
+				Str("operation", "reencrypt_customer").
+				Str("customer_id", customer.ID).
+				Msg("Failed to load customer key")
+		}
+		return
+	}
+	if _, err := r.keys.Rewrap(ctx, customer.ID); err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to rewrap customer key")
+		return
+	}
+
+	if customer.DataKey != nil {
+		rewrapped, ok := r.rewrap(ctx, customer, provider, progress)
+		if !ok {
+			return
+		}
+		rotated, ok := r.rotateFields(ctx, customer, provider, progress)
+		if !ok || !(rewrapped || rotated) {
+			return
+		}
+	} else if !r.encryptWithDataKey(ctx, customer, provider, progress) {
+		return
+	}
+
+	filter := bson.M{"_id": customer.ID, "version": customer.Version}
+	if customer.Version == 0 {
+		filter["version"] = bson.M{"$exists": false}
+	}
+	customer.Version++
+
+	result, err := r.customers.ReplaceOne(ctx, filter, customer)
+	if err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to write re-encrypted customer")
+		return
+	}
+	if result.MatchedCount == 0 {
+		// Modified concurrently; the writer already used a data key
+		progress.Conflicts++
+		log.Warn().
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Customer changed during re-encryption, skipping")
+		return
+	}
+	progress.Migrated++
+}
+
+// rewrap moves the customer's data key onto the customer key. It reports
+// whether the data key changed, and false for ok if it failed.
+func (r *Reencryptor) rewrap(ctx context.Context, customer *models.Customer, provider utils.KeyProvider, progress *ReencryptProgress) (changed, ok bool) {
+	rewrapped, err := provider.RewrapDataKey(ctx, customer.DataKey)
+	if err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to rewrap customer data key")
+		return false, false
+	}
+	if rewrapped.KeyID == customer.DataKey.KeyID {
+		return false, true
+	}
+	customer.DataKey = rewrapped
+	return true, true
+}
+
+// rotateFields re-encrypts the fields of a customer with a data key that are
+// still encrypted with a retired keyring key or unbound. It reports whether any field
+// changed, and false for ok if it failed.
+func (r *Reencryptor) rotateFields(ctx context.Context, customer *models.Customer, provider utils.KeyProvider, progress *ReencryptProgress) (changed, ok bool) {
+	tokenizing := r.keyring.WithTokenizer(r.tokens.ForOwner(ctx, customer.ID))
+	changed, err := utils.RotateRecordPII(ctx, customer, customer.DataKey, tokenizing, provider)
+	if err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to rotate customer PII")
+		return false, false
+	}
+	return changed, true
+}
+
+// encryptWithDataKey moves a customer encrypted directly with a keyring key
+// onto a fresh per-record data key.
+func (r *Reencryptor) encryptWithDataKey(ctx context.Context, customer *models.Customer, provider utils.KeyProvider, progress *ReencryptProgress) bool {
+	if err := customer.DecryptPII(r.keyring.AllowingUnbound()); err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to decrypt customer PII")
+		return false
+	}
+	tokenizing := r.keyring.WithTokenizer(r.tokens.ForOwner(ctx, customer.ID))
+	dataKey, err := utils.EncryptRecordPII(ctx, customer, tokenizing, provider)
+	if err != nil {
+		progress.Failed++
+		log.Error().
+			Err(err).
+			Str("operation", "reencrypt_customer").
+			Str("customer_id", customer.ID).
+			Msg("Failed to re-encrypt customer PII")
+		return false
+	}
+	customer.DataKey = dataKey
+	return true
+}
+
+func (r *Reencryptor) nextBatch(ctx context.Context, after string) ([]models.Customer, error) {
+	filter := bson.M{}
+	if after != "" {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(r.batchSize).
+		SetBatchSize(int32(r.batchSize))
+
+	cur, err := r.customers.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to close cursor")
+		}
+	}(cur, ctx)
+
+	var batch []models.Customer
+	if err := cur.All(ctx, &batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// loadCheckpoint resumes an unfinished run for the current active keyring key
+// and KEK, or starts a fresh one. Rotating either one restarts the run.
+func (r *Reencryptor) loadCheckpoint(ctx context.Context) (ReencryptProgress, error) {
+	activeKeyID := r.keyring.ActiveKeyID()
+	kekKeyID, err := r.keys.ActiveKEKID(ctx)
+	if err != nil {
+		return ReencryptProgress{}, err
+	}
+
+	var checkpoint ReencryptProgress
+	err = r.checkpoints.FindOne(ctx, bson.M{"_id": reencryptJobID}).Decode(&checkpoint)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return checkpoint, err
+	}
+	if err == nil && checkpoint.KeyID == activeKeyID && checkpoint.KEKKeyID == kekKeyID && !checkpoint.Completed {
+		return checkpoint, nil
+	}
+
+	now := time.Now()
+	return ReencryptProgress{
+		ID:        reencryptJobID,
+		KeyID:     activeKeyID,
+		KEKKeyID:  kekKeyID,
+		StartedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+func (r *Reencryptor) saveCheckpoint(ctx context.Context, progress ReencryptProgress) error {
+	_, err := r.checkpoints.ReplaceOne(ctx, bson.M{"_id": reencryptJobID}, progress, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *Reencryptor) setProgress(progress ReencryptProgress) {
+	r.mu.Lock()
+	r.progress = progress
+	r.mu.Unlock()
+}
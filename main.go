@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 	"zeropii/db"
+	"zeropii/jobs"
 	"zeropii/models"
 	"zeropii/utils"
 
@@ -21,6 +26,13 @@ import (
 
 var client *mongo.Client
 var customerCollection *mongo.Collection
+var keyring *utils.Keyring
+var keyProvider utils.KeyProvider
+var customerKeys *utils.CustomerKeyManager
+var tokenVault *utils.TokenVault
+var documents *utils.DocumentStore
+var reencryptor *jobs.Reencryptor
+var receiptIssuer *utils.ReceiptIssuer
 
 //var encryptionKey = os.Getenv("ENCRYPTION_KEY")
 
@@ -48,9 +60,38 @@ func main() {
 
 	// Load environment and initialize mongo
 	loadEnv()
+	loadKeyring()
+	loadKeyProvider()
+	loadAccessPolicy()
+	go reloadAccessPolicyOnSIGHUP()
+	loadReceiptIssuer()
+	validateConfig()
 	db.InitMongoDB()
+	customerCollection = db.CustomerCollection()
+	customerKeys = utils.NewCustomerKeyManager(db.NewCustomerKeyStore(db.Database.Collection("customer_keys")), keyProvider)
+	documents = utils.NewDocumentStore(loadBlobStore(), customerKeys)
+	tokenVault = utils.NewTokenVault(db.NewTokenStore(db.Database.Collection("token_vault")), keyring, keyProvider, customerKeys)
+	reencryptor = jobs.NewReencryptor(customerCollection, db.Database.Collection("job_checkpoints"), keyring, customerKeys, tokenVault, 100)
 
+	router := newRouter()
+
+	// Start the server
+	port := os.Getenv("PORT")
+	err := router.Run(":" + port)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("operation", "start_server").
+			Msg("Failed to start server")
+		return
+	}
+}
+
+// newRouter registers the API end points and their middleware
+func newRouter() *gin.Engine {
 	router := gin.Default()
+	// Spool large document uploads to disk instead of memory
+	router.MaxMultipartMemory = 8 << 20
 
 	//Apply request logger middleware
 	router.Use(requestLoggerMiddleware())
@@ -59,20 +100,46 @@ func main() {
 	api := router.Group("/api/v1/onboarding")
 	{
 		api.POST("/customers", createCustomer)
+		api.GET("/customers", searchCustomers)
 		api.GET("/customers/:id", getCustomer)
 		api.GET("/api/v1/customers/partner/:adminID", getCustomerByAdminID)
+		api.POST("/customers/:id/shred", shredCustomer)
+		api.GET("/customers/:id/audit", getCustomerAudit)
+		api.POST("/customers/:id/documents", uploadDocument)
+		api.GET("/customers/:id/documents/:documentID", getDocument)
+		api.GET("/customers/:id/consents", listConsents)
+		api.POST("/customers/:id/consents", grantConsent)
+		api.POST("/customers/:id/consents/:application/withdraw", withdrawConsent)
 	}
 
-	// Start the server
-	port := os.Getenv("PORT")
-	err := router.Run(":" + port)
-	if err != nil {
-		log.Error().
-			Err(err).
-			Str("operation", "start_server").
-			Msg("Failed to start server")
-		return
+	// Consent receipt end points
+	receipts := router.Group("/api/v1/consent-receipts")
+	{
+		receipts.GET("/keys", getConsentReceiptKeys)
+		receipts.GET("/:receiptID", getConsentReceipt)
+	}
+
+	// Admin end points
+	admin := router.Group("/api/v1/admin")
+	{
+		admin.POST("/reencrypt", startReencryption)
+		admin.GET("/reencrypt", getReencryptionProgress)
 	}
+
+	// Token vault end points
+	vault := router.Group("/api/v1")
+	{
+		vault.POST("/tokenize", tokenize)
+		vault.POST("/detokenize", detokenize)
+	}
+
+	// PII scanning end points
+	scan := router.Group("/api/v1")
+	{
+		scan.POST("/scan", scanDocument)
+	}
+
+	return router
 }
 
 // Create a new customer
@@ -96,32 +163,76 @@ func createCustomer(c *gin.Context) {
 		Str("email", customer.Email). // Don't log PII data
 		Msg("Creating new customer")
 
-	// Encrypt PII before storing it
-	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// IDs are always assigned here: a client-chosen ID could name an existing
+	// customer, whose key would then be used for the tokens of this request
+	customer.ID = uuid.New().String()
+	customer.Version = 1
+
+	// Scans are uploaded to existing customers, so a new customer's documents
+	// cannot reference any
+	for _, doc := range customer.Documents {
+		if utils.IsDocumentRef(doc.ImageUrl) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image_url cannot reference an uploaded document when creating a customer"})
+			return
+		}
+	}
 
-	// Encrypt PII data
-	if err := utils.EncryptStructPII(&customer, encryptionKey); err != nil {
+	// Reject duplicate sign-ups using the email blind index, before any key
+	// or token is created for the customer
+	if customer.Email != "" {
+		var existing []models.Customer
+		emailIndex, err := keyring.BlindIndex("Email", utils.CategoryEmail, customer.Email)
+		if err == nil {
+			existing, err = db.FindCustomersByBlindIndex(ctx, "email_index", emailIndex)
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("operation", "create_customer").
+				Msg("Failed to check for duplicate customer")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+			return
+		}
+		if len(existing) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Customer with this email already exists"})
+			return
+		}
+	}
+
+	// Encrypt PII data with a per-record data key wrapped by the customer's own
+	// key, swapping tokenized fields for tokens owned by the customer
+	provider, err := customerKeys.ProviderFor(ctx, customer.ID)
+	if err != nil {
 		log.Error().
 			Err(err).
-			Msg("Failed to encrypt customer PII")
+			Str("customer_id", customer.ID).
+			Msg("Failed to load customer key")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt customer PII"})
 		return
 	}
-
-	// Check if CustomerID is empty, and assign a new UUID if so
-	if customer.ID == "" {
-		customer.ID = uuid.New().String()
+	tokenizing := keyring.WithTokenizer(tokenVault.ForOwner(ctx, customer.ID))
+	dataKey, err := utils.EncryptRecordPII(ctx, &customer, tokenizing, provider)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to encrypt customer PII")
+		discardCustomerKeys(customer.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt customer PII"})
+		return
 	}
+	customer.DataKey = dataKey
 
 	// Insert the customer into MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 	result, err := customerCollection.InsertOne(ctx, customer)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("operation", "insert_customer").
 			Msg("Failed to insert customer into MongoDB")
+		discardCustomerKeys(customer.ID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
 		return
 	}
@@ -136,6 +247,21 @@ func createCustomer(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"customer_id": result.InsertedID})
 }
 
+// discardCustomerKeys deletes the vault tokens and destroys the key created
+// for a customer that was never stored, so no values outlive the request
+func discardCustomerKeys(id string) {
+	// The request context may be what expired
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := tokenVault.DeleteOwner(ctx, id); err != nil {
+		log.Error().Err(err).Str("operation", "create_customer").Str("customer_id", id).Msg("Failed to delete tokens of unsaved customer")
+	}
+	if err := customerKeys.Shred(ctx, id); err != nil {
+		log.Error().Err(err).Str("operation", "create_customer").Str("customer_id", id).Msg("Failed to destroy key of unsaved customer")
+	}
+}
+
 // Get a customer by ID
 func getCustomer(c *gin.Context) {
 	id := c.Param("id")
@@ -148,10 +274,8 @@ func getCustomer(c *gin.Context) {
 
 	var customer models.Customer
 
-	userRole := c.GetHeader("x-viewer-role")
-	if userRole == "" {
-		log.Error().Msg("x-viewer-role header not provided")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Viewer role header is required"})
+	viewer, ok := viewerFrom(c)
+	if !ok {
 		return
 	}
 
@@ -162,13 +286,29 @@ func getCustomer(c *gin.Context) {
 		return
 	}
 
-	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	// Release PII only to applications the customer consented to
+	viewer, allowed := consentFor(customer.Consents, viewer)
+	if !allowed {
+		refuseWithoutConsent(c, "get_customer", customer.ID, viewer)
+		return
+	}
 
 	// Decrypt PII data
-	if err := utils.DecryptStructPII(&customer, encryptionKey); err != nil {
+	if err := decryptCustomer(context.Background(), &customer); err != nil {
+		if errors.Is(err, utils.ErrKeyShredded) {
+			c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+			return
+		}
 		log.Error().
 			Err(err).
+			Str("customer_id", id).
 			Msg("Failed to decrypt customer PII")
+		// Name the field whose ciphertext was rejected, e.g. one copied from another record
+		var fieldErr *utils.FieldError
+		if errors.As(err, &fieldErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt customer PII", "field": fieldErr.Path})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt customer PII"})
 		return
 	}
@@ -180,15 +320,92 @@ func getCustomer(c *gin.Context) {
 		Str("email", customer.Email).
 		Msg("Customer retrieved successfully")
 
-	// Sanitize PII fields based on the user's role
-	utils.SanitizeCustomerData(&customer, userRole)
+	if err := auditCustomerRead(context.Background(), "view_customer", customer.ID, viewer); err != nil {
+		log.Error().Err(err).Str("operation", "get_customer").Str("customer_id", id).Msg("Failed to record audit event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+		return
+	}
+
+	// Sanitize PII fields based on the user's role and purpose
+	customer.MaskPII(viewer)
+	resolveDocumentRefs(&customer, viewer)
 
 	c.JSON(http.StatusOK, customer)
 	logResponse(c, http.StatusOK, customer)
 }
 
+// Search customers by email or phone using their blind indexes
+func searchCustomers(c *gin.Context) {
+	viewer, ok := viewerFrom(c)
+	if !ok {
+		return
+	}
+
+	var field, indexField, value string
+	var category utils.PIICategory
+	switch {
+	case c.Query("email") != "":
+		field, category, indexField, value = "Email", utils.CategoryEmail, "email_index", c.Query("email")
+	case c.Query("phone") != "":
+		field, category, indexField, value = "Phone", utils.CategoryPhone, "phone_index", c.Query("phone")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or phone query parameter is required"})
+		return
+	}
+
+	log.Info().
+		Str("operation", "search_customers").
+		Str("field", field).
+		Msg("Searching customers by blind index")
+
+	index, err := keyring.BlindIndex(field, category, value)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compute blind index")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search customers"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	customers, err := db.FindCustomersByBlindIndex(ctx, indexField, index)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search customers")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search customers"})
+		return
+	}
+
+	results := make([]models.Customer, 0, len(customers))
+	for i := range customers {
+		// Customers who did not consent to the application are left out
+		// when the policy refuses such reads
+		viewer, allowed := consentFor(customers[i].Consents, viewer)
+		if !allowed {
+			continue
+		}
+		if err := decryptCustomer(ctx, &customers[i]); err != nil {
+			// Erased customers are left out of search results
+			if !errors.Is(err, utils.ErrKeyShredded) {
+				log.Error().Err(err).Str("customer_id", customers[i].ID).Msg("Failed to decrypt customer PII")
+			}
+			continue
+		}
+		if err := auditCustomerRead(ctx, "search_customers", customers[i].ID, viewer); err != nil {
+			log.Error().Err(err).Str("operation", "search_customers").Msg("Failed to record audit event")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search customers"})
+			return
+		}
+		customers[i].MaskPII(viewer)
+		resolveDocumentRefs(&customers[i], viewer)
+		results = append(results, customers[i])
+	}
+
+	c.JSON(http.StatusOK, results)
+	logResponse(c, http.StatusOK, results)
+}
+
 func getCustomerByAdminID(c *gin.Context) {
-	adminID := c.Param("admin_id")
+	adminID := c.Param("adminID")
 
 	if adminID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Admin ID is required"})
@@ -203,30 +420,35 @@ func getCustomerByAdminID(c *gin.Context) {
 
 	var customer models.Customer
 
-	userRole := c.GetHeader("x-viewer-role")
-	if userRole == "" {
-		log.Error().Msg("x-viewer-role header not provided")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Viewer role header is required"})
+	viewer, ok := viewerFrom(c)
+	if !ok {
 		return
 	}
 
-	// Find by partner id
-	cur, err := customerCollection.Find(context.Background(), bson.M{"admin_id": adminID})
-	if err != nil {
+	// Find by admin id; consent and the audit need the customer itself
+	err := customerCollection.FindOne(context.Background(), bson.M{"admin_id": adminID}).Decode(&customer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
 		return
 	}
-	defer func(cur *mongo.Cursor, ctx context.Context) {
-		err := cur.Close(ctx)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to close cursor")
-		}
-	}(cur, context.Background())
+	if err != nil {
+		log.Error().Err(err).Str("operation", "get_customer_by_admin_id").Msg("Failed to load customer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+		return
+	}
 
-	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	viewer, allowed := consentFor(customer.Consents, viewer)
+	if !allowed {
+		refuseWithoutConsent(c, "get_customer_by_admin_id", customer.ID, viewer)
+		return
+	}
 
 	// Decrypt PII data
-	if err := utils.DecryptStructPII(&customer, encryptionKey); err != nil {
+	if err := decryptCustomer(context.Background(), &customer); err != nil {
+		if errors.Is(err, utils.ErrKeyShredded) {
+			c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+			return
+		}
 		log.Error().
 			Err(err).
 			Msg("Failed to decrypt customer PII")
@@ -241,13 +463,137 @@ func getCustomerByAdminID(c *gin.Context) {
 		Str("email", customer.Email).
 		Msg("Customer retrieved successfully")
 
-	// Sanitize PII fields based on the user's role
-	utils.SanitizeCustomerData(&customer, userRole)
+	if err := auditCustomerRead(context.Background(), "view_customer", customer.ID, viewer); err != nil {
+		log.Error().Err(err).Str("operation", "get_customer_by_admin_id").Msg("Failed to record audit event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+		return
+	}
+
+	// Sanitize PII fields based on the user's role and purpose
+	customer.MaskPII(viewer)
+	resolveDocumentRefs(&customer, viewer)
 
 	c.JSON(http.StatusOK, customer)
 	logResponse(c, http.StatusOK, customer)
 }
 
+// viewerFrom reads the x-viewer-role, x-access-purpose and x-application-name
+// headers. A request without a purpose gets the most restrictive view; one
+// with a purpose the access policy does not define is rejected.
+func viewerFrom(c *gin.Context) (utils.Viewer, bool) {
+	viewer := utils.Viewer{
+		Role:        c.GetHeader("x-viewer-role"),
+		Purpose:     c.GetHeader("x-access-purpose"),
+		Application: c.GetHeader("x-application-name"),
+	}
+	if viewer.Role == "" {
+		log.Error().Msg("x-viewer-role header not provided")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Viewer role header is required"})
+		return viewer, false
+	}
+	if viewer.Purpose != "" && !utils.CurrentAccessPolicy().HasPurpose(viewer.Purpose) {
+		log.Error().Str("purpose", viewer.Purpose).Msg("Unknown access purpose")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown access purpose"})
+		return viewer, false
+	}
+	return viewer, true
+}
+
+// consentFor returns viewer with Consented set from the customer's consents,
+// and whether the access policy lets the read go ahead
+func consentFor(consents []models.ConsentDetail, viewer utils.Viewer) (utils.Viewer, bool) {
+	viewer.Consented = models.HasConsent(consents, viewer.Application, viewer.Purpose, time.Now())
+	return viewer, viewer.Consented || utils.CurrentAccessPolicy().Consent.Without != utils.ActionRefuse
+}
+
+// consentRequired returns true if the viewer may only get PII that cannot be
+// masked, such as document scans and detokenized values, with consent
+func consentRequired(viewer utils.Viewer) bool {
+	return !viewer.Consented && utils.CurrentAccessPolicy().Consent.Without != utils.ActionReveal
+}
+
+// refuseWithoutConsent audits and rejects a read by an application the
+// customer has not consented to
+func refuseWithoutConsent(c *gin.Context, operation, customerID string, viewer utils.Viewer) {
+	event := &db.AuditEvent{
+		Operation:   operation,
+		CustomerID:  customerID,
+		Role:        viewer.Role,
+		Purpose:     viewer.Purpose,
+		Application: viewer.Application,
+		Outcome:     "refused_without_consent",
+	}
+	if err := db.RecordAuditEvent(context.Background(), event); err != nil {
+		log.Error().Err(err).Str("operation", operation).Str("customer_id", customerID).Msg("Failed to record audit event")
+	}
+	log.Info().
+		Str("operation", operation).
+		Str("customer_id", customerID).
+		Str("application", viewer.Application).
+		Msg("Read refused without consent")
+	c.JSON(http.StatusForbidden, gin.H{"error": "Customer has not consented to release PII to this application"})
+}
+
+// auditCustomerRead records that viewer read a customer's PII, for which
+// purpose and whether the calling application had consent
+func auditCustomerRead(ctx context.Context, operation, customerID string, viewer utils.Viewer) error {
+	outcome := "read"
+	if !viewer.Consented {
+		outcome = "read_without_consent"
+	}
+	return db.RecordAuditEvent(ctx, &db.AuditEvent{
+		Operation:   operation,
+		CustomerID:  customerID,
+		Role:        viewer.Role,
+		Purpose:     viewer.Purpose,
+		Application: viewer.Application,
+		Outcome:     outcome,
+	})
+}
+
+// decryptCustomer decrypts a customer's PII with its data key, falling back to
+// the keyring for documents written before envelope encryption
+func decryptCustomer(ctx context.Context, customer *models.Customer) error {
+	if customer.DataKey == nil {
+		return customer.DecryptPII(keyring)
+	}
+	provider, err := customerKeys.ProviderFor(ctx, customer.ID)
+	if err != nil {
+		return err
+	}
+	return utils.DecryptRecordPII(ctx, customer, customer.DataKey, keyring, provider)
+}
+
+// Start migrating customers still encrypted directly with keyring keys to per-record data keys
+func startReencryption(c *gin.Context) {
+	if c.GetHeader("x-viewer-role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	if err := reencryptor.Start(context.Background()); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("operation", "start_reencryption").
+		Str("active_key_id", keyring.ActiveKeyID()).
+		Msg("Re-encryption job started")
+
+	c.JSON(http.StatusAccepted, reencryptor.Progress())
+}
+
+// Report progress of the current or last re-encryption run
+func getReencryptionProgress(c *gin.Context) {
+	if c.GetHeader("x-viewer-role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reencryptor.Progress())
+}
+
 func loadEnv() {
 	// Load .env file
 	err := godotenv.Load(".env")
@@ -256,6 +602,144 @@ func loadEnv() {
 	}
 }
 
+func loadKeyring() {
+	var err error
+	keyring, err = utils.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load encryption keyring")
+	}
+	if err := keyring.LoadIndexKeyFromEnv(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load blind index key")
+	}
+	hashKey, err := keyring.MaskHashKey()
+	if err == nil {
+		err = utils.SetMaskHashKey(hashKey)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set mask hash key")
+	}
+	log.Info().
+		Str("operation", "load_keyring").
+		Str("active_key_id", keyring.ActiveKeyID()).
+		Int("key_count", len(keyring.KeyIDs())).
+		Msg("Encryption keyring loaded")
+}
+
+func loadKeyProvider() {
+	var err error
+	keyProvider, err = utils.NewKeyProviderFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize key provider")
+	}
+	log.Info().
+		Str("operation", "load_key_provider").
+		Str("key_provider", fmt.Sprintf("%T", keyProvider)).
+		Msg("Key provider initialized")
+}
+
+// loadAccessPolicy applies the role access policy in POLICY_FILE, keeping the
+// built-in default when no file is configured
+func loadAccessPolicy() {
+	path := os.Getenv("POLICY_FILE")
+	if path == "" {
+		log.Warn().
+			Str("operation", "load_access_policy").
+			Msg("POLICY_FILE not set, using the default access policy")
+		return
+	}
+	policy, err := utils.LoadAccessPolicy(path)
+	if err != nil {
+		log.Fatal().Err(err).Str("policy_file", path).Msg("Failed to load access policy")
+	}
+	utils.SetAccessPolicy(policy)
+	log.Info().
+		Str("operation", "load_access_policy").
+		Str("policy_file", path).
+		Int("role_count", len(policy.Roles)).
+		Msg("Access policy loaded")
+}
+
+// reloadAccessPolicyOnSIGHUP reloads POLICY_FILE whenever the process gets
+// SIGHUP. A policy that fails to load is logged and the current one kept.
+func reloadAccessPolicyOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		path := os.Getenv("POLICY_FILE")
+		if path == "" {
+			continue
+		}
+		policy, err := utils.LoadAccessPolicy(path)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("operation", "reload_access_policy").
+				Str("policy_file", path).
+				Msg("Failed to reload access policy, keeping the current one")
+			continue
+		}
+		utils.SetAccessPolicy(policy)
+		log.Info().
+			Str("operation", "reload_access_policy").
+			Str("policy_file", path).
+			Int("role_count", len(policy.Roles)).
+			Msg("Access policy reloaded")
+	}
+}
+
+func loadReceiptIssuer() {
+	var err error
+	receiptIssuer, err = utils.NewReceiptIssuerFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load consent receipt signing key")
+	}
+	log.Info().
+		Str("operation", "load_receipt_issuer").
+		Str("key_id", receiptIssuer.KeyID()).
+		Msg("Consent receipt signing key loaded")
+}
+
+func loadBlobStore() utils.BlobStore {
+	blobs, err := utils.NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize blob store")
+	}
+	log.Info().
+		Str("operation", "load_blob_store").
+		Str("blob_store", fmt.Sprintf("%T", blobs)).
+		Msg("Blob store initialized")
+	return blobs
+}
+
+// validateConfig refuses to start with missing settings, invalid pii tags or
+// key material that is weak or does not round trip, rather than failing on the
+// first request
+func validateConfig() {
+	for _, name := range []string{"MONGO_URI", "PORT"} {
+		if os.Getenv(name) == "" {
+			log.Fatal().Str("setting", name).Msg("Required setting is missing")
+		}
+	}
+
+	if err := keyring.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Encryption keyring failed validation")
+	}
+
+	if err := utils.ValidatePIITags(models.Customer{}); err != nil {
+		log.Fatal().Err(err).Msg("Model pii tags failed validation")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := utils.ValidateKeyProvider(ctx, keyProvider); err != nil {
+		log.Fatal().Err(err).Msg("Key provider failed validation")
+	}
+
+	log.Info().
+		Str("operation", "validate_config").
+		Msg("Configuration validated")
+}
+
 func initLogger() {
 	// Output logs to both console and file (server will be /var/log/customer_api.log)
 	logFile, err := os.OpenFile("customer_api.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestGetCustomerByAdminIDReadsRouteParam requests a customer by admin ID
+// without a viewer role, which the handler rejects only after reading the
+// admin ID from the route and before touching the database.
+func TestGetCustomerByAdminIDReadsRouteParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/onboarding/api/v1/customers/partner/ADM-1", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Viewer role header is required") {
+		t.Fatalf("GET by admin ID = %d %s, want 400 for the missing viewer role", w.Code, w.Body.String())
+	}
+}
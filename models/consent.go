@@ -0,0 +1,46 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Active reports whether the consent allows releasing PII at now: it was
+// given, has not been withdrawn and has not expired.
+func (d ConsentDetail) Active(now time.Time) bool {
+	if !d.ConsentGiven || d.WithdrawnAt != nil && !d.WithdrawnAt.After(now) {
+		return false
+	}
+	return d.ExpiresAt == nil || now.Before(*d.ExpiresAt)
+}
+
+// HasConsent reports whether application holds an active consent among
+// consents at now that covers purpose. When there are several for the
+// application, the latest one decides, so a newer refusal, withdrawal or
+// narrower grant overrides an older grant.
+func HasConsent(consents []ConsentDetail, application, purpose string, now time.Time) bool {
+	latest, ok := LatestConsent(consents, application)
+	return ok && latest.Active(now) && purpose != "" && slices.Contains(latest.Purposes, purpose)
+}
+
+// LatestConsent returns the consent of application with the latest
+// ConsentDate, the last one listed if several share it.
+func LatestConsent(consents []ConsentDetail, application string) (ConsentDetail, bool) {
+	if application == "" {
+		return ConsentDetail{}, false
+	}
+	var latest *ConsentDetail
+	for i := range consents {
+		consent := &consents[i]
+		if consent.ApplicationName != application {
+			continue
+		}
+		if latest == nil || !consent.ConsentDate.Before(latest.ConsentDate) {
+			latest = consent
+		}
+	}
+	if latest == nil {
+		return ConsentDetail{}, false
+	}
+	return *latest, true
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHasConsent(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+	after := func(d time.Duration) *time.Time {
+		at := now.Add(d)
+		return &at
+	}
+
+	support := []string{"support"}
+
+	tests := []struct {
+		name     string
+		consents []ConsentDetail
+		want     bool
+	}{
+		{"given", []ConsentDetail{{ApplicationName: "app", ConsentGiven: true, Purposes: support}}, true},
+		{"not given", []ConsentDetail{{ApplicationName: "app"}}, false},
+		{"other application", []ConsentDetail{{ApplicationName: "other", ConsentGiven: true, Purposes: support}}, false},
+		{"none", nil, false},
+		{"expired", []ConsentDetail{{ApplicationName: "app", ConsentGiven: true, Purposes: support, ExpiresAt: before(time.Hour)}}, false},
+		{"not yet expired", []ConsentDetail{{ApplicationName: "app", ConsentGiven: true, Purposes: support, ExpiresAt: after(time.Hour)}}, true},
+		{"withdrawn", []ConsentDetail{{ApplicationName: "app", ConsentGiven: true, Purposes: support, WithdrawnAt: before(time.Second)}}, false},
+		{"withdrawn now", []ConsentDetail{{ApplicationName: "app", ConsentGiven: true, Purposes: support, WithdrawnAt: before(0)}}, false},
+		{"later refusal", []ConsentDetail{
+			{ApplicationName: "app", ConsentGiven: true, Purposes: support, ConsentDate: *before(2 * time.Hour)},
+			{ApplicationName: "app", ConsentDate: *before(time.Hour)},
+		}, false},
+		{"later grant", []ConsentDetail{
+			{ApplicationName: "app", ConsentGiven: true, Purposes: support, ConsentDate: *before(time.Hour)},
+			{ApplicationName: "app", ConsentDate: *before(2 * time.Hour)},
+		}, true},
+	}
+	for _, tt := range tests {
+		if got := HasConsent(tt.consents, "app", "support", now); got != tt.want {
+			t.Errorf("%s: HasConsent = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	if HasConsent([]ConsentDetail{{ConsentGiven: true, Purposes: support}}, "", "support", now) {
+		t.Error("HasConsent granted consent to an unnamed application")
+	}
+}
+
+func TestHasConsentPurposes(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+	consents := []ConsentDetail{
+		{ApplicationName: "app", ConsentGiven: true, ConsentDate: earlier, Purposes: []string{"support", "fraud"}},
+		{ApplicationName: "app", ConsentGiven: true, ConsentDate: now, Purposes: []string{"support"}},
+	}
+
+	tests := []struct {
+		purpose string
+		want    bool
+	}{
+		{"support", true},
+		// The latest consent narrowed the purposes
+		{"fraud", false},
+		{"kyc_review", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := HasConsent(consents, "app", tt.purpose, now); got != tt.want {
+			t.Errorf("HasConsent for purpose %q = %t, want %t", tt.purpose, got, tt.want)
+		}
+	}
+	if HasConsent([]ConsentDetail{{ApplicationName: "app", ConsentGiven: true}}, "app", "support", now) {
+		t.Error("HasConsent granted consent without purposes")
+	}
+}
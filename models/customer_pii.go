@@ -0,0 +1,127 @@
+// Code generated by zeropii-gen; DO NOT EDIT.
+
+package models
+
+import (
+	"zeropii/utils"
+)
+
+// EncryptPII encrypts the fields of the Customer tagged pii:"true", as
+// utils.EncryptStructPII does.
+func (c *Customer) EncryptPII(keyring *utils.Keyring) error {
+	return utils.EncryptPIIFields(keyring, c.ID, c.walkPII)
+}
+
+// DecryptPII decrypts the fields of the Customer tagged pii:"true", as
+// utils.DecryptStructPII does.
+func (c *Customer) DecryptPII(keyring *utils.Keyring) error {
+	return utils.DecryptPIIFields(keyring, c.ID, c.walkPII)
+}
+
+// MaskPII masks the fields of the Customer that viewer may not see, as
+// utils.SanitizeCustomerData does.
+func (c *Customer) MaskPII(viewer utils.Viewer) {
+	utils.MaskPIIFields(viewer, c.walkPII)
+}
+
+// PseudonymizePII replaces the fields of the Customer tagged pii:"true" with
+// their pseudonyms under pseudonymizer, as utils.PseudonymizePII does.
+func (c *Customer) PseudonymizePII(pseudonymizer *utils.Pseudonymizer) {
+	utils.PseudonymizePIIFields(pseudonymizer, c.walkPII)
+}
+
+func (c *Customer) walkPII(visit func(utils.PIIField) error) error {
+	return c.visitPII("", visit)
+}
+
+func (c *Customer) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "Email", Name: "Email", Tag: utils.PIIDescriptor{Enabled: true, Category: "email", Mask: "email", Enc: "randomized", Index: true, Level: "medium"}, Value: &c.Email, Index: &c.EmailIndex}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Phone", Name: "Phone", Tag: utils.PIIDescriptor{Enabled: true, Category: "phone", Mask: "last3", Enc: "fpe", Index: true, Level: "medium"}, Value: &c.Phone, Index: &c.PhoneIndex}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "DOB", Name: "DOB", Tag: utils.PIIDescriptor{Enabled: true, Category: "dob", Mask: "year_only", Enc: "randomized", Level: "high"}, Value: &c.DOB}); err != nil {
+		return err
+	}
+	if err := c.Address.visitPII(prefix+"Address.", visit); err != nil {
+		return err
+	}
+	if err := c.Passport.visitPII(prefix+"Passport.", visit); err != nil {
+		return err
+	}
+	if err := c.Pan.visitPII(prefix+"Pan.", visit); err != nil {
+		return err
+	}
+	for idx := range c.Documents {
+		if err := c.Documents[idx].visitPII(prefix+"Documents.", visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CustomerAddress) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := c.CurrentAddress.visitPII(prefix+"CurrentAddress.", visit); err != nil {
+		return err
+	}
+	if err := c.PermanentAddress.visitPII(prefix+"PermanentAddress.", visit); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Passport) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "PassportNumber", Name: "PassportNumber", Tag: utils.PIIDescriptor{Enabled: true, Category: "passport", Mask: "full", Enc: "token", Level: "high"}, Value: &p.PassportNumber}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "PassportDob", Name: "PassportDob", Tag: utils.PIIDescriptor{Enabled: true, Category: "dob", Mask: "year_only", Enc: "randomized", Level: "high"}, Value: &p.PassportDob}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "PassportAddressLine1", Name: "PassportAddressLine1", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &p.PassportAddressLine1}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "PassportAddressLine2", Name: "PassportAddressLine2", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &p.PassportAddressLine2}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Pan) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "PanNumber", Name: "PanNumber", Tag: utils.PIIDescriptor{Enabled: true, Category: "national_id", Mask: "full", Enc: "fpe", Level: "high"}, Value: &p.PanNumber}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "PanDob", Name: "PanDob", Tag: utils.PIIDescriptor{Enabled: true, Category: "dob", Mask: "year_only", Enc: "randomized", Level: "high"}, Value: &p.PanDob}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Docs) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "DocNumber", Name: "DocNumber", Tag: utils.PIIDescriptor{Enabled: true, Category: "document", Mask: "last4", Enc: "fpe", Level: "high"}, Value: &d.DocNumber}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *Address) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "Street", Name: "Street", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.Street}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "StreetLine2", Name: "StreetLine2", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.StreetLine2}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "City", Name: "City", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.City}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "State", Name: "State", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.State}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Zip", Name: "Zip", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.Zip}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Country", Name: "Country", Tag: utils.PIIDescriptor{Enabled: true, Category: "address", Mask: "full", Enc: "randomized", Level: "high"}, Value: &a.Country}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"zeropii/detector"
+	"zeropii/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// maxScanBytes limits the size of documents sent to the scan end point
+const maxScanBytes = 1 << 20
+
+type scanRequest struct {
+	Document json.RawMessage `json:"document" binding:"required"`
+	// Redact also returns the document with the PII found masked
+	Redact bool `json:"redact"`
+	// Types limits the PII looked for; all types by default
+	Types         []detector.Type `json:"types"`
+	MinConfidence float64         `json:"min_confidence"`
+}
+
+// Scan any JSON document for PII, optionally returning it masked. Masks
+// follow the access policy of the x-viewer-role header when it is given.
+func scanDocument(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxScanBytes)
+
+	var req scanRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, t := range req.Types {
+		if !knownDetectorType(t) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown PII type", "type": t})
+			return
+		}
+	}
+	if req.MinConfidence < 0 || req.MinConfidence > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_confidence must be between 0 and 1"})
+		return
+	}
+	scanner := detector.New(req.Types...).WithMinConfidence(req.MinConfidence)
+
+	response := gin.H{}
+	var findings []detector.Finding
+	var err error
+	if req.Redact {
+		var redacted []byte
+		redacted, findings, err = scanner.RedactJSON(req.Document, policyMask(c.GetHeader("x-viewer-role")))
+		response["document"] = json.RawMessage(redacted)
+	} else {
+		findings, err = scanner.ScanJSON(req.Document)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	response["findings"] = findings
+
+	log.Info().
+		Str("operation", "scan_document").
+		Bool("redact", req.Redact).
+		Int("finding_count", len(findings)).
+		Msg("Document scanned for PII")
+
+	c.JSON(http.StatusOK, response)
+}
+
+func knownDetectorType(t detector.Type) bool {
+	for _, known := range detector.Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// policyMask masks findings with the mask the access policy gives role for
+// their category, as it would mask a tagged field of that category
+func policyMask(role string) detector.Masker {
+	policy := utils.CurrentAccessPolicy()
+	return func(finding detector.Finding, value string) string {
+		return utils.MaskValue(value, policy.Mask(role, finding.Tag()))
+	}
+}
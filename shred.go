@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"zeropii/db"
+	"zeropii/models"
+	"zeropii/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type shredRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Crypto-shred a customer by destroying their key (right to erasure)
+func shredCustomer(c *gin.Context) {
+	id := c.Param("id")
+	role := c.GetHeader("x-viewer-role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	var req shredRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var customer models.Customer
+	err := customerCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&customer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Msg("Failed to load customer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+		return
+	}
+	if customer.ShreddedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Customer PII has already been erased"})
+		return
+	}
+	if customer.DataKey == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer predates per-customer keys; run re-encryption first"})
+		return
+	}
+	// Fields readable with the shared keyring would survive the customer key
+	if fields := utils.ReadablePII(ctx, &customer, keyring, nil); len(fields) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer has PII that destroying the customer key would not erase", "fields": fields})
+		return
+	}
+
+	// The data key must be wrapped by the customer key, otherwise destroying
+	// that key would not make this record undecryptable
+	rewrapped := false
+	if customer.DataKey.KeyID != utils.CustomerKeyID(customer.ID) {
+		provider, err := customerKeys.ProviderFor(ctx, customer.ID)
+		if err == nil {
+			customer.DataKey, err = provider.RewrapDataKey(ctx, customer.DataKey)
+		}
+		if err == nil {
+			_, err = customerCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"data_key": customer.DataKey}})
+		}
+		if err != nil {
+			log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to move data key onto customer key")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to shred customer"})
+			return
+		}
+		rewrapped = true
+	}
+
+	if err := customerKeys.Shred(ctx, id); err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to destroy customer key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to shred customer"})
+		return
+	}
+
+	// Blind indexes and token fingerprints are keyed with the shared index
+	// key, so they are removed rather than shredded
+	if err := tokenVault.ForgetOwner(ctx, id); err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to forget token fingerprints")
+	}
+	shreddedAt := time.Now().UTC()
+	update := bson.M{
+		"$set":   bson.M{"shredded_at": shreddedAt},
+		"$unset": bson.M{"email_index": "", "phone_index": ""},
+	}
+	if _, err := customerCollection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to mark customer as shredded")
+	}
+
+	// Prove every field of the stored record can no longer be read
+	readable, err := readableAfterShred(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to verify shredded customer")
+	}
+	verified := err == nil && len(readable) == 0
+
+	event := &db.AuditEvent{
+		Operation:  "crypto_shred",
+		CustomerID: id,
+		Role:       role,
+		Outcome:    "shredded",
+		Details: gin.H{
+			"reason":   req.Reason,
+			"key_id":   utils.CustomerKeyID(id),
+			"verified": verified,
+			"readable": readable,
+			// Backups taken before the data key was moved onto the customer
+			// key still hold a KEK-wrapped copy of it
+			"backups_covered": !rewrapped,
+		},
+	}
+	if err := db.RecordAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("operation", "shred_customer").Str("customer_id", id).Msg("Failed to record audit event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Customer shredded but audit event could not be recorded"})
+		return
+	}
+
+	log.Info().
+		Str("operation", "shred_customer").
+		Str("customer_id", id).
+		Bool("verified", verified).
+		Msg("Customer PII crypto-shredded")
+
+	c.JSON(http.StatusOK, event)
+}
+
+// readableAfterShred reloads a shredded customer and returns what can still
+// be read: the data key if it unwraps, any field that decrypts or
+// detokenizes without it, and blind indexes left in place.
+func readableAfterShred(ctx context.Context, id string) ([]string, error) {
+	var stored models.Customer
+	if err := customerCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&stored); err != nil {
+		return nil, err
+	}
+
+	readable := append([]string{}, utils.ReadablePII(ctx, &stored, keyring, tokenVault)...)
+	if stored.EmailIndex != "" {
+		readable = append(readable, "EmailIndex")
+	}
+	if stored.PhoneIndex != "" {
+		readable = append(readable, "PhoneIndex")
+	}
+	if err := decryptCustomer(ctx, &stored); !errors.Is(err, utils.ErrKeyShredded) {
+		readable = append(readable, "DataKey")
+	}
+	return readable, nil
+}
+
+// List the audit trail of a customer
+func getCustomerAudit(c *gin.Context) {
+	if c.GetHeader("x-viewer-role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	events, err := db.FindAuditEvents(context.Background(), c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Str("operation", "get_customer_audit").Msg("Failed to load audit events")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"zeropii/db"
+	"zeropii/models"
+	"zeropii/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type tokenizeRequest struct {
+	Field      string            `json:"field" binding:"required"`
+	Value      string            `json:"value" binding:"required"`
+	CustomerID string            `json:"customer_id"`
+	Format     utils.TokenFormat `json:"format"`
+}
+
+type detokenizeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Swap a PII value for a token kept in the token vault (admin only)
+func tokenize(c *gin.Context) {
+	role := c.GetHeader("x-viewer-role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	var req tokenizeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format == "" {
+		req.Format = utils.TokenFormatRandom
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Tokens are only issued for customers that exist, so this endpoint
+	// never creates keys for made-up customer IDs
+	if req.CustomerID != "" {
+		count, err := customerCollection.CountDocuments(ctx, bson.M{"_id": req.CustomerID}, options.Count().SetLimit(1))
+		if err != nil {
+			log.Error().Err(err).Str("operation", "tokenize").Msg("Failed to load customer")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tokenize value"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+	}
+
+	token, err := tokenVault.Tokenize(ctx, req.CustomerID, req.Field, req.Value, req.Format)
+	switch {
+	case errors.Is(err, utils.ErrCustomerKeyNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer predates per-customer keys; run re-encryption first"})
+		return
+	case errors.Is(err, utils.ErrUnknownTokenFormat), errors.Is(err, utils.ErrFPEValueTooShort):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, utils.ErrKeyShredded):
+		c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", "tokenize").Str("field", req.Field).Msg("Failed to tokenize value")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tokenize value"})
+		return
+	}
+
+	log.Info().
+		Str("operation", "tokenize").
+		Str("field", req.Field).
+		Str("customer_id", req.CustomerID).
+		Msg("Value tokenized")
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// Reveal the value behind a token (admin only)
+func detokenize(c *gin.Context) {
+	role := c.GetHeader("x-viewer-role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin role is required"})
+		return
+	}
+
+	// Detokenized values cannot be masked, so the purpose must be declared
+	// and must justify the category of the value
+	policy := utils.CurrentAccessPolicy()
+	viewer := utils.Viewer{
+		Role:        role,
+		Purpose:     c.GetHeader("x-access-purpose"),
+		Application: c.GetHeader("x-application-name"),
+	}
+	if !policy.HasPurpose(viewer.Purpose) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A known access purpose is required"})
+		return
+	}
+
+	var req detokenizeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry, value, err := tokenVault.Detokenize(ctx, req.Token)
+	switch {
+	case errors.Is(err, utils.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	case errors.Is(err, utils.ErrKeyShredded):
+		c.JSON(http.StatusGone, gin.H{"error": "Customer PII has been erased"})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("operation", "detokenize").Msg("Failed to detokenize value")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detokenize value"})
+		return
+	}
+
+	if category := utils.PIIFieldCategory(models.Customer{}, entry.Field); !policy.Allows(viewer.Purpose, category) {
+		event := &db.AuditEvent{
+			Operation:   "detokenize",
+			CustomerID:  entry.Owner,
+			Role:        viewer.Role,
+			Purpose:     viewer.Purpose,
+			Application: viewer.Application,
+			Outcome:     "refused_for_purpose",
+			Details:     gin.H{"token": entry.Token, "field": entry.Field, "category": category},
+		}
+		if err := db.RecordAuditEvent(ctx, event); err != nil {
+			log.Error().Err(err).Str("operation", "detokenize").Msg("Failed to record audit event")
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access purpose does not allow this value", "category": category})
+		return
+	}
+
+	// Values owned by a customer are only revealed to applications with
+	// their consent
+	if entry.Owner != "" {
+		consents, err := db.FindCustomerConsents(ctx, entry.Owner)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Error().Err(err).Str("operation", "detokenize").Str("customer_id", entry.Owner).Msg("Failed to load customer consents")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detokenize value"})
+			return
+		}
+		viewer.Consented = models.HasConsent(consents, viewer.Application, viewer.Purpose, time.Now())
+		if consentRequired(viewer) {
+			refuseWithoutConsent(c, "detokenize", entry.Owner, viewer)
+			return
+		}
+	}
+
+	// Every reveal is audited so access to the vault can be reviewed
+	event := &db.AuditEvent{
+		Operation:   "detokenize",
+		CustomerID:  entry.Owner,
+		Role:        viewer.Role,
+		Purpose:     viewer.Purpose,
+		Application: viewer.Application,
+		Outcome:     "revealed",
+		Details:     gin.H{"token": entry.Token, "field": entry.Field},
+	}
+	if err := db.RecordAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Str("operation", "detokenize").Msg("Failed to record audit event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detokenize value"})
+		return
+	}
+
+	log.Info().
+		Str("operation", "detokenize").
+		Str("field", entry.Field).
+		Str("customer_id", entry.Owner).
+		Msg("Token revealed")
+
+	c.JSON(http.StatusOK, gin.H{"token": entry.Token, "field": entry.Field, "value": value})
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var ErrNoIndexKey = errors.New("keyring has no blind index key")
+
+// SetIndexKey sets the HMAC key used for blind indexes. It must be at least
+// 32 bytes and must not be one of the keyring's encryption keys.
+func (k *Keyring) SetIndexKey(key []byte) error {
+	if len(key) < 32 {
+		return fmt.Errorf("blind index key must be at least 32 bytes")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for id, encryptionKey := range k.keys {
+		if subtle.ConstantTimeCompare(encryptionKey, key) == 1 {
+			return fmt.Errorf("blind index key must differ from encryption key %q", id)
+		}
+	}
+	k.indexKey = append([]byte(nil), key...)
+	return nil
+}
+
+// LoadIndexKeyFromEnv sets the blind index key from BLIND_INDEX_KEY, 32
+// random bytes, hex or padded base64 encoded.
+func (k *Keyring) LoadIndexKeyFromEnv() error {
+	encoded := os.Getenv("BLIND_INDEX_KEY")
+	if encoded == "" {
+		return fmt.Errorf("BLIND_INDEX_KEY: %w", ErrNoIndexKey)
+	}
+	key, err := decodeKey(encoded)
+	if err != nil {
+		return fmt.Errorf("BLIND_INDEX_KEY: %w", err)
+	}
+	if err := k.SetIndexKey(key); err != nil {
+		return fmt.Errorf("BLIND_INDEX_KEY: %w", err)
+	}
+	return nil
+}
+
+// MaskHashKey derives the key of the hash mask from the blind index key, so
+// masked values never match blind indexes.
+func (k *Keyring) MaskHashKey() ([]byte, error) {
+	k.mu.RLock()
+	key := k.indexKey
+	k.mu.RUnlock()
+
+	if key == nil {
+		return nil, ErrNoIndexKey
+	}
+	derived := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("zeropii mask hash")), derived); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// BlindIndex returns the keyed HMAC-SHA256 of the value of a field, normalized
+// for its category, so encrypted values can be looked up by equality. The
+// field name is mixed into the HMAC so equal values in different fields get
+// different indexes.
+func (k *Keyring) BlindIndex(field string, category PIICategory, value string) (string, error) {
+	k.mu.RLock()
+	key := k.indexKey
+	k.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoIndexKey
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(normalizeIndexValue(category, value)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// normalizeIndexValue trims whitespace, lowercases emails and strips phone
+// number formatting so equivalent inputs produce the same index.
+func normalizeIndexValue(category PIICategory, value string) string {
+	value = strings.TrimSpace(value)
+	switch category {
+	case CategoryEmail:
+		return strings.ToLower(value)
+	case CategoryPhone:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) || r == '+' {
+				return r
+			}
+			return -1
+		}, value)
+	}
+	return value
+}
@@ -0,0 +1,76 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+
+	"zeropii/utils"
+)
+
+func TestBlindIndexNormalizesByCategory(t *testing.T) {
+	keyring := baseKeyring(t)
+	index := func(field string, category utils.PIICategory, value string) string {
+		t.Helper()
+		idx, err := keyring.BlindIndex(field, category, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return idx
+	}
+
+	tests := []struct {
+		name     string
+		field    string
+		category utils.PIICategory
+		a, b     string
+		equal    bool
+	}{
+		{"email case", "ContactEmail", utils.CategoryEmail, "Ethan.Hunt@Example.com", " ethan.hunt@example.com ", true},
+		{"phone formatting", "Mobile", utils.CategoryPhone, "+91 98765-43210", "+919876543210", true},
+		{"other keeps case", "Email", utils.CategoryOther, "Ethan", "ethan", false},
+		{"other keeps punctuation", "Phone", utils.CategoryOther, "98765-43210", "9876543210", false},
+		{"national ID", "PanNumber", utils.CategoryNationalID, "ABCDE1234F", "ABCDE1234F", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := index(tt.field, tt.category, tt.a), index(tt.field, tt.category, tt.b)
+			if (a == b) != tt.equal {
+				t.Errorf("index(%q) == index(%q) is %v, want %v", tt.a, tt.b, a == b, tt.equal)
+			}
+		})
+	}
+
+	// The field name is mixed in, so equal values in different fields differ
+	if index("Email", utils.CategoryEmail, "a@example.com") == index("BackupEmail", utils.CategoryEmail, "a@example.com") {
+		t.Error("equal values in different fields share a blind index")
+	}
+}
+
+func TestBlindIndexKey(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if _, err := keyring.BlindIndex("Email", utils.CategoryEmail, "a@example.com"); !errors.Is(err, utils.ErrNoIndexKey) {
+		t.Errorf("BlindIndex without a key = %v, want ErrNoIndexKey", err)
+	}
+
+	encryptionKey := randomKey(t)
+	if err := keyring.Rotate("k1", encryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetIndexKey(encryptionKey); err == nil {
+		t.Error("SetIndexKey accepted an encryption key")
+	}
+	if err := keyring.SetIndexKey([]byte("short")); err == nil {
+		t.Error("SetIndexKey accepted a short key")
+	}
+
+	// Indexes depend on the key, not only on the value
+	if err := keyring.SetIndexKey(randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := keyring.BlindIndex("Email", utils.CategoryEmail, "a@example.com")
+	other := baseKeyring(t)
+	second, _ := other.BlindIndex("Email", utils.CategoryEmail, "a@example.com")
+	if first == second {
+		t.Error("different index keys give the same blind index")
+	}
+}
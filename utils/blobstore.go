@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	ErrInvalidBlobKey = errors.New("invalid blob key")
+)
+
+// BlobStore stores opaque blobs, such as encrypted document scans, by key.
+// Keys are slash separated paths of [A-Za-z0-9._-] segments.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob stored under key. It returns ErrBlobNotFound if
+	// there is none. The caller must close the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+var blobKeySegment = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// validateBlobKey rejects keys that could escape the store's root.
+func validateBlobKey(key string) error {
+	for _, segment := range strings.Split(key, "/") {
+		if !blobKeySegment.MatchString(segment) || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidBlobKey, key)
+		}
+	}
+	return nil
+}
+
+// NewBlobStoreFromEnv selects the blob store named by BLOB_STORE:
+//
+//	local  (default) LocalBlobStore rooted at BLOB_DIR
+//	s3     S3BlobStore using S3_ENDPOINT, S3_REGION, S3_BUCKET,
+//	       S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY and S3_USE_PATH_STYLE
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	switch store := os.Getenv("BLOB_STORE"); store {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		return NewLocalBlobStore(dir)
+	case "s3":
+		return NewS3BlobStore(S3Config{
+			Endpoint:     os.Getenv("S3_ENDPOINT"),
+			Region:       os.Getenv("S3_REGION"),
+			Bucket:       os.Getenv("S3_BUCKET"),
+			AccessKeyID:  os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("S3_SECRET_ACCESS_KEY"),
+			UsePathStyle: os.Getenv("S3_USE_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", store)
+	}
+}
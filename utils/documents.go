@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// documentRefPrefix marks an internal document reference, stored in place of
+// a document URL, e.g. "blob:<customer ID>/<document ID>".
+const documentRefPrefix = "blob:"
+
+// maxDocumentHeaderSize bounds the header read before a document is decrypted.
+const maxDocumentHeaderSize = 64 * 1024
+
+var ErrInvalidDocumentRef = errors.New("invalid document reference")
+
+// DocumentStore keeps KYC document scans in a BlobStore, encrypted as a
+// chunked AEAD stream so uploads and downloads never hold a whole document
+// in memory. Each document has its own data key wrapped by the customer's
+// key, so shredding the customer also erases their documents.
+//
+// A stored blob is laid out as
+//
+//	header length (4 bytes, big endian) | JSON documentHeader | encrypted stream
+//
+// and the header is bound to the stream as associated data.
+type DocumentStore struct {
+	blobs BlobStore
+	keys  *CustomerKeyManager
+}
+
+// Document is an opened document. Reading Body yields the decrypted content
+// and fails with ErrStreamCorrupt if the stored blob was altered.
+type Document struct {
+	ContentType string
+	Size        int64
+	Body        io.ReadCloser
+}
+
+type documentHeader struct {
+	CustomerID  string      `json:"customer_id"`
+	ContentType string      `json:"content_type"`
+	Size        int64       `json:"size"`
+	DataKey     *WrappedKey `json:"data_key"`
+}
+
+// NewDocumentStore creates a document store writing to blobs with data keys
+// wrapped by customer keys from keys.
+func NewDocumentStore(blobs BlobStore, keys *CustomerKeyManager) *DocumentStore {
+	return &DocumentStore{blobs: blobs, keys: keys}
+}
+
+// DocumentRef returns the internal reference of a customer's document.
+func DocumentRef(customerID, documentID string) string {
+	return documentRefPrefix + customerID + "/" + documentID
+}
+
+// IsDocumentRef reports whether value is an internal document reference
+// rather than, for example, a legacy URL.
+func IsDocumentRef(value string) bool {
+	return strings.HasPrefix(value, documentRefPrefix)
+}
+
+// ParseDocumentRef splits a reference into its customer and document IDs.
+func ParseDocumentRef(ref string) (customerID, documentID string, err error) {
+	customerID, documentID, ok := strings.Cut(strings.TrimPrefix(ref, documentRefPrefix), "/")
+	if !IsDocumentRef(ref) || !ok || customerID == "" || documentID == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidDocumentRef, ref)
+	}
+	return customerID, documentID, nil
+}
+
+// Upload encrypts size bytes read from r and stores them as a new document
+// of the customer, returning its internal reference.
+func (s *DocumentStore) Upload(ctx context.Context, customerID string, r io.Reader, size int64, contentType string) (string, error) {
+	provider, err := s.keys.ProviderFor(ctx, customerID)
+	if err != nil {
+		return "", err
+	}
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("generate document key: %w", err)
+	}
+	defer clear(dek)
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+	documentID := hex.EncodeToString(id)
+	ref := DocumentRef(customerID, documentID)
+
+	header, err := json.Marshal(documentHeader{
+		CustomerID:  customerID,
+		ContentType: contentType,
+		Size:        size,
+		DataKey:     wrapped,
+	})
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := NewEncryptingReader(r, dek, documentAssociatedData(ref, header))
+	if err != nil {
+		return "", err
+	}
+
+	headerLen := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	blob := io.MultiReader(bytes.NewReader(headerLen), bytes.NewReader(header), encrypted)
+	blobSize := int64(len(headerLen)+len(header)) + EncryptedStreamSize(size)
+	if err := s.blobs.Put(ctx, customerID+"/"+documentID, blob, blobSize); err != nil {
+		return "", fmt.Errorf("store document: %w", err)
+	}
+	return ref, nil
+}
+
+// Open returns the decrypted document behind ref. It returns ErrBlobNotFound
+// for unknown documents and ErrKeyShredded for erased customers.
+func (s *DocumentStore) Open(ctx context.Context, ref string) (*Document, error) {
+	customerID, documentID, err := ParseDocumentRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.blobs.Get(ctx, customerID+"/"+documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := s.open(ctx, ref, customerID, blob)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s *DocumentStore) open(ctx context.Context, ref, customerID string, blob io.ReadCloser) (*Document, error) {
+	var headerLen [4]byte
+	if _, err := io.ReadFull(blob, headerLen[:]); err != nil {
+		return nil, ErrStreamCorrupt
+	}
+	size := binary.BigEndian.Uint32(headerLen[:])
+	if size > maxDocumentHeaderSize {
+		return nil, ErrStreamCorrupt
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(blob, raw); err != nil {
+		return nil, ErrStreamCorrupt
+	}
+	var header documentHeader
+	if err := json.Unmarshal(raw, &header); err != nil || header.CustomerID != customerID {
+		return nil, ErrStreamCorrupt
+	}
+
+	provider, err := s.keys.ProviderFor(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := provider.UnwrapDataKey(ctx, header.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+
+	decrypted, err := NewDecryptingReader(blob, dek, documentAssociatedData(ref, raw))
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		ContentType: header.ContentType,
+		Size:        header.Size,
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{decrypted, blob},
+	}, nil
+}
+
+// documentAssociatedData binds a document's stream to its reference and header.
+func documentAssociatedData(ref string, header []byte) []byte {
+	return append([]byte(ref+"\x00"), header...)
+}
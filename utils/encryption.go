@@ -4,37 +4,231 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"strings"
 )
 
-// Encrypt encrypts plaintext using AES-256 encryption.
+// Ciphertext envelope layout (before base64 encoding):
+//
+//	version (1 byte) | key ID length (1 byte) | key ID | nonce | ciphertext
+//
+// Version 1 is randomized AES-256-GCM with a 12-byte nonce and the tag
+// appended to the ciphertext. Version 2 is deterministic AES-SIV, which has
+// no nonce; its ciphertext is the 16-byte synthetic IV followed by the
+// encrypted data. Version 3 is version 1 sealed with associated data, such
+// as the record and field a value belongs to, which is recorded after the key
+// ID, and only opens with that same associated data:
+//
+//	... | key ID | associated data length (2 bytes) | associated data | nonce | ciphertext
+//
+// so a value opened with the wrong associated data can be told apart from a
+// tampered one.
+//
+// Encoded envelopes carry envelopePrefix so Decrypt can tell them apart from
+// legacy hex encoded AES-CFB values, which never contain a colon.
+const (
+	envelopePrefix = "zp:"
+
+	envelopeVersionGCM      byte = 1
+	envelopeVersionSIV      byte = 2
+	envelopeVersionGCMBound byte = 3
+)
+
+var (
+	ErrInvalidKeySize    = errors.New("encryption key must be 32 bytes for AES-256")
+	ErrMalformedEnvelope = errors.New("malformed ciphertext envelope")
+	ErrUnsupportedCipher = errors.New("unsupported ciphertext envelope version")
+	ErrBindingMismatch   = errors.New("ciphertext belongs to another record or field")
+	ErrAuthFailed        = errors.New("ciphertext authentication failed")
+	ErrUnboundCiphertext = errors.New("ciphertext is not bound to a record and field")
+)
+
+// Envelope is the decoded form of an encrypted value.
+type Envelope struct {
+	Version byte
+	KeyID   string
+	// AssociatedData is the data a version 3 envelope was sealed with
+	AssociatedData []byte
+	Nonce          []byte
+	Ciphertext     []byte
+}
+
+// KeyID returns a short, stable identifier for a key so ciphertexts can record
+// which key produced them without revealing it.
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Encrypt encrypts plaintext with AES-256-GCM and returns an encoded envelope.
 func Encrypt(plaintext, key string) (string, error) {
-	block, err := aes.NewCipher([]byte(key))
+	return encryptGCM(plaintext, []byte(key), KeyID(key), nil)
+}
+
+// Decrypt decrypts a value produced by Encrypt. Legacy AES-CFB values are
+// still accepted so documents written before the envelope format keep working.
+func Decrypt(ciphertext, key string) (string, error) {
+	if !IsEnvelope(ciphertext) {
+		return decryptLegacyCFB(ciphertext, []byte(key))
+	}
+
+	env, err := ParseEnvelope(ciphertext)
 	if err != nil {
 		return "", err
 	}
+	if env.KeyID != KeyID(key) {
+		return "", fmt.Errorf("ciphertext was encrypted with key %q", env.KeyID)
+	}
+	if env.Version != envelopeVersionGCM {
+		return "", ErrUnsupportedCipher
+	}
+	return openGCM(env, []byte(key), nil)
+}
+
+// IsEnvelope reports whether value is in the versioned envelope format.
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+// ParseEnvelope decodes an envelope without decrypting it.
+func ParseEnvelope(value string) (*Envelope, error) {
+	if !IsEnvelope(value) {
+		return nil, ErrMalformedEnvelope
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, envelopePrefix))
+	if err != nil {
+		return nil, ErrMalformedEnvelope
+	}
+	if len(raw) < 2 {
+		return nil, ErrMalformedEnvelope
+	}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	env := &Envelope{Version: raw[0]}
+	var nonceSize int
+	switch env.Version {
+	case envelopeVersionGCM, envelopeVersionGCMBound:
+		nonceSize = gcmNonceSize
+	case envelopeVersionSIV:
+		nonceSize = 0
+	default:
+		return nil, ErrUnsupportedCipher
+	}
+
+	idLen := int(raw[1])
+	raw = raw[2:]
+	if len(raw) < idLen+nonceSize {
+		return nil, ErrMalformedEnvelope
+	}
+	env.KeyID = string(raw[:idLen])
+	raw = raw[idLen:]
+	if env.Version == envelopeVersionGCMBound {
+		if len(raw) < 2 {
+			return nil, ErrMalformedEnvelope
+		}
+		adLen := int(binary.BigEndian.Uint16(raw))
+		raw = raw[2:]
+		if len(raw) < adLen+nonceSize {
+			return nil, ErrMalformedEnvelope
+		}
+		env.AssociatedData = raw[:adLen]
+		raw = raw[adLen:]
+	}
+	env.Nonce = raw[:nonceSize]
+	env.Ciphertext = raw[nonceSize:]
+	return env, nil
+}
+
+// encode serializes the envelope into its string form.
+func (e *Envelope) encode() string {
+	raw := make([]byte, 0, 4+len(e.KeyID)+len(e.AssociatedData)+len(e.Nonce)+len(e.Ciphertext))
+	raw = append(raw, e.Version, byte(len(e.KeyID)))
+	raw = append(raw, e.KeyID...)
+	if e.Version == envelopeVersionGCMBound {
+		raw = binary.BigEndian.AppendUint16(raw, uint16(len(e.AssociatedData)))
+		raw = append(raw, e.AssociatedData...)
+	}
+	raw = append(raw, e.Nonce...)
+	raw = append(raw, e.Ciphertext...)
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(raw)
+}
+
+const gcmNonceSize = 12
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptGCM seals plaintext under key and wraps it in an envelope tagged with
+// keyID. Envelopes sealed with associated data get envelopeVersionGCMBound
+// and record it.
+func encryptGCM(plaintext string, key []byte, keyID string, aad []byte) (string, error) {
+	if len(keyID) > 255 {
+		return "", fmt.Errorf("key ID %q is too long", keyID)
+	}
+	if len(aad) > math.MaxUint16 {
+		return "", fmt.Errorf("associated data is too long")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
 		return "", err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], []byte(plaintext))
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
 
-	return hex.EncodeToString(ciphertext), nil
+	env := &Envelope{
+		Version:    envelopeVersionGCM,
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, []byte(plaintext), aad),
+	}
+	if aad != nil {
+		env.Version = envelopeVersionGCMBound
+		env.AssociatedData = aad
+	}
+	return env.encode(), nil
 }
 
-// Decrypt decrypts ciphertext using AES-256 decryption.
-func Decrypt(ciphertext, key string) (string, error) {
+// openGCM verifies and decrypts an envelope with key.
+func openGCM(env *Envelope, key []byte, aad []byte) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, aad)
+	if err != nil {
+		return "", ErrAuthFailed
+	}
+	return string(plaintext), nil
+}
+
+// decryptLegacyCFB decrypts hex encoded AES-CFB values written before the envelope format.
+func decryptLegacyCFB(ciphertext string, key []byte) (string, error) {
 	data, err := hex.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
 	}
+	if len(data) < aes.BlockSize {
+		return "", ErrMalformedEnvelope
+	}
 
-	block, err := aes.NewCipher([]byte(key))
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
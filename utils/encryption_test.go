@@ -0,0 +1,135 @@
+package utils_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"zeropii/utils"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+// tamper returns envelope with its raw bytes changed by edit.
+func tamper(t *testing.T, envelope string, edit func(raw []byte) []byte) string {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, "zp:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "zp:" + base64.RawURLEncoding.EncodeToString(edit(raw))
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, plaintext := range []string{"", "ethan.hunt@example.com", "Ω日本語"} {
+		ciphertext, err := utils.Encrypt(plaintext, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utils.IsEnvelope(ciphertext) {
+			t.Fatalf("Encrypt(%q) = %q, want an envelope", plaintext, ciphertext)
+		}
+		again, err := utils.Encrypt(plaintext, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again == ciphertext {
+			t.Errorf("Encrypt(%q) is not randomized", plaintext)
+		}
+		decrypted, err := utils.Decrypt(ciphertext, testKey)
+		if err != nil || decrypted != plaintext {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", plaintext, decrypted, err)
+		}
+	}
+}
+
+func TestEnvelopeLayout(t *testing.T) {
+	ciphertext, err := utils.Encrypt("secret", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := utils.ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != 1 || env.KeyID != utils.KeyID(testKey) || len(env.Nonce) != 12 || len(env.Ciphertext) != len("secret")+16 {
+		t.Fatalf("envelope = %+v", env)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	ciphertext, err := utils.Encrypt("secret", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.Decrypt(ciphertext, "fedcba9876543210fedcba9876543210"); err == nil {
+		t.Fatal("Decrypt with another key succeeded")
+	}
+	if _, err := utils.Encrypt("secret", "short"); !errors.Is(err, utils.ErrInvalidKeySize) {
+		t.Fatalf("Encrypt with a short key: %v, want ErrInvalidKeySize", err)
+	}
+}
+
+func TestDecryptRejectsDamagedEnvelopes(t *testing.T) {
+	ciphertext, err := utils.Encrypt("secret", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		ciphertext string
+		want       error
+	}{
+		{"flipped byte", tamper(t, ciphertext, func(raw []byte) []byte { raw[len(raw)-1] ^= 1; return raw }), nil},
+		{"truncated tag", tamper(t, ciphertext, func(raw []byte) []byte { return raw[:len(raw)-4] }), nil},
+		{"truncated header", tamper(t, ciphertext, func(raw []byte) []byte { return raw[:5] }), utils.ErrMalformedEnvelope},
+		{"empty", "zp:", utils.ErrMalformedEnvelope},
+		{"garbled base64", "zp:!!not base64!!", utils.ErrMalformedEnvelope},
+		{"unknown version", tamper(t, ciphertext, func(raw []byte) []byte { raw[0] = 9; return raw }), utils.ErrUnsupportedCipher},
+	}
+	for _, tt := range tests {
+		plaintext, err := utils.Decrypt(tt.ciphertext, testKey)
+		if err == nil {
+			t.Errorf("%s: Decrypt = %q, want an error", tt.name, plaintext)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: Decrypt error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// legacyCFB encrypts plaintext the way values were written before the
+// envelope format: hex(IV | AES-CFB ciphertext).
+func legacyCFB(t *testing.T, plaintext, key string) string {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, aes.BlockSize+len(plaintext))
+	copy(data, "0123456789abcdef") // fixed IV, for the test only
+	cipher.NewCFBEncrypter(block, data[:aes.BlockSize]).XORKeyStream(data[aes.BlockSize:], []byte(plaintext))
+	return hex.EncodeToString(data)
+}
+
+func TestDecryptLegacyCFB(t *testing.T) {
+	legacy := legacyCFB(t, "ethan.hunt@example.com", testKey)
+	if utils.IsEnvelope(legacy) {
+		t.Fatal("legacy value looks like an envelope")
+	}
+	plaintext, err := utils.Decrypt(legacy, testKey)
+	if err != nil || plaintext != "ethan.hunt@example.com" {
+		t.Fatalf("Decrypt(legacy) = %q, %v", plaintext, err)
+	}
+	if _, err := utils.Decrypt("abcd", testKey); err == nil {
+		t.Error("Decrypt accepted a legacy value shorter than its IV")
+	}
+	if _, err := utils.Decrypt("not hex", testKey); err == nil {
+		t.Error("Decrypt accepted a legacy value that is not hex")
+	}
+}
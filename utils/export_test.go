@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"net/http"
+	"time"
+)
+
+// Hooks for the tests and benchmarks in package utils_test, which load models
+// and so cannot live in package utils.
+
+// WalkPII calls visit with the path and value of every tagged string in data,
+// using the cached type plans.
+func WalkPII(data interface{}, visit func(path, value string)) error {
+	return walkPII(data, func(field piiField) error {
+		visit(field.Path, field.Value.String())
+		return nil
+	})
+}
+
+// LegacyWalkPII is WalkPII on the walker without type plans.
+func LegacyWalkPII(data interface{}, visit func(path, value string)) error {
+	return legacyWalkPII(data, func(field piiField) error {
+		visit(field.Path, field.Value.String())
+		return nil
+	})
+}
+
+// LegacySanitizeCustomerData is SanitizeCustomerData on the walker without
+// type plans.
+func LegacySanitizeCustomerData(v interface{}, viewer Viewer) {
+	policy := CurrentAccessPolicy()
+	_ = legacyWalkPII(v, func(field piiField) error {
+		if value, ok := sanitizedValue(policy, viewer, field.Path, field.Tag, field.Value.String()); ok {
+			field.Value.SetString(value)
+		}
+		return nil
+	})
+}
+
+// NewDataKeyring returns the keyring EncryptRecordPII derives from keyring for
+// a record with data key dek.
+func NewDataKeyring(keyring *Keyring, dek []byte) (*Keyring, error) {
+	return keyring.withDataKey(dataKeyID, dek)
+}
+
+// IsRandomized reports whether value is a randomized (GCM) envelope, which
+// differs every time the same plaintext is encrypted.
+func IsRandomized(value string) bool {
+	env, err := ParseEnvelope(value)
+	if err != nil {
+		return false
+	}
+	switch env.Version {
+	case envelopeVersionGCM, envelopeVersionGCMBound:
+		return true
+	}
+	return false
+}
+
+// SIVSeal and SIVOpen are the raw AES-SIV functions, for the RFC 5297 test
+// vectors in siv_test.go.
+func SIVSeal(key, plaintext []byte, associatedData ...[]byte) ([]byte, error) {
+	return sivSeal(key, plaintext, associatedData...)
+}
+
+func SIVOpen(key, ciphertext []byte, associatedData ...[]byte) ([]byte, error) {
+	return sivOpen(key, ciphertext, associatedData...)
+}
+
+// FF1Encrypt and FF1Decrypt run FF1 on a numeral string, for the NIST SP
+// 800-38G samples in ff1_test.go.
+func FF1Encrypt(key []byte, radix int, tweak []byte, x []int) ([]int, error) {
+	f, err := newFF1(key, radix)
+	if err != nil {
+		return nil, err
+	}
+	return f.encrypt(tweak, x)
+}
+
+func FF1Decrypt(key []byte, radix int, tweak []byte, x []int) ([]int, error) {
+	f, err := newFF1(key, radix)
+	if err != nil {
+		return nil, err
+	}
+	return f.decrypt(tweak, x)
+}
+
+// SignV4 is the request signer of S3BlobStore, for the AWS examples in
+// blobstore_s3_test.go.
+func SignV4(req *http.Request, payloadHash, accessKeyID, secretKey, region, service string, now time.Time) {
+	signV4(req, payloadHash, accessKeyID, secretKey, region, service, now)
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+)
+
+// FF1 format-preserving encryption, NIST SP 800-38G. Numeral strings are
+// slices of digits in [0, radix); the output has the same radix and length.
+
+const ff1Rounds = 10
+
+var errFF1Domain = errors.New("ff1: input domain too small")
+
+type ff1 struct {
+	block cipher.Block
+	radix int
+}
+
+func newFF1(key []byte, radix int) (*ff1, error) {
+	if radix < 2 || radix > 1<<16 {
+		return nil, errors.New("ff1: radix out of range")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ff1{block: block, radix: radix}, nil
+}
+
+// ff1DomainOK reports whether a numeral string of length n is long enough,
+// i.e. radix^n >= 1,000,000 as required by SP 800-38G Rev. 1. Smaller
+// domains can be enumerated.
+func ff1DomainOK(radix, n int) bool {
+	return n >= 2 && math.Pow(float64(radix), float64(n)) >= 1e6
+}
+
+func (f *ff1) encrypt(tweak []byte, x []int) ([]int, error) {
+	return f.cipher(tweak, x, true)
+}
+
+func (f *ff1) decrypt(tweak []byte, x []int) ([]int, error) {
+	return f.cipher(tweak, x, false)
+}
+
+func (f *ff1) cipher(tweak []byte, x []int, encrypt bool) ([]int, error) {
+	n := len(x)
+	if !ff1DomainOK(f.radix, n) {
+		return nil, errFF1Domain
+	}
+
+	u := n / 2
+	v := n - u
+	a := append([]int(nil), x[:u]...)
+	b := append([]int(nil), x[u:]...)
+
+	// Byte lengths of the round input and output
+	bLen := int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(f.radix))) / 8))
+	d := 4*((bLen+3)/4) + 4
+
+	p := make([]byte, 16)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3] = byte(f.radix >> 16)
+	p[4] = byte(f.radix >> 8)
+	p[5] = byte(f.radix)
+	p[6] = 10
+	p[7] = byte(u)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(len(tweak)))
+
+	padLen := ((-len(tweak)-bLen-1)%16 + 16) % 16
+	q := make([]byte, len(tweak)+padLen+1+bLen)
+	copy(q, tweak)
+
+	radix := big.NewInt(int64(f.radix))
+	uMod := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	vMod := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+
+	for round := 0; round < ff1Rounds; round++ {
+		i := round
+		if !encrypt {
+			i = ff1Rounds - 1 - round
+		}
+
+		// Q = T || 0^pad || [i]^1 || [NUM(B or A)]^b
+		q[len(tweak)+padLen] = byte(i)
+		roundInput := b
+		if !encrypt {
+			roundInput = a
+		}
+		numToRadix(roundInput, f.radix).FillBytes(q[len(q)-bLen:])
+
+		y := new(big.Int).SetBytes(f.expand(f.prf(p, q), d))
+
+		m, mod := uMod, u
+		if i%2 == 1 {
+			m, mod = vMod, v
+		}
+
+		if encrypt {
+			c := new(big.Int).Add(numToRadix(a, f.radix), y)
+			c.Mod(c, m)
+			a, b = b, radixToNum(c, f.radix, mod)
+		} else {
+			c := new(big.Int).Sub(numToRadix(b, f.radix), y)
+			c.Mod(c, m)
+			a, b = radixToNum(c, f.radix, mod), a
+		}
+	}
+
+	return append(a, b...), nil
+}
+
+// prf is AES CBC-MAC with a zero IV over P || Q.
+func (f *ff1) prf(p, q []byte) []byte {
+	y := make([]byte, 16)
+	for _, block := range [][]byte{p, q} {
+		for off := 0; off < len(block); off += 16 {
+			xorInto(y, block[off:off+16])
+			f.block.Encrypt(y, y)
+		}
+	}
+	return y
+}
+
+// expand returns the first d bytes of R || CIPH(R ^ [1]) || CIPH(R ^ [2]) ...
+func (f *ff1) expand(r []byte, d int) []byte {
+	s := append([]byte(nil), r...)
+	for j := 1; len(s) < d; j++ {
+		block := append([]byte(nil), r...)
+		var counter [16]byte
+		binary.BigEndian.PutUint64(counter[8:], uint64(j))
+		xorInto(block, counter[:])
+		f.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
+
+// numToRadix interprets x as a big-endian number in the given radix.
+func numToRadix(x []int, radix int) *big.Int {
+	n := new(big.Int)
+	r := big.NewInt(int64(radix))
+	for _, digit := range x {
+		n.Mul(n, r)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	return n
+}
+
+// radixToNum writes n as exactly m big-endian digits in the given radix.
+func radixToNum(n *big.Int, radix, m int) []int {
+	out := make([]int, m)
+	r := big.NewInt(int64(radix))
+	x := new(big.Int).Set(n)
+	digit := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		x.DivMod(x, r, digit)
+		out[i] = int(digit.Int64())
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"zeropii/utils"
+)
+
+const base36 = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func numerals(s string) []int {
+	x := make([]int, len(s))
+	for i, r := range s {
+		x[i] = strings.IndexRune(base36, r)
+	}
+	return x
+}
+
+func numeralString(x []int) string {
+	var b strings.Builder
+	for _, d := range x {
+		b.WriteByte(base36[d])
+	}
+	return b.String()
+}
+
+// The FF1 samples published by NIST for SP 800-38G.
+func TestFF1Samples(t *testing.T) {
+	const (
+		key128 = "2b7e151628aed2a6abf7158809cf4f3c"
+		key192 = key128 + "ef4359d8d580aa4f"
+		key256 = key192 + "7f036d6f04fc6a94"
+	)
+	samples := []struct {
+		name       string
+		key        string
+		radix      int
+		tweak      string
+		plaintext  string
+		ciphertext string
+	}{
+		{"1", key128, 10, "", "0123456789", "2433477484"},
+		{"2", key128, 10, "39383736353433323130", "0123456789", "6124200773"},
+		{"3", key128, 36, "3737373770717273373737", "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+		{"4", key192, 10, "", "0123456789", "2830668132"},
+		{"5", key192, 10, "39383736353433323130", "0123456789", "2496655549"},
+		{"6", key192, 36, "3737373770717273373737", "0123456789abcdefghi", "xbj3kv35jrawxv32ysr"},
+		{"7", key256, 10, "", "0123456789", "6657667009"},
+		{"8", key256, 10, "39383736353433323130", "0123456789", "1001623463"},
+		{"9", key256, 36, "3737373770717273373737", "0123456789abcdefghi", "xs8a0azh2avyalyzuwd"},
+	}
+	for _, s := range samples {
+		t.Run("sample "+s.name, func(t *testing.T) {
+			key, tweak := unhex(t, s.key), unhex(t, s.tweak)
+
+			got, err := utils.FF1Encrypt(key, s.radix, tweak, numerals(s.plaintext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numeralString(got) != s.ciphertext {
+				t.Fatalf("encrypt = %s, want %s", numeralString(got), s.ciphertext)
+			}
+			back, err := utils.FF1Decrypt(key, s.radix, tweak, got)
+			if err != nil || numeralString(back) != s.plaintext {
+				t.Fatalf("decrypt = %s, %v, want %s", numeralString(back), err, s.plaintext)
+			}
+		})
+	}
+}
+
+func TestFF1RejectsSmallDomains(t *testing.T) {
+	key := unhex(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	// 10^5 and 26^4 are below the minimum domain of 1,000,000 values
+	for _, tt := range []struct {
+		radix int
+		x     []int
+	}{{10, []int{1, 2, 3, 4}}, {10, []int{1, 2, 3, 4, 5}}, {26, []int{3, 1, 4, 1}}, {10, nil}} {
+		if _, err := utils.FF1Encrypt(key, tt.radix, nil, tt.x); err == nil {
+			t.Errorf("encrypt accepted %d numerals of radix %d", len(tt.x), tt.radix)
+		}
+	}
+	if _, err := utils.FF1Encrypt(key, 10, nil, []int{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Errorf("encrypt rejected 6 decimal numerals: %v", err)
+	}
+	if _, err := utils.FF1Encrypt(key, 26, nil, []int{3, 1, 4, 1, 5}); err != nil {
+		t.Errorf("encrypt rejected 5 letters: %v", err)
+	}
+}
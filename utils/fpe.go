@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrFPERequiresDataKey = errors.New("format-preserving encryption requires a per-record data key")
+	ErrFPEValueTooShort   = errors.New("value too short for format-preserving encryption")
+)
+
+// fpeClass is a set of characters encrypted together with FF1. Characters
+// outside every class (spaces, '+', '-', ...) are left in place.
+type fpeClass struct {
+	name     string
+	first    rune
+	radix    int
+	contains func(r rune) bool
+}
+
+var fpeClasses = []fpeClass{
+	{name: "digit", first: '0', radix: 10, contains: func(r rune) bool { return r >= '0' && r <= '9' }},
+	{name: "upper", first: 'A', radix: 26, contains: func(r rune) bool { return r >= 'A' && r <= 'Z' }},
+	{name: "lower", first: 'a', radix: 26, contains: func(r rune) bool { return r >= 'a' && r <= 'z' }},
+}
+
+// EncryptFormatPreserving encrypts value with FF1 so the result keeps its
+// length and the character class of every position: digits stay digits,
+// upper and lower case letters stay letters, and punctuation is unchanged.
+// A PAN such as ABCDE1234F keeps the AAAAA9999A shape and a phone number
+// stays all digits.
+//
+// FF1 output carries no key ID or authentication tag, so it is only
+// available on per-record keyrings, where the key is the record's data key
+// and never rotates; elsewhere it fails with ErrFPERequiresDataKey. All the
+// characters of a class are encrypted together, so the six letters of a PAN
+// form one string, but a class with fewer than 1,000,000 possible values,
+// such as the four digits of a PAN, could be enumerated; a value holding one
+// fails with ErrFPEValueTooShort. Fields tagged fpe are encrypted randomized
+// in both cases.
+func (k *Keyring) EncryptFormatPreserving(value string, tweak []byte) (string, error) {
+	return k.formatPreserving(value, tweak, true)
+}
+
+// DecryptFormatPreserving reverses EncryptFormatPreserving with the same tweak.
+func (k *Keyring) DecryptFormatPreserving(value string, tweak []byte) (string, error) {
+	return k.formatPreserving(value, tweak, false)
+}
+
+func (k *Keyring) formatPreserving(value string, tweak []byte, encrypt bool) (string, error) {
+	if k.shared == nil {
+		return "", ErrFPERequiresDataKey
+	}
+	key, err := k.key(k.ActiveKeyID())
+	if err != nil {
+		return "", err
+	}
+
+	subkey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("zeropii ff1")), subkey); err != nil {
+		return "", err
+	}
+	defer clear(subkey)
+
+	runes := []rune(value)
+	for _, class := range fpeClasses {
+		var positions []int
+		var numerals []int
+		for i, r := range runes {
+			if class.contains(r) {
+				positions = append(positions, i)
+				numerals = append(numerals, int(r-class.first))
+			}
+		}
+		if len(numerals) == 0 {
+			continue
+		}
+		if !ff1DomainOK(class.radix, len(numerals)) {
+			return "", ErrFPEValueTooShort
+		}
+
+		f, err := newFF1(subkey, class.radix)
+		if err != nil {
+			return "", err
+		}
+		classTweak := append([]byte(class.name+":"), tweak...)
+		var out []int
+		if encrypt {
+			out, err = f.encrypt(classTweak, numerals)
+		} else {
+			out, err = f.decrypt(classTweak, numerals)
+		}
+		if err != nil {
+			return "", fmt.Errorf("format-preserving encryption: %w", err)
+		}
+		for j, pos := range positions {
+			runes[pos] = class.first + rune(out[j])
+		}
+	}
+	return string(runes), nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PIIField is a string field tagged pii:"true" as listed by code generated
+// with zeropii-gen (see cmd/zeropii-gen). Generated methods hand every such
+// field to EncryptPIIFields, DecryptPIIFields, MaskPIIFields or
+// PseudonymizePIIFields, which apply exactly what EncryptStructPII,
+// DecryptStructPII, SanitizeCustomerData and PseudonymizePII apply to the
+// same field, without reflection.
+type PIIField struct {
+	// Path is the dotted path of the field from the record, as walkPII
+	// reports it
+	Path string
+	// Name is the Go name of the struct field
+	Name string
+	// Tag is the field's pii tag, parsed and validated by zeropii-gen
+	Tag   PIIDescriptor
+	Value *string
+	// Index is the sibling <Name>Index field, or nil if the struct has none
+	Index *string
+}
+
+// PIIFieldWalker calls visit for every tagged field of a record, in struct
+// field order, and stops at the first error.
+type PIIFieldWalker func(visit func(field PIIField) error) error
+
+// PIIRecord is implemented by models with generated PII methods.
+// EncryptRecordPII and DecryptRecordPII use them when available.
+type PIIRecord interface {
+	EncryptPII(keyring *Keyring) error
+	DecryptPII(keyring *Keyring) error
+	MaskPII(viewer Viewer)
+	PseudonymizePII(p *Pseudonymizer)
+}
+
+// EncryptPIIFields encrypts the fields of the record recordID as EncryptStructPII does.
+func EncryptPIIFields(keyring *Keyring, recordID string, walk PIIFieldWalker) error {
+	return walk(func(field PIIField) error {
+		tag := field.Tag
+		// Write the blind index before the plaintext is replaced
+		if tag.Index {
+			if field.Index == nil {
+				return errMissingIndexField(field.Name)
+			}
+			index, err := blindIndexOf(field.Name, tag.Category, *field.Value, keyring)
+			if err != nil {
+				return err
+			}
+			*field.Index = index
+		}
+
+		encryptedValue, err := encryptField(keyring, recordID, field.Path, field.Name, tag, *field.Value)
+		if err != nil {
+			return err
+		}
+		*field.Value = encryptedValue
+		return nil
+	})
+}
+
+// DecryptPIIFields decrypts the fields of the record recordID as DecryptStructPII does.
+func DecryptPIIFields(keyring *Keyring, recordID string, walk PIIFieldWalker) error {
+	return walk(func(field PIIField) error {
+		decryptedValue, err := decryptField(keyring, recordID, field.Path, field.Name, field.Tag, *field.Value)
+		if err != nil {
+			return err
+		}
+		*field.Value = decryptedValue
+		return nil
+	})
+}
+
+// MaskPIIFields masks the fields a viewer may not see as SanitizeCustomerData does.
+func MaskPIIFields(viewer Viewer, walk PIIFieldWalker) {
+	policy := CurrentAccessPolicy()
+	_ = walk(func(field PIIField) error {
+		if value, ok := sanitizedValue(policy, viewer, field.Path, field.Tag, *field.Value); ok {
+			*field.Value = value
+		}
+		return nil
+	})
+}
+
+// PseudonymizePIIFields replaces every field with its pseudonym as PseudonymizePII does.
+func PseudonymizePIIFields(p *Pseudonymizer, walk PIIFieldWalker) {
+	_ = walk(func(field PIIField) error {
+		*field.Value = p.Pseudonym(field.Tag.Category, *field.Value)
+		return nil
+	})
+}
+
+// SortedKeys returns the keys of m in the order walkPII visits them, for
+// generated code walking maps.
+func SortedKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
@@ -0,0 +1,306 @@
+package utils_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeropii/utils"
+	"zeropii/utils/internal/piifixture"
+)
+
+// The tests below check that methods generated by zeropii-gen behave exactly
+// like the reflection functions on the same data: same fields, same paths,
+// same ciphertexts where encryption is deterministic, interchangeable
+// ciphertexts where it is not, and the same errors.
+
+type fakeTokenizer struct{}
+
+func (fakeTokenizer) Tokenize(field, value string, format utils.TokenFormat) (string, error) {
+	return "tok:" + value, nil
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKeyring returns a record keyring with a data key and a tokenizer.
+func testKeyring(t *testing.T) *utils.Keyring {
+	t.Helper()
+	keyring, err := utils.NewDataKeyring(baseKeyring(t), randomKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring.WithTokenizer(fakeTokenizer{})
+}
+
+func baseKeyring(t *testing.T) *utils.Keyring {
+	t.Helper()
+	keyring := utils.NewKeyring()
+	if err := keyring.Add("k1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActive("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetIndexKey(randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func newRecord(t *testing.T) utils.PIIRecord {
+	nickname := "ethan"
+	record := &piifixture.Record{
+		ID:       "rec-1",
+		Email:    "ethan.hunt@example.com",
+		Nickname: &nickname,
+		Aliases:  []string{"Hunt", "E.H."},
+		Labels:   map[string]string{"home": "1 MG Road", "work": "IMF HQ"},
+		Codes:    [2]string{"ABCDE1234F", "9876543210"},
+		Status:   "verified",
+		Passport: "N1111111",
+		Untagged: "left alone",
+		Contact:  &piifixture.Contact{Name: "Luther", Phone: "+1234567890", Note: "tech"},
+		Contacts: []*piifixture.Contact{
+			{Name: "Benji", Phone: "+1987654321"},
+			nil,
+			{Name: "Ilsa", Phone: "+4420794600"},
+		},
+		ByKind: map[string]piifixture.Contact{
+			"emergency": {Name: "Julia", Phone: "+1555012345"},
+		},
+		Groups: map[int][]piifixture.Contact{
+			2:  {{Name: "Brandt", Phone: "+1555098765"}},
+			10: {{Name: "Hanley", Phone: "+1555011111"}},
+		},
+	}
+	record.Nested.Secret = "the rabbit's foot"
+	record.Nested.Public = "public"
+	return record
+}
+
+func newCustomer(t *testing.T) utils.PIIRecord {
+	customer := loadSampleCustomer(t)
+	customer.ID = "cust-1"
+	return customer
+}
+
+var piiModels = []struct {
+	name string
+	new  func(t *testing.T) utils.PIIRecord
+}{
+	{"Customer", newCustomer},
+	{"Record", newRecord},
+}
+
+func TestGeneratedEncryptMatchesReflection(t *testing.T) {
+	for _, model := range piiModels {
+		t.Run(model.name, func(t *testing.T) {
+			keyring := testKeyring(t)
+			original := model.new(t)
+
+			reflected := model.new(t)
+			if err := utils.EncryptStructPII(reflected, keyring); err != nil {
+				t.Fatal(err)
+			}
+			generated := model.new(t)
+			if err := generated.EncryptPII(keyring); err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(generated, original) {
+				t.Fatal("EncryptPII left the record unchanged")
+			}
+			// Randomized ciphertexts differ between runs; everything else,
+			// including blind indexes, FPE values and tokens, must be equal
+			if !reflect.DeepEqual(normalized(t, generated), normalized(t, reflected)) {
+				t.Fatalf("generated encryption differs from reflection:\n%+v\n%+v", generated, reflected)
+			}
+
+			// Ciphertexts are bound to record and field paths, so decrypting
+			// each with the other path proves the paths agree
+			if err := generated.DecryptPII(keyring); err != nil {
+				t.Fatal(err)
+			}
+			if err := utils.DecryptStructPII(reflected, keyring); err != nil {
+				t.Fatal(err)
+			}
+			reflectedCopy := model.new(t)
+			if err := utils.EncryptStructPII(reflectedCopy, keyring); err != nil {
+				t.Fatal(err)
+			}
+			if err := reflectedCopy.DecryptPII(keyring); err != nil {
+				t.Fatal(err)
+			}
+			generatedCopy := model.new(t)
+			if err := generatedCopy.EncryptPII(keyring); err != nil {
+				t.Fatal(err)
+			}
+			if err := utils.DecryptStructPII(generatedCopy, keyring); err != nil {
+				t.Fatal(err)
+			}
+			// Tokens stay in place when decrypting
+			want := piiValues(t, original)
+			for _, decrypted := range []utils.PIIRecord{generated, reflected, reflectedCopy, generatedCopy} {
+				got := piiValues(t, decrypted)
+				for i := range got {
+					got[i] = strings.Replace(got[i], "=tok:", "=", 1)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("round trip gave\n%q\nwant\n%q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratedErrorsMatchReflection(t *testing.T) {
+	for _, model := range piiModels {
+		t.Run(model.name, func(t *testing.T) {
+			// Without a tokenizer the token field fails
+			keyring, err := utils.NewDataKeyring(baseKeyring(t), randomKey(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reflectedErr := utils.EncryptStructPII(model.new(t), keyring)
+			generatedErr := model.new(t).EncryptPII(keyring)
+
+			var fieldErr *utils.FieldError
+			if !errors.As(generatedErr, &fieldErr) || !errors.Is(generatedErr, utils.ErrNoTokenizer) {
+				t.Fatalf("EncryptPII error = %v, want a *FieldError wrapping ErrNoTokenizer", generatedErr)
+			}
+			if reflectedErr == nil || generatedErr.Error() != reflectedErr.Error() {
+				t.Fatalf("EncryptPII error = %v, reflection error = %v", generatedErr, reflectedErr)
+			}
+		})
+	}
+}
+
+// testViewers pairs every role of the default policy with every purpose,
+// with and without consent.
+var testViewers = func() []utils.Viewer {
+	var viewers []utils.Viewer
+	for _, role := range []string{"admin", "manager", "support", ""} {
+		for _, purpose := range []string{"kyc_review", "fraud", "support", ""} {
+			for _, consented := range []bool{true, false} {
+				viewers = append(viewers, utils.Viewer{Role: role, Purpose: purpose, Consented: consented})
+			}
+		}
+	}
+	return viewers
+}()
+
+func TestGeneratedMaskMatchesReflection(t *testing.T) {
+	for _, model := range piiModels {
+		for _, viewer := range testViewers {
+			t.Run(fmt.Sprintf("%s/%s/%s/%t", model.name, viewer.Role, viewer.Purpose, viewer.Consented), func(t *testing.T) {
+				reflected := model.new(t)
+				utils.SanitizeCustomerData(reflected, viewer)
+				generated := model.new(t)
+				generated.MaskPII(viewer)
+
+				if !reflect.DeepEqual(generated, reflected) {
+					t.Fatalf("MaskPII gave\n%+v\nSanitizeCustomerData gave\n%+v", generated, reflected)
+				}
+			})
+		}
+	}
+}
+
+func TestGeneratedPseudonymizeMatchesReflection(t *testing.T) {
+	pseudonymizer, err := utils.NewPseudonymizer(randomKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range piiModels {
+		t.Run(model.name, func(t *testing.T) {
+			reflected := model.new(t)
+			utils.PseudonymizePII(reflected, pseudonymizer)
+			generated := model.new(t)
+			generated.PseudonymizePII(pseudonymizer)
+
+			if reflect.DeepEqual(generated, model.new(t)) {
+				t.Fatal("PseudonymizePII left the record unchanged")
+			}
+			if !reflect.DeepEqual(generated, reflected) {
+				t.Fatalf("PseudonymizePII gave\n%+v\nreflection gave\n%+v", generated, reflected)
+			}
+		})
+	}
+}
+
+// piiValues lists the tagged fields of record as path=value.
+func piiValues(t *testing.T, record utils.PIIRecord) []string {
+	t.Helper()
+	var values []string
+	if err := utils.WalkPII(record, func(path, value string) { values = append(values, path+"="+value) }); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// normalized returns a copy of record with every randomized envelope
+// replaced by a placeholder.
+func normalized(t *testing.T, record utils.PIIRecord) interface{} {
+	t.Helper()
+	copied := reflect.New(reflect.TypeOf(record).Elem())
+	copied.Elem().Set(reflect.ValueOf(record).Elem())
+	normalize(copied.Elem())
+	return copied.Interface()
+}
+
+func normalize(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() && utils.IsRandomized(v.String()) {
+			v.SetString("<randomized>")
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			// Copy so the original record is left untouched
+			copied := reflect.New(v.Type().Elem())
+			copied.Elem().Set(v.Elem())
+			normalize(copied.Elem())
+			v.Set(copied)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				normalize(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(copied, v)
+			for i := 0; i < copied.Len(); i++ {
+				normalize(copied.Index(i))
+			}
+			v.Set(copied)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalize(v.Index(i))
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			copied := reflect.MakeMap(v.Type())
+			for _, key := range v.MapKeys() {
+				value := reflect.New(v.Type().Elem()).Elem()
+				value.Set(v.MapIndex(key))
+				normalize(value)
+				copied.SetMapIndex(key, value)
+			}
+			v.Set(copied)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package piifixture declares models using every field shape zeropii-gen
+// supports, to check generated methods against the reflection functions.
+package piifixture
+
+import "time"
+
+//go:generate go run zeropii/cmd/zeropii-gen -type Record
+
+type Record struct {
+	ID         string             `json:"id" bson:"_id"`
+	Email      string             `json:"email" pii:"true,index"`
+	EmailIndex string             `json:"email_index"`
+	Nickname   *string            `json:"nickname" pii:"true"`
+	Aliases    []string           `json:"aliases" pii:"true,deterministic"`
+	Labels     map[string]string  `json:"labels" pii:"true"`
+	Codes      [2]string          `json:"codes" pii:"true,fpe"`
+	Status     Status             `json:"status" pii:"true"`
+	Passport   string             `json:"passport" pii:"true,token"`
+	Untagged   string             `json:"untagged"`
+	Contact    *Contact           `json:"contact"`
+	Contacts   []*Contact         `json:"contacts"`
+	ByKind     map[string]Contact `json:"by_kind"`
+	Groups     map[int][]Contact  `json:"groups"`
+	Nested     struct {
+		Secret string `json:"secret" pii:"true"`
+		Public string `json:"public"`
+	} `json:"nested"`
+	CreatedDate time.Time `json:"created_date"`
+	hidden      string    `pii:"true"`
+}
+
+// Status is a named string type.
+type Status string
+
+type Contact struct {
+	Name       string `json:"name" pii:"true"`
+	Phone      string `json:"phone" pii:"true,index,fpe"`
+	PhoneIndex string `json:"phone_index"`
+	Note       string `json:"note"`
+}
@@ -0,0 +1,112 @@
+// Code generated by zeropii-gen; DO NOT EDIT.
+
+package piifixture
+
+import (
+	"fmt"
+	"zeropii/utils"
+)
+
+// EncryptPII encrypts the fields of the Record tagged pii:"true", as
+// utils.EncryptStructPII does.
+func (r *Record) EncryptPII(keyring *utils.Keyring) error {
+	return utils.EncryptPIIFields(keyring, r.ID, r.walkPII)
+}
+
+// DecryptPII decrypts the fields of the Record tagged pii:"true", as
+// utils.DecryptStructPII does.
+func (r *Record) DecryptPII(keyring *utils.Keyring) error {
+	return utils.DecryptPIIFields(keyring, r.ID, r.walkPII)
+}
+
+// MaskPII masks the fields of the Record that viewer may not see, as
+// utils.SanitizeCustomerData does.
+func (r *Record) MaskPII(viewer utils.Viewer) {
+	utils.MaskPIIFields(viewer, r.walkPII)
+}
+
+// PseudonymizePII replaces the fields of the Record tagged pii:"true" with
+// their pseudonyms under pseudonymizer, as utils.PseudonymizePII does.
+func (r *Record) PseudonymizePII(pseudonymizer *utils.Pseudonymizer) {
+	utils.PseudonymizePIIFields(pseudonymizer, r.walkPII)
+}
+
+func (r *Record) walkPII(visit func(utils.PIIField) error) error {
+	return r.visitPII("", visit)
+}
+
+func (r *Record) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "Email", Name: "Email", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Index: true, Level: "high"}, Value: &r.Email, Index: &r.EmailIndex}); err != nil {
+		return err
+	}
+	if r.Nickname != nil {
+		if err := visit(utils.PIIField{Path: prefix + "Nickname", Name: "Nickname", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Level: "high"}, Value: r.Nickname}); err != nil {
+			return err
+		}
+	}
+	for idx := range r.Aliases {
+		if err := visit(utils.PIIField{Path: prefix + "Aliases", Name: "Aliases", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "deterministic", Level: "high"}, Value: &r.Aliases[idx]}); err != nil {
+			return err
+		}
+	}
+	for _, key := range utils.SortedKeys(r.Labels) {
+		val := r.Labels[key]
+		if err := visit(utils.PIIField{Path: prefix + "Labels." + fmt.Sprint(key), Name: "Labels", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Level: "high"}, Value: &val}); err != nil {
+			return err
+		}
+		r.Labels[key] = val
+	}
+	for idx := range r.Codes {
+		if err := visit(utils.PIIField{Path: prefix + "Codes", Name: "Codes", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "fpe", Level: "high"}, Value: &r.Codes[idx]}); err != nil {
+			return err
+		}
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Status", Name: "Status", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Level: "high"}, Value: (*string)(&r.Status)}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Passport", Name: "Passport", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "token", Level: "high"}, Value: &r.Passport}); err != nil {
+		return err
+	}
+	if r.Contact != nil {
+		if err := r.Contact.visitPII(prefix+"Contact.", visit); err != nil {
+			return err
+		}
+	}
+	for idx := range r.Contacts {
+		if r.Contacts[idx] != nil {
+			if err := r.Contacts[idx].visitPII(prefix+"Contacts.", visit); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range utils.SortedKeys(r.ByKind) {
+		val := r.ByKind[key]
+		if err := val.visitPII(prefix+"ByKind."+fmt.Sprint(key)+".", visit); err != nil {
+			return err
+		}
+		r.ByKind[key] = val
+	}
+	for _, key := range utils.SortedKeys(r.Groups) {
+		val := r.Groups[key]
+		for idx1 := range val {
+			if err := val[idx1].visitPII(prefix+"Groups."+fmt.Sprint(key)+".", visit); err != nil {
+				return err
+			}
+		}
+		r.Groups[key] = val
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Nested.Secret", Name: "Secret", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Level: "high"}, Value: &r.Nested.Secret}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Contact) visitPII(prefix string, visit func(utils.PIIField) error) error {
+	if err := visit(utils.PIIField{Path: prefix + "Name", Name: "Name", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "randomized", Level: "high"}, Value: &c.Name}); err != nil {
+		return err
+	}
+	if err := visit(utils.PIIField{Path: prefix + "Phone", Name: "Phone", Tag: utils.PIIDescriptor{Enabled: true, Category: "other", Mask: "full", Enc: "fpe", Index: true, Level: "high"}, Value: &c.Phone, Index: &c.PhoneIndex}); err != nil {
+		return err
+	}
+	return nil
+}
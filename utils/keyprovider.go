@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const dataKeySize = 32
+
+// WrappedKey is a data encryption key (DEK) encrypted under a key-encryption
+// key (KEK). It is stored alongside the record whose fields the DEK encrypts.
+type WrappedKey struct {
+	KeyID      string `json:"key_id" bson:"key_id"`
+	Ciphertext string `json:"ciphertext" bson:"ciphertext"`
+}
+
+// KeyProvider generates, wraps and unwraps data keys with a key-encryption
+// key that never leaves the provider.
+type KeyProvider interface {
+	// GenerateDataKey returns a new plaintext DEK and the same key wrapped by the KEK.
+	GenerateDataKey(ctx context.Context) ([]byte, *WrappedKey, error)
+	// WrapDataKey wraps an existing key with the current KEK.
+	WrapDataKey(ctx context.Context, key []byte) (*WrappedKey, error)
+	// UnwrapDataKey recovers the plaintext DEK from a wrapped key.
+	UnwrapDataKey(ctx context.Context, wrapped *WrappedKey) ([]byte, error)
+	// RewrapDataKey re-wraps a wrapped key with the current KEK without
+	// returning the plaintext to the caller.
+	RewrapDataKey(ctx context.Context, wrapped *WrappedKey) (*WrappedKey, error)
+}
+
+// NewKeyProviderFromEnv selects the KEK provider named by KEY_PROVIDER:
+//
+//	file   (default) LocalKeyProvider backed by the JSON key file at KEK_FILE
+//	env    LocalKeyProvider backed by KEK_KEYS and KEK_ACTIVE_KEY_ID
+//	vault  VaultTransitProvider using VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE,
+//	       VAULT_TRANSIT_MOUNT and VAULT_TRANSIT_KEY
+func NewKeyProviderFromEnv() (KeyProvider, error) {
+	switch provider := os.Getenv("KEY_PROVIDER"); provider {
+	case "", "file":
+		keyFile := os.Getenv("KEK_FILE")
+		if keyFile == "" {
+			keyFile = "kek.json"
+		}
+		return NewLocalKeyProvider(keyFile)
+	case "env":
+		return NewEnvKeyProvider()
+	case "vault":
+		return NewVaultTransitProvider(VaultConfig{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Mount:     os.Getenv("VAULT_TRANSIT_MOUNT"),
+			KeyName:   os.Getenv("VAULT_TRANSIT_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown KEY_PROVIDER %q", provider)
+	}
+}
+
+// dataKeyID is the key ID recorded in envelopes encrypted with a record's DEK.
+const dataKeyID = "dek"
+
+// EncryptRecordPII encrypts the PII fields of data with a freshly generated
+// data key and returns that key wrapped by the provider, to be stored with
+// the record. Keys shared across records, such as the blind index key, come
+// from keyring.
+func EncryptRecordPII(ctx context.Context, data interface{}, keyring *Keyring, provider KeyProvider) (*WrappedKey, error) {
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	defer clear(dek)
+
+	kr, err := keyring.withDataKey(dataKeyID, dek)
+	if err != nil {
+		return nil, err
+	}
+	if err := encryptPII(data, kr); err != nil {
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// DecryptRecordPII unwraps the record's data key once and decrypts its PII
+// fields with it.
+func DecryptRecordPII(ctx context.Context, data interface{}, wrapped *WrappedKey, keyring *Keyring, provider KeyProvider) error {
+	dek, err := provider.UnwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return err
+	}
+	defer clear(dek)
+
+	kr, err := keyring.withDataKey(dataKeyID, dek)
+	if err != nil {
+		return err
+	}
+	return decryptPII(data, kr)
+}
+
+// RotateRecordPII unwraps the record's data key and re-encrypts the fields of
+// data still encrypted with retired keyring keys, as RotateStructPII does.
+// It reports whether any field changed.
+func RotateRecordPII(ctx context.Context, data interface{}, wrapped *WrappedKey, keyring *Keyring, provider KeyProvider) (bool, error) {
+	dek, err := provider.UnwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return false, err
+	}
+	defer clear(dek)
+
+	kr, err := keyring.withDataKey(dataKeyID, dek)
+	if err != nil {
+		return false, err
+	}
+	return RotateStructPII(data, kr)
+}
+
+// encryptPII encrypts data with its generated EncryptPII method if it has
+// one, and by reflection otherwise.
+func encryptPII(data interface{}, keyring *Keyring) error {
+	if record, ok := data.(PIIRecord); ok {
+		return record.EncryptPII(keyring)
+	}
+	return EncryptStructPII(data, keyring)
+}
+
+// decryptPII is the decrypting counterpart of encryptPII.
+func decryptPII(data interface{}, keyring *Keyring) error {
+	if record, ok := data.(PIIRecord); ok {
+		return record.DecryptPII(keyring)
+	}
+	return DecryptStructPII(data, keyring)
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LocalKeyProvider keeps KEKs in process memory, loaded from a JSON key file
+// or the environment, so envelope encryption works without a cloud KMS.
+type LocalKeyProvider struct {
+	keyring *Keyring
+}
+
+// localKeyFile is the on-disk format of a LocalKeyProvider key file.
+type localKeyFile struct {
+	ActiveKeyID string            `json:"active_key_id"`
+	Keys        map[string]string `json:"keys"` // key ID -> base64 encoded 32-byte KEK
+}
+
+// NewLocalKeyProvider loads KEKs from path. If the file does not exist, a new
+// one is created holding a single randomly generated KEK.
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = createLocalKeyFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file localKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse key file %s: %w", path, err)
+	}
+
+	kr := NewKeyring()
+	for id, encoded := range file.Keys {
+		if err := addEncodedKey(kr, id, encoded); err != nil {
+			return nil, fmt.Errorf("key file %s: %w", path, err)
+		}
+	}
+	if err := kr.SetActive(file.ActiveKeyID); err != nil {
+		return nil, fmt.Errorf("key file %s: active key: %w", path, err)
+	}
+
+	return &LocalKeyProvider{keyring: kr}, nil
+}
+
+// NewEnvKeyProvider loads KEKs from KEK_KEYS, a comma separated list of
+// id:base64key pairs. KEK_ACTIVE_KEY_ID selects the wrapping key and
+// defaults to the last key listed.
+func NewEnvKeyProvider() (*LocalKeyProvider, error) {
+	keys := os.Getenv("KEK_KEYS")
+	if keys == "" {
+		return nil, fmt.Errorf("KEK_KEYS is not set")
+	}
+
+	kr := NewKeyring()
+	var last string
+	for _, entry := range strings.Split(keys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("KEK_KEYS: entry must be id:base64key")
+		}
+		if err := addEncodedKey(kr, id, encoded); err != nil {
+			return nil, fmt.Errorf("KEK_KEYS: %w", err)
+		}
+		last = id
+	}
+
+	active := os.Getenv("KEK_ACTIVE_KEY_ID")
+	if active == "" {
+		active = last
+	}
+	if err := kr.SetActive(active); err != nil {
+		return nil, fmt.Errorf("KEK_ACTIVE_KEY_ID: %w", err)
+	}
+
+	return &LocalKeyProvider{keyring: kr}, nil
+}
+
+func addEncodedKey(kr *Keyring, id, encoded string) error {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("key %q is not valid base64", id)
+	}
+	return kr.Add(id, key)
+}
+
+func createLocalKeyFile(path string) ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	id := "kek-" + KeyID(string(key))
+	data, err := json.MarshalIndent(localKeyFile{
+		ActiveKeyID: id,
+		Keys:        map[string]string{id: base64.StdEncoding.EncodeToString(key)},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("create key file %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// GenerateDataKey returns a random DEK wrapped with the active KEK.
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, *WrappedKey, error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := p.WrapDataKey(ctx, dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, wrapped, nil
+}
+
+// WrapDataKey encrypts key with the active KEK.
+func (p *LocalKeyProvider) WrapDataKey(ctx context.Context, key []byte) (*WrappedKey, error) {
+	ciphertext, err := p.keyring.Encrypt(string(key))
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{KeyID: p.keyring.ActiveKeyID(), Ciphertext: ciphertext}, nil
+}
+
+// UnwrapDataKey decrypts a DEK with the KEK that wrapped it.
+func (p *LocalKeyProvider) UnwrapDataKey(ctx context.Context, wrapped *WrappedKey) ([]byte, error) {
+	if wrapped == nil || !IsEnvelope(wrapped.Ciphertext) {
+		return nil, ErrMalformedEnvelope
+	}
+	dek, err := p.keyring.Decrypt(wrapped.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return []byte(dek), nil
+}
+
+// RewrapDataKey re-wraps a DEK with the active KEK.
+func (p *LocalKeyProvider) RewrapDataKey(ctx context.Context, wrapped *WrappedKey) (*WrappedKey, error) {
+	dek, err := p.UnwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+	return p.WrapDataKey(ctx, dek)
+}
@@ -0,0 +1,174 @@
+package utils_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"zeropii/utils"
+	"zeropii/utils/internal/piifixture"
+)
+
+func TestLocalKeyProviderCreatesKeyFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kek.json")
+
+	provider, err := utils.NewLocalKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dek) != 32 {
+		t.Fatalf("data key is %d bytes", len(dek))
+	}
+
+	// The same file loads the same KEK
+	reloaded, err := utils.NewLocalKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := reloaded.UnwrapDataKey(ctx, wrapped)
+	if err != nil || string(unwrapped) != string(dek) {
+		t.Fatalf("UnwrapDataKey after reload = %x, %v", unwrapped, err)
+	}
+}
+
+func TestEnvKeyProviderRewrap(t *testing.T) {
+	ctx := context.Background()
+	oldKEK := base64.StdEncoding.EncodeToString(randomKey(t))
+	newKEK := base64.StdEncoding.EncodeToString(randomKey(t))
+
+	t.Setenv("KEK_ACTIVE_KEY_ID", "")
+	t.Setenv("KEK_KEYS", "kek-1:"+oldKEK)
+	old, err := utils.NewEnvKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dek, wrapped, err := old.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KEK_KEYS", "kek-1:"+oldKEK+",kek-2:"+newKEK)
+	rotated, err := utils.NewEnvKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := rotated.RewrapDataKey(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped.KeyID != "kek-2" {
+		t.Errorf("rewrapped key ID = %q, want kek-2", rewrapped.KeyID)
+	}
+	for _, w := range []*utils.WrappedKey{wrapped, rewrapped} {
+		if unwrapped, err := rotated.UnwrapDataKey(ctx, w); err != nil || string(unwrapped) != string(dek) {
+			t.Errorf("UnwrapDataKey(%s) = %x, %v", w.KeyID, unwrapped, err)
+		}
+	}
+
+	// A provider without the new KEK cannot unwrap what it wrapped
+	if _, err := old.UnwrapDataKey(ctx, rewrapped); !errors.Is(err, utils.ErrUnknownKey) {
+		t.Errorf("UnwrapDataKey with a retired provider = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestEnvKeyProviderRejectsBadConfig(t *testing.T) {
+	t.Setenv("KEK_ACTIVE_KEY_ID", "")
+	for _, keys := range []string{
+		"",
+		"kek-1",
+		"kek-1:not base64!",
+		"kek-1:" + base64.StdEncoding.EncodeToString([]byte("too short")),
+	} {
+		t.Setenv("KEK_KEYS", keys)
+		if _, err := utils.NewEnvKeyProvider(); err == nil {
+			t.Errorf("NewEnvKeyProvider accepted KEK_KEYS=%q", keys)
+		}
+	}
+}
+
+func TestLocalKeyProviderRejectsMalformedWrappedKeys(t *testing.T) {
+	ctx := context.Background()
+	provider, err := utils.NewLocalKeyProvider(filepath.Join(t.TempDir(), "kek.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, w := range map[string]*utils.WrappedKey{
+		"nil":          nil,
+		"not envelope": {KeyID: wrapped.KeyID, Ciphertext: "deadbeef"},
+	} {
+		if _, err := provider.UnwrapDataKey(ctx, w); !errors.Is(err, utils.ErrMalformedEnvelope) {
+			t.Errorf("UnwrapDataKey(%s) = %v, want ErrMalformedEnvelope", name, err)
+		}
+	}
+	damaged := *wrapped
+	damaged.Ciphertext = tamper(t, wrapped.Ciphertext, func(b []byte) []byte {
+		b[len(b)-1] ^= 1
+		return b
+	})
+	if _, err := provider.UnwrapDataKey(ctx, &damaged); err == nil {
+		t.Error("UnwrapDataKey accepted a tampered data key")
+	}
+}
+
+func TestRecordPIIRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider, err := utils.NewLocalKeyProvider(filepath.Join(t.TempDir(), "kek.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := baseKeyring(t).WithTokenizer(fakeTokenizer{})
+
+	record := newRecord(t).(*piifixture.Record)
+	wrapped, err := utils.EncryptRecordPII(ctx, record, keyring, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Randomized fields are encrypted with the record's data key
+	if env, err := utils.ParseEnvelope(record.Email); err != nil || env.KeyID != "dek" {
+		t.Errorf("email envelope = %v, %v, want the data key", env, err)
+	}
+
+	if err := utils.DecryptRecordPII(ctx, record, wrapped, keyring, provider); err != nil {
+		t.Fatal(err)
+	}
+	want := newRecord(t).(*piifixture.Record)
+	if record.Email != want.Email || !reflect.DeepEqual(record.Aliases, want.Aliases) ||
+		record.Codes != want.Codes || record.Contact.Phone != want.Contact.Phone {
+		t.Errorf("record decrypts to %+v", record)
+	}
+
+	// Another record's data key does not decrypt it
+	other := newRecord(t).(*piifixture.Record)
+	otherKey, err := utils.EncryptRecordPII(ctx, other, keyring, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.EncryptRecordPII(ctx, record, keyring, provider); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.DecryptRecordPII(ctx, record, otherKey, keyring, provider); err == nil {
+		t.Error("record decrypted with another record's data key")
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultConfig configures a VaultTransitProvider.
+type VaultConfig struct {
+	Address   string // e.g. https://vault.internal:8200
+	Token     string
+	Namespace string // optional, Vault Enterprise namespaces
+	Mount     string // transit mount path, defaults to "transit"
+	KeyName   string // name of the transit key used as KEK
+	Client    *http.Client
+}
+
+// VaultTransitProvider wraps data keys with a HashiCorp Vault Transit key. The
+// KEK never leaves Vault; data keys are generated, wrapped, unwrapped and
+// re-wrapped through the transit encrypt, decrypt, rewrap and datakey endpoints.
+type VaultTransitProvider struct {
+	config VaultConfig
+	client *http.Client
+}
+
+// NewVaultTransitProvider creates a provider for the configured transit key.
+func NewVaultTransitProvider(config VaultConfig) (*VaultTransitProvider, error) {
+	if config.Address == "" || config.Token == "" || config.KeyName == "" {
+		return nil, fmt.Errorf("vault transit provider requires an address, token and key name")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	config.Address = strings.TrimRight(config.Address, "/")
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &VaultTransitProvider{config: config, client: client}, nil
+}
+
+// vaultTransitData is the "data" object shared by transit requests and responses.
+type vaultTransitData struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Bits       int    `json:"bits,omitempty"`
+}
+
+type vaultResponse struct {
+	Data   vaultTransitData `json:"data"`
+	Errors []string         `json:"errors"`
+}
+
+// GenerateDataKey asks Vault for a new 256-bit data key.
+func (p *VaultTransitProvider) GenerateDataKey(ctx context.Context) ([]byte, *WrappedKey, error) {
+	data, err := p.call(ctx, "datakey/plaintext", vaultTransitData{Bits: dataKeySize * 8})
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := p.wrappedKey("datakey", data.Ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	dek, err := decodeVaultDataKey("datakey", data.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, wrapped, nil
+}
+
+// WrapDataKey encrypts key with the transit key.
+func (p *VaultTransitProvider) WrapDataKey(ctx context.Context, key []byte) (*WrappedKey, error) {
+	data, err := p.call(ctx, "encrypt", vaultTransitData{Plaintext: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		return nil, err
+	}
+	return p.wrappedKey("encrypt", data.Ciphertext)
+}
+
+// UnwrapDataKey decrypts a wrapped key with the transit key.
+func (p *VaultTransitProvider) UnwrapDataKey(ctx context.Context, wrapped *WrappedKey) ([]byte, error) {
+	if wrapped == nil || !strings.HasPrefix(wrapped.Ciphertext, "vault:") {
+		return nil, ErrMalformedEnvelope
+	}
+	data, err := p.call(ctx, "decrypt", vaultTransitData{Ciphertext: wrapped.Ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return decodeVaultDataKey("decrypt", data.Plaintext)
+}
+
+// RewrapDataKey re-wraps a key with the latest version of the transit key.
+// The plaintext never leaves Vault.
+func (p *VaultTransitProvider) RewrapDataKey(ctx context.Context, wrapped *WrappedKey) (*WrappedKey, error) {
+	if wrapped == nil || !strings.HasPrefix(wrapped.Ciphertext, "vault:") {
+		return nil, ErrMalformedEnvelope
+	}
+	data, err := p.call(ctx, "rewrap", vaultTransitData{Ciphertext: wrapped.Ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return p.wrappedKey("rewrap", data.Ciphertext)
+}
+
+// wrappedKey records the transit key name and version (vault:v<N>:...) as the
+// key ID. A reply without a well-formed ciphertext is an error, so a broken
+// Vault never leaves a record with a data key nobody can unwrap.
+func (p *VaultTransitProvider) wrappedKey(operation, ciphertext string) (*WrappedKey, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("vault %s: response has no ciphertext: %w", operation, ErrMalformedEnvelope)
+	}
+	return &WrappedKey{KeyID: p.config.KeyName + ":" + parts[1], Ciphertext: ciphertext}, nil
+}
+
+// decodeVaultDataKey decodes the base64 plaintext of a data key returned by Vault.
+func decodeVaultDataKey(operation, plaintext string) ([]byte, error) {
+	dek, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: invalid plaintext: %w", operation, err)
+	}
+	if len(dek) != dataKeySize {
+		clear(dek)
+		return nil, fmt.Errorf("vault %s: data key is %d bytes: %w", operation, len(dek), ErrInvalidKeySize)
+	}
+	return dek, nil
+}
+
+// call POSTs body to /v1/<mount>/<operation>/<key name> and returns the response data.
+func (p *VaultTransitProvider) call(ctx context.Context, operation string, body vaultTransitData) (*vaultTransitData, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.config.Address, p.config.Mount, operation, p.config.KeyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	var result vaultResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s: status %d: %s", operation, resp.StatusCode, strings.Join(result.Errors, "; "))
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("vault %s: decode response: %w", operation, decodeErr)
+	}
+	return &result.Data, nil
+}
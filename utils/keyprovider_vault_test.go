@@ -0,0 +1,176 @@
+package utils_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"zeropii/utils"
+)
+
+// fakeTransit is a Vault Transit server for one key. Its "ciphertexts" are
+// vault:v<N>: followed by the base64 plaintext, which is enough to check
+// what the provider sends and how it reads the replies.
+type fakeTransit struct {
+	version int
+	// reply, if set, replaces the reply to every request
+	reply func(w http.ResponseWriter)
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+	if f.reply != nil {
+		f.reply(w)
+		return
+	}
+
+	var req struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+		Bits       int    `json:"bits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	seal := func(plaintext string) string {
+		return fmt.Sprintf("vault:v%d:%s", f.version, plaintext)
+	}
+	open := func(ciphertext string) (string, bool) {
+		parts := strings.SplitN(ciphertext, ":", 3)
+		return parts[len(parts)-1], len(parts) == 3 && parts[0] == "vault"
+	}
+
+	var data map[string]string
+	switch r.URL.Path {
+	case "/v1/transit/datakey/plaintext/kek":
+		if req.Bits != 256 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		plaintext := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+		data = map[string]string{"plaintext": plaintext, "ciphertext": seal(plaintext)}
+	case "/v1/transit/encrypt/kek":
+		data = map[string]string{"ciphertext": seal(req.Plaintext)}
+	case "/v1/transit/decrypt/kek":
+		plaintext, ok := open(req.Ciphertext)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":["invalid ciphertext"]}`)
+			return
+		}
+		data = map[string]string{"plaintext": plaintext}
+	case "/v1/transit/rewrap/kek":
+		plaintext, _ := open(req.Ciphertext)
+		data = map[string]string{"ciphertext": seal(plaintext)}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func newVaultProvider(t *testing.T, transit *fakeTransit, token string) *utils.VaultTransitProvider {
+	t.Helper()
+	server := httptest.NewServer(transit)
+	t.Cleanup(server.Close)
+	provider, err := utils.NewVaultTransitProvider(utils.VaultConfig{
+		Address: server.URL + "/",
+		Token:   token,
+		KeyName: "kek",
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestVaultTransitProvider(t *testing.T) {
+	ctx := context.Background()
+	transit := &fakeTransit{version: 1}
+	provider := newVaultProvider(t, transit, "test-token")
+
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dek) != 32 || wrapped.KeyID != "kek:v1" {
+		t.Fatalf("GenerateDataKey = %d bytes wrapped by %q", len(dek), wrapped.KeyID)
+	}
+	unwrapped, err := provider.UnwrapDataKey(ctx, wrapped)
+	if err != nil || string(unwrapped) != string(dek) {
+		t.Fatalf("UnwrapDataKey = %x, %v", unwrapped, err)
+	}
+
+	transit.version = 2
+	rewrapped, err := provider.RewrapDataKey(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped.KeyID != "kek:v2" {
+		t.Errorf("rewrapped key ID = %q, want kek:v2", rewrapped.KeyID)
+	}
+	if unwrapped, err := provider.UnwrapDataKey(ctx, rewrapped); err != nil || string(unwrapped) != string(dek) {
+		t.Errorf("UnwrapDataKey(rewrapped) = %x, %v", unwrapped, err)
+	}
+
+	if _, err := provider.UnwrapDataKey(ctx, &utils.WrappedKey{Ciphertext: "zp:AQ"}); !errors.Is(err, utils.ErrMalformedEnvelope) {
+		t.Errorf("UnwrapDataKey(non-Vault key) = %v, want ErrMalformedEnvelope", err)
+	}
+}
+
+func TestVaultTransitProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	wrapped := &utils.WrappedKey{KeyID: "kek:v1", Ciphertext: "vault:v1:" + base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	reply := func(body string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) { fmt.Fprint(w, body) }
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		reply   func(w http.ResponseWriter)
+		wantErr error
+		// operations whose replies are usable despite the fault
+		unwrapOK, rewrapOK bool
+	}{
+		{name: "forbidden", token: "wrong-token"},
+		{name: "malformed JSON", reply: reply(`{"data": {"ciphertext": `)},
+		{name: "missing ciphertext", reply: reply(`{"data": {"plaintext": "` + base64.StdEncoding.EncodeToString(make([]byte, 32)) + `"}}`), wantErr: utils.ErrMalformedEnvelope, unwrapOK: true},
+		{name: "short data key", reply: reply(`{"data": {"plaintext": "AAAA", "ciphertext": "vault:v1:AAAA"}}`), wantErr: utils.ErrInvalidKeySize, rewrapOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				token = "test-token"
+			}
+			provider := newVaultProvider(t, &fakeTransit{version: 1, reply: tt.reply}, token)
+
+			_, _, err := provider.GenerateDataKey(ctx)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("GenerateDataKey = %v, want %v", err, tt.wantErr)
+			}
+			if tt.name == "forbidden" && !strings.Contains(err.Error(), "status 403") {
+				t.Errorf("GenerateDataKey = %v, want status 403", err)
+			}
+			if _, err := provider.UnwrapDataKey(ctx, wrapped); (err == nil) != tt.unwrapOK {
+				t.Errorf("UnwrapDataKey = %v", err)
+			}
+			if _, err := provider.RewrapDataKey(ctx, wrapped); (err == nil) != tt.rewrapOK {
+				t.Errorf("RewrapDataKey = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoActiveKey = errors.New("keyring has no active key")
+	ErrUnknownKey  = errors.New("key not found in keyring")
+)
+
+// Keyring holds versioned encryption keys. New values are always encrypted
+// with the active key; retired keys stay in the ring so values they produced
+// can still be decrypted, selected by the key ID embedded in each envelope.
+// A Keyring is safe for concurrent use, so keys can be rotated while serving.
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     map[string][]byte
+	active   string
+	legacy   string
+	indexKey []byte
+	// shared is the keyring a per-record keyring was derived from; its keys
+	// are used for deterministic encryption, which must not vary by record
+	shared *Keyring
+	// tokenizer issues tokens for fields tagged pii:"true,token"
+	tokenizer Tokenizer
+	// requireBinding rejects unbound values where bound ones are expected
+	requireBinding bool
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Add registers a key under id. The first key added also becomes the key used
+// for legacy AES-CFB values, which carry no key ID.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("key %q is %d bytes: %w", id, len(key), ErrInvalidKeySize)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, exists := k.keys[id]; exists {
+		return fmt.Errorf("key %q already exists in keyring", id)
+	}
+	k.keys[id] = append([]byte(nil), key...)
+	if k.legacy == "" {
+		k.legacy = id
+	}
+	return nil
+}
+
+// withDataKey returns a keyring that encrypts with a record's data key while
+// sharing this keyring's blind index key.
+func (k *Keyring) withDataKey(id string, dek []byte) (*Keyring, error) {
+	child := NewKeyring()
+	if err := child.Rotate(id, dek); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	child.indexKey = k.indexKey
+	child.tokenizer = k.tokenizer
+	child.requireBinding = k.requireBinding
+	k.mu.RUnlock()
+	child.shared = k.sharedKeyring()
+	return child, nil
+}
+
+// WithTokenizer returns a copy of the keyring that swaps fields tagged
+// pii:"true,token" for tokens issued by t.
+func (k *Keyring) WithTokenizer(t Tokenizer) *Keyring {
+	c := k.clone()
+	c.tokenizer = t
+	return c
+}
+
+// AllowingUnbound returns a copy of the keyring that decrypts unbound values
+// even when binding is required, for migrating them to bound ones.
+func (k *Keyring) AllowingUnbound() *Keyring {
+	c := k.clone()
+	c.requireBinding = false
+	return c
+}
+
+func (k *Keyring) clone() *Keyring {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make(map[string][]byte, len(k.keys))
+	for id, key := range k.keys {
+		keys[id] = key
+	}
+	return &Keyring{
+		keys:           keys,
+		active:         k.active,
+		legacy:         k.legacy,
+		indexKey:       k.indexKey,
+		shared:         k.shared,
+		tokenizer:      k.tokenizer,
+		requireBinding: k.requireBinding,
+	}
+}
+
+// sharedKeyring returns the keyring holding keys shared across records.
+func (k *Keyring) sharedKeyring() *Keyring {
+	if k.shared != nil {
+		return k.shared
+	}
+	return k
+}
+
+// SetActive makes id the key used for new encryptions.
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	k.active = id
+	return nil
+}
+
+// SetLegacy selects the key used to decrypt legacy AES-CFB values.
+func (k *Keyring) SetLegacy(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	k.legacy = id
+	return nil
+}
+
+// RequireBinding makes DecryptBound reject values that are not bound to
+// associated data: AES-CFB values and version 1 envelopes. Turn it on once
+// the re-encryption job has upgraded every stored value.
+func (k *Keyring) RequireBinding(required bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.requireBinding = required
+}
+
+func (k *Keyring) bindingRequired() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.requireBinding
+}
+
+// Rotate adds a new key and makes it active in one step. The previously
+// active key is kept for decryption.
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+	return k.SetActive(id)
+}
+
+// ActiveKeyID returns the ID of the key used for new encryptions.
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// KeyIDs returns the IDs of every key in the ring.
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Encrypt encrypts plaintext with the active key.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	k.mu.RLock()
+	id, key := k.active, k.keys[k.active]
+	k.mu.RUnlock()
+
+	if id == "" {
+		return "", ErrNoActiveKey
+	}
+	return encryptGCM(plaintext, key, id, nil)
+}
+
+// EncryptBound encrypts plaintext with the active key, binding it to
+// associatedData. The result only decrypts through DecryptBound with the
+// same associated data.
+func (k *Keyring) EncryptBound(plaintext string, associatedData []byte) (string, error) {
+	if associatedData == nil {
+		associatedData = []byte{}
+	}
+
+	k.mu.RLock()
+	id, key := k.active, k.keys[k.active]
+	k.mu.RUnlock()
+
+	if id == "" {
+		return "", ErrNoActiveKey
+	}
+	return encryptGCM(plaintext, key, id, associatedData)
+}
+
+// EncryptDeterministic encrypts plaintext with AES-SIV under the active
+// shared key.
+//
+// Unlike Encrypt, which is randomized so that equal plaintexts produce
+// unrelated ciphertexts, the same plaintext and associated data always
+// produce the same ciphertext here. That makes the ciphertext usable for
+// equality joins and lookups across records and datasets, at the cost of
+// revealing which records share a value. Use it only for fields that need
+// equality matching. On per-record keyrings the shared keyring is used
+// rather than the record's data key, so values stay comparable across
+// records. Rotating the active key changes every deterministic ciphertext.
+func (k *Keyring) EncryptDeterministic(plaintext string, associatedData []byte) (string, error) {
+	shared := k.sharedKeyring()
+
+	shared.mu.RLock()
+	id, key := shared.active, shared.keys[shared.active]
+	shared.mu.RUnlock()
+
+	if id == "" {
+		return "", ErrNoActiveKey
+	}
+	subkey, err := sivKey(key)
+	if err != nil {
+		return "", err
+	}
+	defer clear(subkey)
+
+	sealed, err := sivSeal(subkey, []byte(plaintext), associatedData)
+	if err != nil {
+		return "", err
+	}
+	env := &Envelope{Version: envelopeVersionSIV, KeyID: id, Ciphertext: sealed}
+	return env.encode(), nil
+}
+
+// DecryptDeterministic decrypts a value produced by EncryptDeterministic with
+// the same associated data.
+func (k *Keyring) DecryptDeterministic(ciphertext string, associatedData []byte) (string, error) {
+	env, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if env.Version != envelopeVersionSIV {
+		return "", ErrUnsupportedCipher
+	}
+	key, err := k.sharedKeyring().key(env.KeyID)
+	if err != nil {
+		return "", err
+	}
+	subkey, err := sivKey(key)
+	if err != nil {
+		return "", err
+	}
+	defer clear(subkey)
+
+	plaintext, err := sivOpen(subkey, env.Ciphertext, associatedData)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Decrypt decrypts ciphertext with whichever key produced it.
+func (k *Keyring) Decrypt(ciphertext string) (string, error) {
+	return k.DecryptBound(ciphertext, nil)
+}
+
+// DecryptBound decrypts ciphertext with whichever key produced it. Values
+// from EncryptBound must be given the associated data they were bound to.
+// A value that authenticates under the associated data recorded in it, but
+// was given other associated data, fails with ErrBindingMismatch; anything
+// that does not authenticate fails with ErrAuthFailed.
+//
+// Unbound values written by Encrypt or before binding was introduced
+// decrypt regardless of associatedData, unless the keyring requires binding
+// and associatedData is not nil, in which case they fail with
+// ErrUnboundCiphertext.
+func (k *Keyring) DecryptBound(ciphertext string, associatedData []byte) (string, error) {
+	strict := associatedData != nil && k.bindingRequired()
+	if !IsEnvelope(ciphertext) {
+		if strict {
+			return "", ErrUnboundCiphertext
+		}
+		key, err := k.key(k.legacyKeyID())
+		if err != nil {
+			return "", err
+		}
+		return decryptLegacyCFB(ciphertext, key)
+	}
+
+	env, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	switch env.Version {
+	case envelopeVersionGCM:
+		if strict {
+			return "", ErrUnboundCiphertext
+		}
+	case envelopeVersionGCMBound:
+		if associatedData == nil {
+			associatedData = []byte{}
+		}
+	default:
+		return "", ErrUnsupportedCipher
+	}
+	key, err := k.key(env.KeyID)
+	if err != nil {
+		return "", err
+	}
+
+	if env.Version == envelopeVersionGCM {
+		return openGCM(env, key, nil)
+	}
+	// Only report a mismatch for a value that is intact under the binding it
+	// was sealed with
+	if !bytes.Equal(env.AssociatedData, associatedData) {
+		if _, err := openGCM(env, key, env.AssociatedData); err != nil {
+			return "", err
+		}
+		return "", ErrBindingMismatch
+	}
+	return openGCM(env, key, associatedData)
+}
+
+// NeedsRotation reports whether ciphertext was produced by a key other than
+// the active one, including legacy values that carry no key ID.
+func (k *Keyring) NeedsRotation(ciphertext string) bool {
+	if !IsEnvelope(ciphertext) {
+		return true
+	}
+	env, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		return true
+	}
+	return env.KeyID != k.ActiveKeyID()
+}
+
+func (k *Keyring) legacyKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.legacy
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// LoadKeyringFromEnv builds a keyring from the environment:
+//
+//	ENCRYPTION_KEY              single key, registered under its KeyID fingerprint
+//	ENCRYPTION_KEYS             comma separated id:key pairs for versioned keys
+//	ENCRYPTION_ACTIVE_KEY_ID    key used for new encryptions (defaults to the last key listed)
+//	ENCRYPTION_LEGACY_KEY_ID    key used for legacy AES-CFB values (defaults to ENCRYPTION_KEY)
+//	ENCRYPTION_REQUIRE_BINDING  "true" to reject unbound field values, see RequireBinding
+//
+// Keys are 32 random bytes, hex or padded base64 encoded, as printed by
+// `openssl rand -hex 32`. A key that was used raw can be kept by hex encoding
+// it; its KeyID and the values it encrypted stay the same.
+func LoadKeyringFromEnv() (*Keyring, error) {
+	kr := NewKeyring()
+	var last string
+
+	if encoded := os.Getenv("ENCRYPTION_KEY"); encoded != "" {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+		}
+		last = KeyID(string(key))
+		if err := kr.Add(last, key); err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+		}
+	}
+
+	if keys := os.Getenv("ENCRYPTION_KEYS"); keys != "" {
+		for _, entry := range strings.Split(keys, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok {
+				return nil, fmt.Errorf("ENCRYPTION_KEYS: entry must be id:key")
+			}
+			key, err := decodeKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("ENCRYPTION_KEYS: key %q: %w", id, err)
+			}
+			if err := kr.Add(id, key); err != nil {
+				return nil, fmt.Errorf("ENCRYPTION_KEYS: %w", err)
+			}
+			last = id
+		}
+	}
+
+	active := os.Getenv("ENCRYPTION_ACTIVE_KEY_ID")
+	if active == "" {
+		active = last
+	}
+	if active == "" {
+		return nil, fmt.Errorf("set ENCRYPTION_KEY or ENCRYPTION_KEYS: %w", ErrNoActiveKey)
+	}
+	if err := kr.SetActive(active); err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_ACTIVE_KEY_ID: %w", err)
+	}
+
+	if legacy := os.Getenv("ENCRYPTION_LEGACY_KEY_ID"); legacy != "" {
+		if err := kr.SetLegacy(legacy); err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_LEGACY_KEY_ID: %w", err)
+		}
+	}
+
+	if required := os.Getenv("ENCRYPTION_REQUIRE_BINDING"); required != "" {
+		on, err := strconv.ParseBool(required)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_REQUIRE_BINDING: %w", err)
+		}
+		kr.RequireBinding(on)
+	}
+
+	return kr, nil
+}
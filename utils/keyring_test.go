@@ -0,0 +1,275 @@
+package utils_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"zeropii/utils"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if _, err := keyring.Encrypt("secret"); !errors.Is(err, utils.ErrNoActiveKey) {
+		t.Fatalf("Encrypt without keys: %v, want ErrNoActiveKey", err)
+	}
+	if err := keyring.Rotate("v1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	old, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Rotate("v2", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if keyring.ActiveKeyID() != "v2" {
+		t.Fatalf("active key = %q, want v2", keyring.ActiveKeyID())
+	}
+	current, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, ciphertext := range map[string]string{"v1": old, "v2": current} {
+		env, err := utils.ParseEnvelope(ciphertext)
+		if err != nil || env.KeyID != id {
+			t.Errorf("envelope key ID = %v, %v, want %s", env, err, id)
+		}
+		// The retired key still decrypts the values it produced
+		if plaintext, err := keyring.Decrypt(ciphertext); err != nil || plaintext != "secret" {
+			t.Errorf("Decrypt(%s value) = %q, %v", id, plaintext, err)
+		}
+	}
+	if !keyring.NeedsRotation(old) || keyring.NeedsRotation(current) {
+		t.Error("NeedsRotation does not follow the active key")
+	}
+}
+
+func TestKeyringRejectsInvalidKeys(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if err := keyring.Add("v1", []byte("short")); !errors.Is(err, utils.ErrInvalidKeySize) {
+		t.Errorf("Add(short key) = %v, want ErrInvalidKeySize", err)
+	}
+	if err := keyring.Add("", randomKey(t)); err == nil {
+		t.Error("Add accepted an empty key ID")
+	}
+	if err := keyring.Add("v1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add("v1", randomKey(t)); err == nil {
+		t.Error("Add replaced an existing key")
+	}
+	if err := keyring.SetActive("v9"); !errors.Is(err, utils.ErrUnknownKey) {
+		t.Errorf("SetActive(unknown) = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyringDecryptUnknownKeyID(t *testing.T) {
+	other := utils.NewKeyring()
+	if err := other.Rotate("v7", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := other.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := utils.NewKeyring()
+	if err := keyring.Rotate("v1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Decrypt(ciphertext); !errors.Is(err, utils.ErrUnknownKey) {
+		t.Fatalf("Decrypt under an unknown key ID: %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyringLegacyKey(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if err := keyring.Add("old", []byte(testKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Rotate("new", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Legacy values carry no key ID; the first key added decrypts them
+	legacy := legacyCFB(t, "ethan", testKey)
+	if plaintext, err := keyring.Decrypt(legacy); err != nil || plaintext != "ethan" {
+		t.Fatalf("Decrypt(legacy) = %q, %v", plaintext, err)
+	}
+	if !keyring.NeedsRotation(legacy) {
+		t.Error("legacy value does not need rotation")
+	}
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	// A key once used raw, hex encoded, keeps decrypting its values
+	t.Setenv("ENCRYPTION_KEY", hex.EncodeToString([]byte(testKey)))
+	t.Setenv("ENCRYPTION_KEYS", "v2:"+base64.StdEncoding.EncodeToString(randomKey(t)))
+	t.Setenv("ENCRYPTION_ACTIVE_KEY_ID", "")
+	t.Setenv("ENCRYPTION_LEGACY_KEY_ID", "")
+
+	keyring, err := utils.LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.ActiveKeyID() != "v2" {
+		t.Errorf("active key = %q, want the last key listed", keyring.ActiveKeyID())
+	}
+	ciphertext, err := utils.Encrypt("secret", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := keyring.Decrypt(ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("ENCRYPTION_KEY value decrypts to %q, %v", plaintext, err)
+	}
+
+	if err := keyring.Validate(); err != nil {
+		t.Errorf("Validate with a weak retired key: %v", err)
+	}
+
+	t.Setenv("ENCRYPTION_ACTIVE_KEY_ID", "v9")
+	if _, err := utils.LoadKeyringFromEnv(); err == nil {
+		t.Error("LoadKeyringFromEnv accepted an unknown active key")
+	}
+}
+
+func TestKeyringDecryptBound(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if err := keyring.Rotate("v1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("rec-1\x00Email")
+	ciphertext, err := keyring.EncryptBound("ethan@example.com", ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, err := utils.ParseEnvelope(ciphertext); err != nil || env.Version != 3 || string(env.AssociatedData) != string(ad) {
+		t.Fatalf("envelope = %+v, %v", env, err)
+	}
+	if plaintext, err := keyring.DecryptBound(ciphertext, ad); err != nil || plaintext != "ethan@example.com" {
+		t.Fatalf("DecryptBound = %q, %v", plaintext, err)
+	}
+
+	flipped := tamper(t, ciphertext, func(raw []byte) []byte {
+		raw[len(raw)-1] ^= 1
+		return raw
+	})
+	rebound := tamper(t, ciphertext, func(raw []byte) []byte {
+		// The first byte of the recorded associated data, after the
+		// version, key ID and its length
+		raw[2+len("v1")+2] ^= 1
+		return raw
+	})
+	tests := []struct {
+		name       string
+		ciphertext string
+		ad         []byte
+		want       error
+	}{
+		{"moved to another field", ciphertext, []byte("rec-1\x00Phone"), utils.ErrBindingMismatch},
+		{"moved to another record", ciphertext, []byte("rec-2\x00Email"), utils.ErrBindingMismatch},
+		{"unbound read", ciphertext, nil, utils.ErrBindingMismatch},
+		{"flipped ciphertext", flipped, ad, utils.ErrAuthFailed},
+		{"flipped and moved", flipped, []byte("rec-1\x00Phone"), utils.ErrAuthFailed},
+		{"altered binding", rebound, ad, utils.ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keyring.DecryptBound(tt.ciphertext, tt.ad); !errors.Is(err, tt.want) {
+				t.Errorf("DecryptBound = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringRequireBinding(t *testing.T) {
+	keyring := utils.NewKeyring()
+	if err := keyring.Add("old", []byte(testKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Rotate("v1", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	unbound, err := keyring.Encrypt("ethan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("rec-1\x00Name")
+	values := map[string]string{"version 1": unbound, "legacy": legacyCFB(t, "ethan", testKey)}
+
+	for name, value := range values {
+		if plaintext, err := keyring.DecryptBound(value, ad); err != nil || plaintext != "ethan" {
+			t.Errorf("DecryptBound(%s) before binding is required = %q, %v", name, plaintext, err)
+		}
+	}
+
+	keyring.RequireBinding(true)
+	lenient := keyring.AllowingUnbound()
+	for name, value := range values {
+		if _, err := keyring.DecryptBound(value, ad); !errors.Is(err, utils.ErrUnboundCiphertext) {
+			t.Errorf("DecryptBound(%s) = %v, want ErrUnboundCiphertext", name, err)
+		}
+		// Values that were never bound to a field are still readable
+		if plaintext, err := keyring.Decrypt(value); err != nil || plaintext != "ethan" {
+			t.Errorf("Decrypt(%s) = %q, %v", name, plaintext, err)
+		}
+		if plaintext, err := lenient.DecryptBound(value, ad); err != nil || plaintext != "ethan" {
+			t.Errorf("AllowingUnbound().DecryptBound(%s) = %q, %v", name, plaintext, err)
+		}
+	}
+}
+
+func TestKeyringRejectsWeakKeys(t *testing.T) {
+	pattern := make([]byte, 32)
+	for i := range pattern {
+		pattern[i] = byte(0xf0 + i%4)
+	}
+	tests := []struct {
+		name     string
+		key      string
+		loadErr  error
+		validErr error
+	}{
+		{"random hex", hex.EncodeToString(randomKey(t)), nil, nil},
+		{"random base64", base64.StdEncoding.EncodeToString(randomKey(t)), nil, nil},
+		{"unpadded base64", base64.RawURLEncoding.EncodeToString(randomKey(t)), utils.ErrKeyEncoding, nil},
+		{"old .env sample", "my32characterlongencryptionkey!!", utils.ErrKeyEncoding, nil},
+		{".env placeholder", "replace-with-openssl-rand-hex-32", utils.ErrKeyEncoding, nil},
+		{"hex too short", hex.EncodeToString(randomKey(t)[:16]), utils.ErrKeyEncoding, nil},
+		{"hex encoded passphrase", hex.EncodeToString([]byte("my32characterlongencryptionkey!!")), nil, utils.ErrWeakKey},
+		{"repeated pattern", hex.EncodeToString(pattern), nil, utils.ErrWeakKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENCRYPTION_KEY", tt.key)
+			t.Setenv("ENCRYPTION_KEYS", "")
+			t.Setenv("ENCRYPTION_ACTIVE_KEY_ID", "")
+			t.Setenv("ENCRYPTION_LEGACY_KEY_ID", "")
+			keyring, err := utils.LoadKeyringFromEnv()
+			if !errors.Is(err, tt.loadErr) {
+				t.Fatalf("LoadKeyringFromEnv = %v, want %v", err, tt.loadErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := keyring.Validate(); !errors.Is(err, tt.validErr) {
+				t.Errorf("Validate = %v, want %v", err, tt.validErr)
+			}
+		})
+	}
+
+	keyring := testKeyring(t)
+	t.Setenv("BLIND_INDEX_KEY", "change-me-blind-index-key-at-least-32-bytes")
+	if err := keyring.LoadIndexKeyFromEnv(); !errors.Is(err, utils.ErrKeyEncoding) {
+		t.Errorf("LoadIndexKeyFromEnv(old .env sample) = %v, want ErrKeyEncoding", err)
+	}
+	t.Setenv("BLIND_INDEX_KEY", base64.StdEncoding.EncodeToString([]byte("change-me-blind-index-key-32byte")))
+	if err := keyring.LoadIndexKeyFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Validate(); !errors.Is(err, utils.ErrWeakKey) {
+		t.Errorf("Validate with a passphrase blind index key = %v, want ErrWeakKey", err)
+	}
+}
@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaskStrategy masks a value for viewers the access policy shows it masked.
+// Strategies must be safe for concurrent use.
+type MaskStrategy interface {
+	Mask(value string) string
+}
+
+// MaskFunc adapts a function to a MaskStrategy.
+type MaskFunc func(value string) string
+
+// Mask calls f(value).
+func (f MaskFunc) Mask(value string) string {
+	return f(value)
+}
+
+// MaskFactory builds a strategy from the arguments of a MaskSpec, e.g. 2 and
+// 4 for "partial:2:4".
+type MaskFactory func(args []string) (MaskStrategy, error)
+
+// MaskSpec names a registered mask strategy and its arguments, separated by
+// colons: "last4", "partial:2:4", "date:month". Tags select one with the mask
+// option and access policies per role and category.
+type MaskSpec string
+
+const (
+	MaskFull        MaskSpec = "full"         // "REDACTED"
+	MaskEmail       MaskSpec = "email"        // first character of the local part and the domain
+	MaskEmailDomain MaskSpec = "email_domain" // first character of the local part and of each domain label but the last
+	MaskLast3       MaskSpec = "last3"        // last 3 characters of values of at least 10
+	MaskLast4       MaskSpec = "last4"        // last 4 characters of values of at least 4
+	MaskYearOnly    MaskSpec = "year_only"    // year of a dd-mm-yyyy or dd/mm/yyyy date
+	MaskPartial     MaskSpec = "partial"      // partial:F:L keeps the first F and last L characters
+	MaskFixed       MaskSpec = "fixed"        // fixed:N gives N asterisks whatever the length, 8 by default
+	MaskHash        MaskSpec = "hash"         // first 16 hex digits of the HMAC-SHA256 of the value under the mask hash key
+	MaskNull        MaskSpec = "null"         // the empty string
+	MaskDate        MaskSpec = "date"         // date:year, date:month or date:decade of a date
+)
+
+var maskSpecSyntax = regexp.MustCompile(`^[a-z][a-z0-9_]*(:[A-Za-z0-9_.\-]*)*$`)
+
+var (
+	maskMu        sync.RWMutex
+	maskFactories = map[string]MaskFactory{}
+	// resolvedMasks caches the strategy of each spec resolved successfully.
+	// Strategies cannot be replaced, so entries never go stale.
+	resolvedMasks sync.Map
+	// maskHashKey keys the hash mask; without it values are redacted
+	maskHashKey []byte
+)
+
+func init() {
+	for name, strategy := range map[MaskSpec]MaskFunc{
+		MaskFull:        func(string) string { return "REDACTED" },
+		MaskEmail:       maskEmail,
+		MaskEmailDomain: maskEmailDomain,
+		MaskLast3:       maskLast3,
+		MaskLast4:       maskLast4,
+		MaskYearOnly:    maskYearOnly,
+		MaskHash:        maskHash,
+		MaskNull:        func(string) string { return "" },
+	} {
+		mustRegisterMask(string(name), noArgs(name, strategy))
+	}
+	mustRegisterMask(string(MaskPartial), newPartialMask)
+	mustRegisterMask(string(MaskFixed), newFixedMask)
+	mustRegisterMask(string(MaskDate), newDateMask)
+}
+
+// RegisterMaskStrategy registers strategy under name for specs without
+// arguments. Applications register their strategies before loading the
+// access policy and validating tags, e.g. from an init function.
+func RegisterMaskStrategy(name string, strategy MaskStrategy) error {
+	return RegisterMaskFactory(name, noArgs(MaskSpec(name), strategy))
+}
+
+// RegisterMaskFactory registers a strategy taking arguments under name.
+// Registered names cannot be replaced.
+func RegisterMaskFactory(name string, factory MaskFactory) error {
+	if strings.Contains(name, ":") || !maskSpecSyntax.MatchString(name) {
+		return fmt.Errorf("invalid mask strategy name %q", name)
+	}
+	maskMu.Lock()
+	defer maskMu.Unlock()
+	if _, ok := maskFactories[name]; ok {
+		return fmt.Errorf("mask strategy %q is already registered", name)
+	}
+	maskFactories[name] = factory
+	return nil
+}
+
+func mustRegisterMask(name string, factory MaskFactory) {
+	if err := RegisterMaskFactory(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+func noArgs(name MaskSpec, strategy MaskStrategy) MaskFactory {
+	return func(args []string) (MaskStrategy, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("mask %s takes no arguments", name)
+		}
+		return strategy, nil
+	}
+}
+
+// ResolveMask returns the strategy spec selects.
+func ResolveMask(spec MaskSpec) (MaskStrategy, error) {
+	if strategy, ok := resolvedMasks.Load(spec); ok {
+		return strategy.(MaskStrategy), nil
+	}
+	if !maskSpecSyntax.MatchString(string(spec)) {
+		return nil, fmt.Errorf("invalid mask %q", spec)
+	}
+
+	name, rest, hasArgs := strings.Cut(string(spec), ":")
+	var args []string
+	if hasArgs {
+		args = strings.Split(rest, ":")
+	}
+	maskMu.RLock()
+	factory, ok := maskFactories[name]
+	maskMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown mask %q", spec)
+	}
+	strategy, err := factory(args)
+	if err != nil {
+		return nil, fmt.Errorf("mask %q: %w", spec, err)
+	}
+	resolvedMasks.Store(spec, strategy)
+	return strategy, nil
+}
+
+// MaskValue returns value masked with the strategy spec selects, or fully
+// redacted if spec does not resolve.
+func MaskValue(value string, spec MaskSpec) string {
+	strategy, err := ResolveMask(spec)
+	if err != nil {
+		return "REDACTED"
+	}
+	return strategy.Mask(value)
+}
+
+// maskEmail masks part of the email address.
+func maskEmail(email string) string {
+	if len(email) == 0 {
+		return "REDACTED"
+	}
+	// Split the email into the local part (before @) and the domain part (after @).
+	parts := strings.Split(email, "@")
+	if len(parts) != 2 {
+		return "REDACTED"
+	}
+
+	localPart := parts[0]
+	domainPart := parts[1]
+
+	// Mask all but the first character of the local part.
+	if len(localPart) > 1 {
+		localPart = string(localPart[0]) + strings.Repeat("*", len(localPart)-1)
+	} else {
+		localPart = "*"
+	}
+
+	return localPart + "@" + domainPart
+}
+
+// maskEmailDomain masks an email address like maskEmail, and every label of
+// the domain but the last one too
+func maskEmailDomain(email string) string {
+	masked := maskEmail(email)
+	local, domain, ok := strings.Cut(masked, "@")
+	if !ok {
+		return masked
+	}
+	labels := strings.Split(domain, ".")
+	for i := 0; i < len(labels)-1; i++ {
+		labels[i] = keepEnds(labels[i], 1, 0)
+	}
+	return local + "@" + strings.Join(labels, ".")
+}
+
+func maskLast3(value string) string {
+	if len(value) >= 10 {
+		return "*******" + value[len(value)-3:]
+	}
+	return "REDACTED"
+}
+
+func maskLast4(value string) string {
+	if len(value) >= 4 {
+		return "***-**-" + value[len(value)-4:]
+	}
+	return "REDACTED"
+}
+
+// maskYearOnly shows only the year of dd-mm-yyyy or dd/mm/yyyy
+func maskYearOnly(value string) string {
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '-' || r == '/' })
+	if len(parts) >= 2 {
+		return "**-**-" + parts[len(parts)-1]
+	}
+	return "****-**-**"
+}
+
+// SetMaskHashKey sets the HMAC key of the hash mask, at least 32 bytes. The
+// key keeps short values such as phone numbers from being recovered by
+// hashing every candidate; see Keyring.MaskHashKey.
+func SetMaskHashKey(key []byte) error {
+	if len(key) < 32 {
+		return fmt.Errorf("mask hash key must be at least 32 bytes")
+	}
+	maskMu.Lock()
+	defer maskMu.Unlock()
+	maskHashKey = append([]byte(nil), key...)
+	return nil
+}
+
+// maskHash gives equal values equal masks, so they can be correlated without
+// being revealed. Without a key it redacts the value.
+func maskHash(value string) string {
+	maskMu.RLock()
+	key := maskHashKey
+	maskMu.RUnlock()
+
+	if key == nil {
+		return "REDACTED"
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func newPartialMask(args []string) (MaskStrategy, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("partial takes the number of leading and trailing characters to keep")
+	}
+	first, err := strconv.Atoi(args[0])
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("invalid leading count %q", args[0])
+	}
+	last, err := strconv.Atoi(args[1])
+	if err != nil || last < 0 {
+		return nil, fmt.Errorf("invalid trailing count %q", args[1])
+	}
+	return MaskFunc(func(value string) string {
+		return keepEnds(value, first, last)
+	}), nil
+}
+
+// keepEnds replaces all but the first and last characters of value with
+// asterisks. A value no longer than the characters kept is masked whole.
+func keepEnds(value string, first, last int) string {
+	runes := []rune(value)
+	if len(runes) <= first+last {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:first]) + strings.Repeat("*", len(runes)-first-last) + string(runes[len(runes)-last:])
+}
+
+func newFixedMask(args []string) (MaskStrategy, error) {
+	width := 8
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		width, err = strconv.Atoi(args[0])
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("invalid width %q", args[0])
+		}
+	default:
+		return nil, fmt.Errorf("fixed takes at most a width")
+	}
+	mask := strings.Repeat("*", width)
+	return MaskFunc(func(string) string { return mask }), nil
+}
+
+// dateLayouts are the date formats date masks understand
+var dateLayouts = []string{"02-01-2006", "02/01/2006", "2006-01-02", "2006/01/02", time.RFC3339}
+
+func newDateMask(args []string) (MaskStrategy, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("date takes one of year, month or decade")
+	}
+	var generalize func(t time.Time) string
+	switch args[0] {
+	case "year":
+		generalize = func(t time.Time) string { return strconv.Itoa(t.Year()) }
+	case "month":
+		generalize = func(t time.Time) string { return t.Format("01-2006") }
+	case "decade":
+		generalize = func(t time.Time) string { return strconv.Itoa(t.Year()/10*10) + "s" }
+	default:
+		return nil, fmt.Errorf("date takes one of year, month or decade, not %q", args[0])
+	}
+	return MaskFunc(func(value string) string {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return generalize(t)
+			}
+		}
+		return "REDACTED"
+	}), nil
+}
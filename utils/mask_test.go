@@ -0,0 +1,126 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"zeropii/models"
+	"zeropii/utils"
+)
+
+func TestBuiltInMasks(t *testing.T) {
+	tests := []struct {
+		spec  utils.MaskSpec
+		value string
+		want  string
+	}{
+		{"full", "Ethan Hunt", "REDACTED"},
+		{"email", "ethan.hunt@example.com", "e*********@example.com"},
+		{"email_domain", "ethan@mail.example.com", "e****@m***.e******.com"},
+		{"last3", "+1234567890", "*******890"},
+		{"last3", "12345", "REDACTED"},
+		{"last4", "X1234567", "***-**-4567"},
+		{"year_only", "23/07/2015", "**-**-2015"},
+		{"partial:2:4", "AAAAA1111B", "AA****111B"},
+		{"partial:0:2", "ab", "**"},
+		{"partial:1:1", "Ω日本語", "Ω**語"},
+		{"fixed", "anything", "********"},
+		{"fixed:3", "a", "***"},
+		{"null", "Ethan Hunt", ""},
+		{"date:year", "01-02-2000", "2000"},
+		{"date:month", "2000-02-01", "02-2000"},
+		{"date:decade", "23/07/1987", "1980s"},
+		{"date:year", "sometime", "REDACTED"},
+	}
+	for _, tt := range tests {
+		strategy, err := utils.ResolveMask(tt.spec)
+		if err != nil {
+			t.Errorf("ResolveMask(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := strategy.Mask(tt.value); got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.spec, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHashMask(t *testing.T) {
+	if err := utils.SetMaskHashKey([]byte("short")); err == nil {
+		t.Error("SetMaskHashKey accepted a short key")
+	}
+	if err := utils.SetMaskHashKey([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	strategy, err := utils.ResolveMask(utils.MaskHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HMAC-SHA256, not the unkeyed d9ad7a1baac49a1f
+	if got := strategy.Mask("ethan.hunt@example.com"); got != "f5077c9a168238e9" {
+		t.Errorf("hash = %q, want f5077c9a168238e9", got)
+	}
+	if strategy.Mask("9876543210") == strategy.Mask("9876543211") {
+		t.Error("hash gave different values the same mask")
+	}
+}
+
+func TestResolveMaskRejectsInvalid(t *testing.T) {
+	for _, spec := range []utils.MaskSpec{"", "nope", "full:1", "partial:2", "partial:a:1", "fixed:0", "date:week", "Last4", "last4,"} {
+		if _, err := utils.ResolveMask(spec); err == nil {
+			t.Errorf("ResolveMask(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRegisterMaskStrategy(t *testing.T) {
+	upper := utils.MaskFunc(strings.ToUpper)
+	if err := utils.RegisterMaskStrategy("test_upper", upper); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.RegisterMaskStrategy("test_upper", upper); err == nil {
+		t.Error("registering a name twice succeeded")
+	}
+	if err := utils.RegisterMaskStrategy("full", upper); err == nil {
+		t.Error("replacing a built-in strategy succeeded")
+	}
+	if err := utils.RegisterMaskStrategy("bad:name", upper); err == nil {
+		t.Error("registering an invalid name succeeded")
+	}
+
+	_, err := utils.ParsePIITag("category=name,mask=test_upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := utils.ParseAccessPolicy([]byte(`
+roles:
+  support:
+    masks:
+      email: test_upper
+purposes:
+  support:
+    categories: [email, phone]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer utils.SetAccessPolicy(utils.DefaultAccessPolicy())
+	utils.SetAccessPolicy(policy)
+
+	customer := models.Customer{Email: "jane@example.com", Phone: "9876543210"}
+	utils.SanitizeCustomerData(&customer, utils.Viewer{Role: "support", Purpose: "support", Consented: true})
+	if customer.Email != "JANE@EXAMPLE.COM" || customer.Phone != "*******210" {
+		t.Errorf("SanitizeCustomerData = %q, %q", customer.Email, customer.Phone)
+	}
+}
+
+func TestValidatePIITagsRejectsUnknownMask(t *testing.T) {
+	type record struct {
+		Name string `pii:"category=name,mask=not_registered"`
+	}
+	if _, err := utils.ParsePIITag("category=name,mask=not_registered"); err != nil {
+		t.Fatalf("ParsePIITag: %v", err)
+	}
+	if err := utils.ValidatePIITags(record{}); err == nil || !strings.Contains(err.Error(), "record.Name") {
+		t.Errorf("ValidatePIITags = %v, want an error naming record.Name", err)
+	}
+}
@@ -1,239 +1,290 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
 	"strings"
 )
 
-// EncryptStructPII uses reflection to encrypt fields tagged with pii:"true"
-func EncryptStructPII(data interface{}, key string) error {
-	// Get the value of the struct
-	val := reflect.ValueOf(data)
+// setBlindIndex writes the blind index of value to the <name>Index field of structVal
+func setBlindIndex(structVal reflect.Value, name string, category PIICategory, value string, keyring *Keyring) error {
+	indexField := structVal.FieldByName(name + "Index")
+	if !indexField.IsValid() || indexField.Kind() != reflect.String || !indexField.CanSet() {
+		return errMissingIndexField(name)
+	}
 
-	// Ensure we're working with a pointer to a struct
-	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
-		return fmt.Errorf("expected pointer to a struct")
+	index, err := blindIndexOf(name, category, value, keyring)
+	if err != nil {
+		return err
 	}
+	indexField.SetString(index)
+	return nil
+}
 
-	// Get the actual struct
-	val = val.Elem()
+// blindIndexOf returns the blind index of a field value; empty values get an empty index
+func blindIndexOf(name string, category PIICategory, value string, keyring *Keyring) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	return keyring.BlindIndex(name, category, value)
+}
 
-	// Iterate over the struct fields
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := val.Type().Field(i)
-
-		// Check if the field is tagged as PII
-		if tag, ok := fieldType.Tag.Lookup("pii"); ok && tag == "true" {
-			// Perform encryption on PII fields
-			encryptedValue, err := Encrypt(field.String(), key)
-			if err != nil {
-				log.Printf("Failed to encrypt %s: %v", fieldType.Name, err)
-				return err
-			}
-			field.SetString(encryptedValue)
-		}
-		// If it's a nested struct, recursively encrypt it
-		if field.Kind() == reflect.Struct {
-			err := EncryptStructPII(field.Addr().Interface(), key)
-			if err != nil {
+func errMissingIndexField(name string) error {
+	return fmt.Errorf("field %s is tagged with index but has no %sIndex string field", name, name)
+}
 
-				return err
-			}
-		}
+// FieldError reports the field whose value could not be encrypted or decrypted.
+type FieldError struct {
+	Op   string
+	Path string
+	Err  error
+}
 
-		// Handle slices or arrays of structs
-		if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
-			// Check if the slice contains structs
-			if field.Type().Elem().Kind() == reflect.Struct {
-				for j := 0; j < field.Len(); j++ {
-					// Get the element as a struct pointer and encrypt it
-					elem := field.Index(j).Addr().Interface()
-					err := EncryptStructPII(elem, key)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
-	}
-	return nil
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
 }
 
-// DecryptStructPII uses reflection to decrypt fields tagged with pii:"true"
-func DecryptStructPII(data interface{}, key string) error {
-	val := reflect.ValueOf(data).Elem()
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := val.Type().Field(i)
-
-		// Check if the field is tagged as PII
-		if tag, ok := fieldType.Tag.Lookup("pii"); ok && tag == "true" {
-			// Perform decryption on PII fields
-			decryptedValue, err := Decrypt(field.String(), key)
-			if err != nil {
-				log.Printf("Failed to decrypt %s: %v", fieldType.Name, err)
-				return err
-			}
-			field.SetString(decryptedValue)
-		}
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
 
-		// If it's a nested struct, recursively encrypt it
-		if field.Kind() == reflect.Struct {
-			err := DecryptStructPII(field.Addr().Interface(), key)
-			if err != nil {
+// fieldBinding identifies where a value lives: the ID of the record and the
+// dotted path of the field within it, as reported by walkPII.
+type fieldBinding struct {
+	recordID string
+	path     string
+}
 
-				return err
-			}
-		}
+// associatedData is the AEAD associated data binding a ciphertext to its record and field
+func (b fieldBinding) associatedData() []byte {
+	return []byte(b.recordID + "\x00" + b.path)
+}
 
-		// Handle slices or arrays of structs
-		if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
-			// Check if the slice contains structs
-			if field.Type().Elem().Kind() == reflect.Struct {
-				for j := 0; j < field.Len(); j++ {
-					// Get the element as a struct pointer and encrypt it
-					elem := field.Index(j).Addr().Interface()
-					err := DecryptStructPII(elem, key)
-					if err != nil {
-						return err
-					}
-				}
-			}
+// recordIDOf returns the value of the _id field of the struct data points to, if it has one
+func recordIDOf(data interface{}) string {
+	val := reflect.Indirect(reflect.ValueOf(data))
+	if val.Kind() != reflect.Struct {
+		return ""
+	}
+	for i := 0; i < val.NumField(); i++ {
+		name, _, _ := strings.Cut(val.Type().Field(i).Tag.Get("bson"), ",")
+		if name == "_id" && val.Field(i).Kind() == reflect.String {
+			return val.Field(i).String()
 		}
 	}
-	return nil
+	return ""
 }
 
-// RedactPII redacts fields based on role
-func RedactPII(v interface{}, role string) {
-	val := reflect.ValueOf(v).Elem() // Get the value of the struct
-	typ := val.Type()                // Get the type of the struct
-
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := typ.Field(i)
-
-		// Check if the field has the `pii:"true"` tag
-		if tag := fieldType.Tag.Get("pii"); tag == "true" {
-			if shouldRedact(role) {
-				// Redact the field (if it's a string)
-				if field.Kind() == reflect.String {
-					field.SetString("REDACTED")
-				}
+// encryptValue encrypts a single field value in the mode selected by its tag.
+// Randomized values are bound to their record and field; deterministic values
+// only to their field, so equal values still match across records. Values
+// that cannot keep their format, because the keyring has no data key or the
+// value is too short for FF1, are encrypted randomized or given a random
+// token instead.
+func encryptValue(keyring *Keyring, value string, binding fieldBinding, name string, tag PIIDescriptor) (string, error) {
+	switch tag.Enc {
+	case EncToken, EncTokenFPE:
+		if keyring.tokenizer == nil {
+			return "", ErrNoTokenizer
+		}
+		if tag.Enc == EncTokenFPE {
+			token, err := keyring.tokenizer.Tokenize(name, value, TokenFormatPreserving)
+			if !errors.Is(err, ErrFPEValueTooShort) {
+				return token, err
 			}
 		}
-
-		// Handle nested structs (like Address)
-		if field.Kind() == reflect.Struct {
-			RedactPII(field.Addr().Interface(), role) // Recursively redact nested structs
+		return keyring.tokenizer.Tokenize(name, value, TokenFormatRandom)
+	case EncFPE:
+		// decryptValue tells the fallback apart: FF1 output is never an envelope
+		ciphertext, err := keyring.EncryptFormatPreserving(value, []byte(name))
+		if errors.Is(err, ErrFPERequiresDataKey) || errors.Is(err, ErrFPEValueTooShort) {
+			break
 		}
+		return ciphertext, err
+	case EncDeterministic:
+		return keyring.EncryptDeterministic(value, []byte(binding.path))
 	}
+	return keyring.EncryptBound(value, binding.associatedData())
 }
 
-// shouldRedact returns true if the given role should not have access to PII
-func shouldRedact(role string) bool {
-	// Define roles that can view PII (e.g., "admin" or "manager")
-	allowedRoles := map[string]bool{
-		"admin":   true,
-		"manager": true,
+// decryptValue decrypts a single field value. The envelope version decides
+// the mode, so values written before a field changed mode still decrypt.
+func decryptValue(keyring *Keyring, value string, binding fieldBinding, name string, tag PIIDescriptor) (string, error) {
+	// Tokens are left in place; only values encrypted before the field was
+	// tokenized are decrypted
+	if tag.Tokenized() && !IsEnvelope(value) {
+		return value, nil
 	}
-
-	// If the role is not in the allowed list, redact the field
-	_, canViewPII := allowedRoles[role]
-	return !canViewPII
+	// FPE values are never envelopes and only exist on records with a data key
+	if tag.Enc == EncFPE && !IsEnvelope(value) && keyring.shared != nil {
+		return keyring.DecryptFormatPreserving(value, []byte(name))
+	}
+	if env, err := ParseEnvelope(value); err == nil && env.Version == envelopeVersionSIV {
+		return keyring.DecryptDeterministic(value, []byte(binding.path))
+	}
+	return keyring.DecryptBound(value, binding.associatedData())
 }
 
-// SanitizeCustomerData sanitizes PII based on role
-func SanitizeCustomerData(v interface{}, role string) {
-	val := reflect.ValueOf(v).Elem() // Get the value of the struct
-	typ := val.Type()                // Get the type of the struct
-
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := typ.Field(i)
-
-		// Check if the field has the `pii:"true"` tag
-		if tag := fieldType.Tag.Get("pii"); tag == "true" {
-			if shouldSanitize(role, fieldType.Name) {
-				// Sanitize the field (if it's a string)
-				if field.Kind() == reflect.String {
-					field.SetString(maskPII(field.String(), fieldType.Name))
-				}
+// EncryptStructPII uses reflection to encrypt fields tagged with pii:"true"
+// using the keyring's active key. Each ciphertext is bound to the struct's
+// _id and the field's path through AEAD associated data, so it cannot be
+// moved to another record or field. Fields tagged pii:"true,deterministic"
+// are encrypted with Keyring.EncryptDeterministic instead, and fields tagged
+// pii:"true,fpe" with Keyring.EncryptFormatPreserving when keyring holds a
+// record's data key (see EncryptRecordPII) and randomized otherwise. Fields tagged
+// pii:"true,token" are replaced by a token from the keyring's tokenizer (see
+// Keyring.WithTokenizer), or a format-preserving token when also tagged fpe.
+func EncryptStructPII(data interface{}, keyring *Keyring) error {
+	id := recordIDOf(data)
+	return walkPII(data, func(field piiField) error {
+		// Write the blind index before the plaintext is replaced
+		if field.Tag.Index {
+			if err := setBlindIndex(field.Parent, field.Name, field.Tag.Category, field.Value.String(), keyring); err != nil {
+				return err
 			}
 		}
 
-		// Handle nested structs (like Address)
-		if field.Kind() == reflect.Struct {
-			SanitizeCustomerData(field.Addr().Interface(), role) // Recursively sanitize nested structs
+		encryptedValue, err := encryptField(keyring, id, field.Path, field.Name, field.Tag, field.Value.String())
+		if err != nil {
+			return err
 		}
-	}
+		field.Value.SetString(encryptedValue)
+		return nil
+	})
 }
 
-// shouldSanitize checks if a field should be sanitized based on the role
-func shouldSanitize(role, fieldName string) bool {
-	// Define roles that have full access to specific fields
-	if role == "admin" {
-		return false // Admins can view all PII
-	}
-
-	if role == "manager" && (fieldName == "Email" || fieldName == "Phone") {
-		return false // Manager can view email, phone but not others
-	}
-
-	// Default case: sanitize for viewers and others
-	return true
+// DecryptStructPII uses reflection to decrypt fields tagged with pii:"true"
+// using whichever keyring key produced each value. A value moved from another
+// record or field fails to decrypt with a *FieldError naming the field.
+func DecryptStructPII(data interface{}, keyring *Keyring) error {
+	id := recordIDOf(data)
+	return walkPII(data, func(field piiField) error {
+		decryptedValue, err := decryptField(keyring, id, field.Path, field.Name, field.Tag, field.Value.String())
+		if err != nil {
+			return err
+		}
+		field.Value.SetString(decryptedValue)
+		return nil
+	})
 }
 
-// maskPII returns a sanitized version of the PII field
-func maskPII(value string, fieldName string) string {
-	switch fieldName {
-	case "Email":
-		// Mask email address
-		return maskEmail(value)
-	case "Phone":
-		// Mask phone number
-		if len(value) >= 10 {
-			return "*******" + value[len(value)-3:]
+// RotateStructPII re-encrypts the fields of data tagged with pii:"true" that
+// were not encrypted with the keyring's active keys: randomized values under
+// a retired key, in the legacy AES-CFB format or not bound to their record
+// and field by associated data they record, and deterministic values under
+// a retired shared key. Unbound values are read even when the keyring
+// requires binding, so the job can upgrade them. It reports whether any
+// field changed. Tokens and format-preserving values are left alone; they
+// are tied to the record's data key, which is rotated by re-wrapping it
+// instead.
+func RotateStructPII(data interface{}, keyring *Keyring) (bool, error) {
+	id := recordIDOf(data)
+	lenient := keyring.AllowingUnbound()
+	changed := false
+	err := walkPII(data, func(field piiField) error {
+		value := field.Value.String()
+		if !keyring.fieldNeedsRotation(value, field.Tag) {
+			return nil
 		}
-	case "DocNumber":
-		// Mask Doc number
-		if len(value) >= 4 {
-			return "***-**-" + value[len(value)-4:]
+		plaintext, err := decryptField(lenient, id, field.Path, field.Name, field.Tag, value)
+		if err != nil {
+			return err
 		}
-	case "DOB":
-		// Mask DOB (show only the year)
-		parts := strings.Split(value, "-")
-		if len(parts) >= 1 {
-			return "**-**-" + parts[len(parts)-1]
+		encryptedValue, err := encryptField(keyring, id, field.Path, field.Name, field.Tag, plaintext)
+		if err != nil {
+			return err
 		}
-		return "****-**-**"
-	}
-	return "REDACTED"
+		field.Value.SetString(encryptedValue)
+		changed = true
+		return nil
+	})
+	return changed, err
 }
 
-// maskEmail masks part of the email address.
-func maskEmail(email string) string {
-	if len(email) == 0 {
-		return "REDACTED"
+// fieldNeedsRotation reports whether a field value is encrypted with a key
+// or envelope version other than the ones encryptValue would use for it now
+func (k *Keyring) fieldNeedsRotation(value string, tag PIIDescriptor) bool {
+	if !IsEnvelope(value) {
+		// Tokens and FPE values are never envelopes; anything else is legacy
+		return value != "" && !tag.Tokenized() && tag.Enc != EncFPE
+	}
+	env, err := ParseEnvelope(value)
+	if err != nil {
+		return false
 	}
-	// Split the email into the local part (before @) and the domain part (after @).
-	parts := strings.Split(email, "@")
-	if len(parts) != 2 {
-		return "REDACTED"
+	if env.Version == envelopeVersionSIV {
+		return env.KeyID != k.sharedKeyring().ActiveKeyID()
 	}
+	return env.Version != envelopeVersionGCMBound || env.KeyID != k.ActiveKeyID()
+}
 
-	localPart := parts[0]
-	domainPart := parts[1]
+// encryptField encrypts the value of the field at path, reporting failures as a *FieldError
+func encryptField(keyring *Keyring, recordID, path, name string, tag PIIDescriptor, value string) (string, error) {
+	binding := fieldBinding{recordID: recordID, path: path}
+	encryptedValue, err := encryptValue(keyring, value, binding, name, tag)
+	if err != nil {
+		log.Printf("Failed to encrypt %s: %v", path, err)
+		return "", &FieldError{Op: "encrypt", Path: path, Err: err}
+	}
+	return encryptedValue, nil
+}
 
-	// Mask all but the first character of the local part.
-	if len(localPart) > 1 {
-		localPart = string(localPart[0]) + strings.Repeat("*", len(localPart)-1)
-	} else {
-		localPart = "*"
+// decryptField decrypts the value of the field at path, reporting failures as a *FieldError
+func decryptField(keyring *Keyring, recordID, path, name string, tag PIIDescriptor, value string) (string, error) {
+	binding := fieldBinding{recordID: recordID, path: path}
+	decryptedValue, err := decryptValue(keyring, value, binding, name, tag)
+	if err != nil {
+		log.Printf("Failed to decrypt %s: %v", path, err)
+		return "", &FieldError{Op: "decrypt", Path: path, Err: err}
 	}
+	return decryptedValue, nil
+}
 
-	return localPart + "@" + domainPart
+// RedactPII applies the access policy for viewer like SanitizeCustomerData,
+// except that fields the policy masks are fully redacted
+func RedactPII(v interface{}, viewer Viewer) {
+	policy := CurrentAccessPolicy()
+	_ = walkPII(v, func(field piiField) error {
+		action := policy.Action(viewer, field.Path, field.Tag)
+		if action == ActionMask {
+			action = ActionRedact
+		}
+		if value, ok := applyAction(action, field.Tag.Mask, field.Value.String()); ok {
+			field.Value.SetString(value)
+		}
+		return nil
+	})
+}
+
+// SanitizeCustomerData applies the access policy for viewer to the PII fields
+// of v, revealing, masking, redacting or omitting each one
+func SanitizeCustomerData(v interface{}, viewer Viewer) {
+	policy := CurrentAccessPolicy()
+	_ = walkPII(v, func(field piiField) error {
+		if value, ok := sanitizedValue(policy, viewer, field.Path, field.Tag, field.Value.String()); ok {
+			field.Value.SetString(value)
+		}
+		return nil
+	})
+}
+
+// sanitizedValue returns the value viewer may see of the field at path, and
+// whether it differs from value
+func sanitizedValue(policy *AccessPolicy, viewer Viewer, path string, tag PIIDescriptor, value string) (string, bool) {
+	return applyAction(policy.Action(viewer, path, tag), policy.Mask(viewer.Role, tag), value)
+}
+
+// applyAction returns value after action, masked with mask, and whether it changed
+func applyAction(action PolicyAction, mask MaskSpec, value string) (string, bool) {
+	switch action {
+	case ActionReveal:
+		return value, false
+	case ActionMask:
+		return MaskValue(value, mask), true
+	case ActionOmit:
+		return "", true
+	}
+	return "REDACTED", true
 }
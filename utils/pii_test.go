@@ -0,0 +1,135 @@
+package utils_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"zeropii/utils"
+	"zeropii/utils/internal/piifixture"
+)
+
+func TestRotateStructPII(t *testing.T) {
+	base := baseKeyring(t)
+	keyring, err := utils.NewDataKeyring(base, randomKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring = keyring.WithTokenizer(fakeTokenizer{})
+
+	record := newRecord(t).(*piifixture.Record)
+	if err := utils.EncryptStructPII(record, keyring); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := utils.RotateStructPII(record, keyring); err != nil || changed {
+		t.Fatalf("RotateStructPII under unchanged keys = %v, %v", changed, err)
+	}
+
+	before := *record
+	before.Aliases = append([]string(nil), record.Aliases...)
+	if err := base.Rotate("k2", randomKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := utils.RotateStructPII(record, keyring)
+	if err != nil || !changed {
+		t.Fatalf("RotateStructPII after rotating the shared key = %v, %v", changed, err)
+	}
+
+	// Deterministic fields move to the new shared key; fields under the
+	// record's data key stay as they are
+	for _, alias := range record.Aliases {
+		if env, err := utils.ParseEnvelope(alias); err != nil || env.KeyID != "k2" {
+			t.Errorf("alias envelope = %v, %v, want key k2", env, err)
+		}
+	}
+	if record.Email != before.Email || record.Codes != before.Codes || record.Passport != before.Passport {
+		t.Error("RotateStructPII changed fields under the data key")
+	}
+
+	if err := utils.DecryptStructPII(record, keyring); err != nil {
+		t.Fatal(err)
+	}
+	want := newRecord(t).(*piifixture.Record)
+	if !reflect.DeepEqual(record.Aliases, want.Aliases) || record.Email != want.Email {
+		t.Errorf("rotated record decrypts to %+v", record)
+	}
+}
+
+func TestFormatPreservingFallback(t *testing.T) {
+	type account struct {
+		ID    string `bson:"_id"`
+		Phone string `pii:"category=phone,enc=fpe"`
+		PAN   string `pii:"category=national_id,enc=fpe"`
+	}
+	dataKeyring, err := utils.NewDataKeyring(baseKeyring(t), randomKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		keyring       *utils.Keyring
+		phone         string
+		wantFormatted bool
+	}{
+		{"data key", dataKeyring, "9876543210", true},
+		{"no data key", baseKeyring(t), "9876543210", false},
+		{"too short", dataKeyring, "X1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &account{ID: "acc-1", Phone: tt.phone, PAN: "ABCDE1234F"}
+			if err := utils.EncryptStructPII(record, tt.keyring); err != nil {
+				t.Fatal(err)
+			}
+			if formatted := !utils.IsEnvelope(record.Phone); formatted != tt.wantFormatted {
+				t.Errorf("phone %q kept its format: %v, want %v", record.Phone, formatted, tt.wantFormatted)
+			}
+			if tt.wantFormatted && len(record.Phone) != len(tt.phone) {
+				t.Errorf("phone %q changed length", record.Phone)
+			}
+			// The four digits of a PAN are too few for FF1
+			if !utils.IsEnvelope(record.PAN) {
+				t.Errorf("PAN %q kept its format", record.PAN)
+			}
+			if err := utils.DecryptStructPII(record, tt.keyring); err != nil {
+				t.Fatal(err)
+			}
+			if record.Phone != tt.phone || record.PAN != "ABCDE1234F" {
+				t.Errorf("record decrypts to %+v", record)
+			}
+		})
+	}
+}
+
+func TestRotateStructPIIBindsUnboundValues(t *testing.T) {
+	keyring := baseKeyring(t).WithTokenizer(fakeTokenizer{})
+	record := newRecord(t).(*piifixture.Record)
+	email := record.Email
+	if err := utils.EncryptStructPII(record, keyring); err != nil {
+		t.Fatal(err)
+	}
+	// As written before fields were bound to their record and path
+	unbound, err := keyring.Encrypt(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.Email = unbound
+
+	keyring.RequireBinding(true)
+	if err := utils.DecryptStructPII(record, keyring); !errors.Is(err, utils.ErrUnboundCiphertext) {
+		t.Fatalf("DecryptStructPII with an unbound field = %v, want ErrUnboundCiphertext", err)
+	}
+	record.Email = unbound
+
+	changed, err := utils.RotateStructPII(record, keyring)
+	if err != nil || !changed {
+		t.Fatalf("RotateStructPII = %v, %v", changed, err)
+	}
+	if env, err := utils.ParseEnvelope(record.Email); err != nil || env.Version != 3 {
+		t.Fatalf("rotated email envelope = %+v, %v", env, err)
+	}
+	if err := utils.DecryptStructPII(record, keyring); err != nil || record.Email != email {
+		t.Fatalf("rotated record decrypts to %q, %v", record.Email, err)
+	}
+}
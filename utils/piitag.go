@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// PIICategory is the kind of personal data a field holds. Masking and access
+// decisions are keyed on it rather than on Go field names.
+type PIICategory string
+
+const (
+	CategoryOther      PIICategory = "other"
+	CategoryName       PIICategory = "name"
+	CategoryEmail      PIICategory = "email"
+	CategoryPhone      PIICategory = "phone"
+	CategoryDOB        PIICategory = "dob"
+	CategoryAddress    PIICategory = "address"
+	CategoryNationalID PIICategory = "national_id"
+	CategoryPassport   PIICategory = "passport"
+	CategoryDocument   PIICategory = "document"
+	CategoryFinancial  PIICategory = "financial"
+)
+
+var piiCategories = []PIICategory{
+	CategoryOther, CategoryName, CategoryEmail, CategoryPhone, CategoryDOB, CategoryAddress,
+	CategoryNationalID, CategoryPassport, CategoryDocument, CategoryFinancial,
+}
+
+// EncMode is how a field is encrypted at rest.
+type EncMode string
+
+const (
+	EncRandomized    EncMode = "randomized"    // AES-256-GCM bound to record and field
+	EncDeterministic EncMode = "deterministic" // AES-SIV, equal values give equal ciphertexts
+	EncFPE           EncMode = "fpe"           // FF1, keeping length and character classes
+	EncToken         EncMode = "token"         // replaced by a random token from the vault
+	EncTokenFPE      EncMode = "token_fpe"     // replaced by a format-preserving token
+)
+
+var encModes = []EncMode{EncRandomized, EncDeterministic, EncFPE, EncToken, EncTokenFPE}
+
+// Sensitivity ranks how harmful disclosure of a field would be.
+type Sensitivity string
+
+const (
+	SensitivityLow    Sensitivity = "low"
+	SensitivityMedium Sensitivity = "medium"
+	SensitivityHigh   Sensitivity = "high"
+)
+
+var sensitivities = []Sensitivity{SensitivityLow, SensitivityMedium, SensitivityHigh}
+
+// PIIDescriptor is a parsed pii struct tag. A tag is a comma-separated list
+// of key=value options and flags:
+//
+//	pii:"category=dob,mask=year_only,enc=randomized,level=high"
+//	pii:"category=email,index"
+//
+// category, enc and level take the values of PIICategory, EncMode and
+// Sensitivity, and mask a MaskSpec. The flag index also writes a blind
+// index to the sibling <Field>Index field. The original form pii:"true",
+// optionally followed by the flags index, deterministic, fpe and token, keeps
+// working: it describes a randomized field of category other.
+type PIIDescriptor struct {
+	Enabled  bool
+	Category PIICategory
+	Mask     MaskSpec
+	Enc      EncMode
+	Index    bool
+	Level    Sensitivity
+}
+
+// Tokenized reports whether the field holds a token rather than a ciphertext.
+func (d PIIDescriptor) Tokenized() bool {
+	return d.Enc == EncToken || d.Enc == EncTokenFPE
+}
+
+// defaultMasks is the mask of each category whose tag sets none.
+var defaultMasks = map[PIICategory]MaskSpec{
+	CategoryEmail:    MaskEmail,
+	CategoryPhone:    MaskLast3,
+	CategoryDOB:      MaskYearOnly,
+	CategoryDocument: MaskLast4,
+}
+
+// ParsePIITag parses the value of a pii struct tag. The empty tag, "false"
+// and "-" describe a field without PII.
+func ParsePIITag(value string) (PIIDescriptor, error) {
+	var d PIIDescriptor
+	value = strings.TrimSpace(value)
+	if value == "" || value == "false" || value == "-" {
+		return d, nil
+	}
+
+	var deterministic, fpe, token bool
+	for i, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		key, val, isKeyValue := strings.Cut(option, "=")
+		if !isKeyValue {
+			switch {
+			case option == "true" && i == 0:
+			case option == "index":
+				d.Index = true
+			case option == "deterministic":
+				deterministic = true
+			case option == "fpe":
+				fpe = true
+			case option == "token":
+				token = true
+			default:
+				return PIIDescriptor{}, fmt.Errorf("unknown pii tag option %q", option)
+			}
+			continue
+		}
+
+		var err error
+		switch key {
+		case "category":
+			d.Category, err = parseOption("pii tag category", PIICategory(val), piiCategories)
+		case "mask":
+			d.Mask, err = parseMaskSpec(val)
+		case "enc":
+			d.Enc, err = parseOption("pii tag enc", EncMode(val), encModes)
+		case "level":
+			d.Level, err = parseOption("pii tag level", Sensitivity(val), sensitivities)
+		default:
+			err = fmt.Errorf("unknown pii tag option %q", key)
+		}
+		if err != nil {
+			return PIIDescriptor{}, err
+		}
+	}
+	d.Enabled = true
+
+	// The flags of the original form select the encryption mode
+	flagEnc := EncRandomized
+	switch {
+	case token && fpe:
+		flagEnc = EncTokenFPE
+	case token:
+		flagEnc = EncToken
+	case fpe:
+		flagEnc = EncFPE
+	case deterministic:
+		flagEnc = EncDeterministic
+	}
+	if (deterministic && (fpe || token)) || (d.Enc != "" && flagEnc != EncRandomized && d.Enc != flagEnc) {
+		return PIIDescriptor{}, fmt.Errorf("pii tag %q selects more than one encryption mode", value)
+	}
+	if d.Enc == "" {
+		d.Enc = flagEnc
+	}
+
+	if d.Category == "" {
+		d.Category = CategoryOther
+	}
+	if d.Mask == "" {
+		d.Mask = defaultMasks[d.Category]
+		if d.Mask == "" {
+			d.Mask = MaskFull
+		}
+	}
+	if d.Level == "" {
+		d.Level = SensitivityHigh
+	}
+	return d, nil
+}
+
+// parseMaskSpec checks the syntax of a mask option. Whether it names a
+// registered strategy is only known once the application has registered its
+// own, so ValidatePIITags checks that.
+func parseMaskSpec(value string) (MaskSpec, error) {
+	if !maskSpecSyntax.MatchString(value) {
+		return "", fmt.Errorf("invalid pii tag mask %q", value)
+	}
+	return MaskSpec(value), nil
+}
+
+func parseOption[T ~string](key string, value T, allowed []T) (T, error) {
+	for _, option := range allowed {
+		if value == option {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unknown %s %q", key, value)
+}
+
+// parsePIITag parses the pii tag of a struct field
+func parsePIITag(field reflect.StructField) PIIDescriptor {
+	return parsePIITagValue(field.Tag.Get("pii"))
+}
+
+// parsePIITagValue parses the value of a pii tag. An invalid tag still marks
+// PII, so it is treated as randomized, fully masked and highly sensitive;
+// ValidatePIITags reports it at startup.
+func parsePIITagValue(value string) PIIDescriptor {
+	d, err := ParsePIITag(value)
+	if err != nil {
+		return PIIDescriptor{
+			Enabled:  true,
+			Category: CategoryOther,
+			Mask:     MaskFull,
+			Enc:      EncRandomized,
+			Level:    SensitivityHigh,
+		}
+	}
+	return d
+}
+
+// ValidatePIITags checks the pii tags of every struct type reachable from the
+// types of models, and that their masks are registered, returning an error
+// naming the first invalid field.
+func ValidatePIITags(models ...interface{}) error {
+	seen := map[reflect.Type]bool{}
+	for _, model := range models {
+		err := eachPIITag(reflect.TypeOf(model), seen, func(t reflect.Type, field reflect.StructField) error {
+			tag, err := ParsePIITag(field.Tag.Get("pii"))
+			if err == nil && tag.Enabled {
+				_, err = ResolveMask(tag.Mask)
+			}
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PIICategoriesOf returns the categories of the PII fields reachable from the
+// type of model whose sensitivity is at least level, in declaration order of
+// the categories.
+func PIICategoriesOf(model interface{}, level Sensitivity) []PIICategory {
+	found := map[PIICategory]bool{}
+	minLevel := slices.Index(sensitivities, level)
+	_ = eachPIITag(reflect.TypeOf(model), map[reflect.Type]bool{}, func(t reflect.Type, field reflect.StructField) error {
+		tag := parsePIITag(field)
+		if tag.Enabled && slices.Index(sensitivities, tag.Level) >= minLevel {
+			found[tag.Category] = true
+		}
+		return nil
+	})
+
+	var categories []PIICategory
+	for _, category := range piiCategories {
+		if found[category] {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// PIIFieldCategory returns the category of the first PII field called name
+// among the types reachable from the type of model, or CategoryOther if no
+// PII field has that name.
+func PIIFieldCategory(model interface{}, name string) PIICategory {
+	category := CategoryOther
+	_ = eachPIITag(reflect.TypeOf(model), map[reflect.Type]bool{}, func(t reflect.Type, field reflect.StructField) error {
+		if tag := parsePIITag(field); field.Name == name && tag.Enabled {
+			category = tag.Category
+			return errFound
+		}
+		return nil
+	})
+	return category
+}
+
+// errFound stops eachPIITag once a field is found
+var errFound = errors.New("found")
+
+// eachPIITag calls visit for every field of every struct type reachable from
+// t, stopping at the first error.
+func eachPIITag(t reflect.Type, seen map[reflect.Type]bool, visit func(t reflect.Type, field reflect.StructField) error) error {
+	if t == nil || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return eachPIITag(t.Elem(), seen, visit)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if err := visit(t, field); err != nil {
+				return err
+			}
+			if err := eachPIITag(field.Type, seen, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
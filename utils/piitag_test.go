@@ -0,0 +1,123 @@
+package utils_test
+
+import (
+	"testing"
+
+	"zeropii/models"
+	"zeropii/utils"
+)
+
+func TestParsePIITag(t *testing.T) {
+	// descriptor is the parse of a tag with everything but the given
+	// options left at their defaults
+	descriptor := func(category utils.PIICategory, mask utils.MaskSpec, enc utils.EncMode) utils.PIIDescriptor {
+		return utils.PIIDescriptor{Enabled: true, Category: category, Mask: mask, Enc: enc, Level: utils.SensitivityHigh}
+	}
+	indexed := func(d utils.PIIDescriptor) utils.PIIDescriptor {
+		d.Index = true
+		return d
+	}
+
+	tests := []struct {
+		tag  string
+		want utils.PIIDescriptor
+	}{
+		// No PII
+		{"", utils.PIIDescriptor{}},
+		{"-", utils.PIIDescriptor{}},
+		{"false", utils.PIIDescriptor{}},
+		{" false ", utils.PIIDescriptor{}},
+
+		// The original form and its flags
+		{"true", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncRandomized)},
+		{"true,index", indexed(descriptor(utils.CategoryOther, utils.MaskFull, utils.EncRandomized))},
+		{"true,deterministic", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncDeterministic)},
+		{"true,fpe", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncFPE)},
+		{"true,token", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncToken)},
+		{"true,token,fpe", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncTokenFPE)},
+		{"true,index,fpe", indexed(descriptor(utils.CategoryOther, utils.MaskFull, utils.EncFPE))},
+		{"true, index , deterministic", indexed(descriptor(utils.CategoryOther, utils.MaskFull, utils.EncDeterministic))},
+
+		// Default masks by category
+		{"category=email", descriptor(utils.CategoryEmail, utils.MaskEmail, utils.EncRandomized)},
+		{"category=phone", descriptor(utils.CategoryPhone, utils.MaskLast3, utils.EncRandomized)},
+		{"category=dob", descriptor(utils.CategoryDOB, utils.MaskYearOnly, utils.EncRandomized)},
+		{"category=document", descriptor(utils.CategoryDocument, utils.MaskLast4, utils.EncRandomized)},
+		{"category=name", descriptor(utils.CategoryName, utils.MaskFull, utils.EncRandomized)},
+		{"category=address", descriptor(utils.CategoryAddress, utils.MaskFull, utils.EncRandomized)},
+		{"category=national_id", descriptor(utils.CategoryNationalID, utils.MaskFull, utils.EncRandomized)},
+		{"category=passport", descriptor(utils.CategoryPassport, utils.MaskFull, utils.EncRandomized)},
+		{"category=financial", descriptor(utils.CategoryFinancial, utils.MaskFull, utils.EncRandomized)},
+		{"category=other", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncRandomized)},
+
+		// Explicit options
+		{"category=dob,mask=date:decade", descriptor(utils.CategoryDOB, "date:decade", utils.EncRandomized)},
+		{"category=phone,mask=partial:2:2,enc=fpe", descriptor(utils.CategoryPhone, "partial:2:2", utils.EncFPE)},
+		{"category=email,index,level=medium", utils.PIIDescriptor{
+			Enabled: true, Category: utils.CategoryEmail, Mask: utils.MaskEmail, Enc: utils.EncRandomized, Index: true, Level: utils.SensitivityMedium,
+		}},
+		{"enc=token_fpe", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncTokenFPE)},
+		// Flags agreeing with enc are accepted
+		{"enc=fpe,fpe", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncFPE)},
+		{"enc=token_fpe,token,fpe", descriptor(utils.CategoryOther, utils.MaskFull, utils.EncTokenFPE)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, err := utils.ParsePIITag(tt.tag)
+			if err != nil || got != tt.want {
+				t.Errorf("ParsePIITag(%q) = %+v, %v, want %+v", tt.tag, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePIITagRejectsInvalid(t *testing.T) {
+	tags := []string{
+		// Conflicting encryption modes
+		"true,deterministic,fpe",
+		"deterministic,token",
+		"enc=fpe,token",
+		"enc=token,fpe",
+		"enc=randomized,deterministic",
+		"enc=deterministic,token,fpe",
+
+		// Unknown options and values
+		"yes",
+		"encrypt",
+		"index,true",
+		"true,true",
+		"sensitive=high",
+		"category=ssn",
+		"category=",
+		"enc=aes",
+		"level=critical",
+		"mask=Last4",
+		"mask=",
+		"mask=partial:1 2",
+	}
+	for _, tag := range tags {
+		t.Run(tag, func(t *testing.T) {
+			if d, err := utils.ParsePIITag(tag); err == nil {
+				t.Errorf("ParsePIITag(%q) = %+v, want an error", tag, d)
+			}
+		})
+	}
+}
+
+func TestPIIFieldCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		want utils.PIICategory
+	}{
+		{"Email", utils.CategoryEmail},
+		{"PassportNumber", utils.CategoryPassport},
+		{"PanNumber", utils.CategoryNationalID},
+		{"ID", utils.CategoryOther},
+		{"made_up", utils.CategoryOther},
+	}
+	for _, tt := range tests {
+		if got := utils.PIIFieldCategory(models.Customer{}, tt.name); got != tt.want {
+			t.Errorf("PIIFieldCategory(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"reflect"
+	"sync"
+)
+
+// typePlan lists the fields of a struct type that the PII walker has to look
+// at, resolved once per type so walks replay it instead of re-reading struct
+// fields and re-parsing pii tags on every call. Directly nested structs are
+// flattened into index paths; fields that can never reach a tagged string are
+// left out altogether.
+type typePlan struct {
+	fields []fieldPlan
+}
+
+type fieldPlan struct {
+	// index is the index path of the field from the planned struct, for
+	// reflect.Value.FieldByIndex
+	index []int
+	// parent is the index path of the struct declaring the field
+	parent []int
+	// path is the dotted path of the field from the planned struct
+	path string
+	name string
+	tag  PIIDescriptor
+}
+
+// typePlans caches a *typePlan per reflect.Type.
+var typePlans sync.Map
+
+// planFor returns the cached plan of struct type t, building it on first use.
+func planFor(t reflect.Type) *typePlan {
+	if plan, ok := typePlans.Load(t); ok {
+		return plan.(*typePlan)
+	}
+	plan, _ := typePlans.LoadOrStore(t, buildTypePlan(t))
+	return plan.(*typePlan)
+}
+
+// buildTypePlan resolves the plan of struct type t.
+func buildTypePlan(t reflect.Type) *typePlan {
+	plan := &typePlan{}
+	plan.addStruct(t, nil, "", map[planKey]bool{})
+	return plan
+}
+
+func (p *typePlan) addStruct(t reflect.Type, index []int, path string, visiting map[planKey]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// Unexported fields cannot be set
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		fieldPath := joinPath(path, field.Name)
+		tag := parsePIITag(field)
+
+		switch {
+		case field.Type.Kind() == reflect.String:
+			if tag.Enabled {
+				p.fields = append(p.fields, fieldPlan{
+					index: fieldIndex, parent: index, path: fieldPath,
+					name: field.Name, tag: tag,
+				})
+			}
+		case field.Type.Kind() == reflect.Struct:
+			// A struct cannot contain itself by value, so this terminates
+			p.addStruct(field.Type, fieldIndex, fieldPath, visiting)
+		case mayContainPII(field.Type, tag.Enabled, visiting):
+			p.fields = append(p.fields, fieldPlan{
+				index: fieldIndex, parent: index, path: fieldPath,
+				name: field.Name, tag: tag,
+			})
+		}
+	}
+}
+
+type planKey struct {
+	typ    reflect.Type
+	tagged bool
+}
+
+// mayContainPII reports whether a value of type t, held by a field that is
+// tagged or not, can lead to a tagged string. Interfaces always may. Types
+// already being resolved count as not containing PII, which is what makes
+// recursive types terminate; their other fields still decide the answer.
+func mayContainPII(t reflect.Type, tagged bool, visiting map[planKey]bool) bool {
+	key := planKey{t, tagged}
+	if visiting[key] {
+		return false
+	}
+	visiting[key] = true
+	defer delete(visiting, key)
+
+	switch t.Kind() {
+	case reflect.String:
+		return tagged
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return mayContainPII(t.Elem(), tagged, visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if mayContainPII(field.Type, parsePIITag(field).Enabled, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyAction is what a role sees of a PII field.
+type PolicyAction string
+
+const (
+	ActionReveal PolicyAction = "reveal" // the plaintext
+	ActionMask   PolicyAction = "mask"   // the value masked with the field's mask strategy
+	ActionRedact PolicyAction = "redact" // "REDACTED"
+	ActionOmit   PolicyAction = "omit"   // an empty value, dropped from omitempty JSON
+
+	// ActionRefuse rejects the whole read; only ConsentPolicy uses it
+	ActionRefuse PolicyAction = "refuse"
+)
+
+// policyActions is ordered from the least to the most restrictive action.
+var policyActions = []PolicyAction{ActionReveal, ActionMask, ActionRedact, ActionOmit}
+
+var consentActions = []PolicyAction{ActionReveal, ActionMask, ActionRedact, ActionOmit, ActionRefuse}
+
+// Viewer is who reads PII and why: the role from the x-viewer-role header,
+// the purpose from the x-access-purpose header and the calling application
+// from the x-application-name header.
+type Viewer struct {
+	Role        string
+	Purpose     string
+	Application string
+	// Consented is set when the customer whose PII is read has given
+	// Application an active consent
+	Consented bool
+}
+
+// AccessPolicy maps roles to the action applied to each PII field they read.
+// It is loaded from YAML:
+//
+//	default: mask
+//	roles:
+//	  manager:
+//	    inherits: viewer
+//	    categories:
+//	      email: reveal
+//	      phone: reveal
+//	    fields:
+//	      Pan.PanNumber: redact
+//	    masks:
+//	      phone: partial:2:2
+//	purposes:
+//	  support:
+//	    categories: [name, email, phone]
+//
+// For a field, the first of these that is set decides: the role's rule for
+// the field's path, its rule for the field's category, its default, then the
+// same three looked up through the roles it inherits from, most specific
+// first, and finally the policy default. Roles not in the policy get the
+// policy default.
+//
+// The viewer's purpose then limits the result: fields whose category the
+// purpose does not list are at least redacted, and a viewer without a
+// purpose gets the most restrictive view, every field omitted. Without the
+// customer's consent, fields are limited further by the consent policy.
+// Token fields hold no PII, so only a field rule changes them.
+type AccessPolicy struct {
+	Default  PolicyAction             `yaml:"default"`
+	Roles    map[string]RolePolicy    `yaml:"roles"`
+	Purposes map[string]PurposePolicy `yaml:"purposes"`
+	Consent  ConsentPolicy            `yaml:"consent"`
+}
+
+// RolePolicy is the part of an AccessPolicy for one role.
+type RolePolicy struct {
+	Inherits   string                       `yaml:"inherits"`
+	Default    PolicyAction                 `yaml:"default"`
+	Categories map[PIICategory]PolicyAction `yaml:"categories"`
+	Fields     map[string]PolicyAction      `yaml:"fields"`
+	// Masks overrides the mask of the fields of a category that the role
+	// sees masked; other fields use the mask of their tag
+	Masks map[PIICategory]MaskSpec `yaml:"masks"`
+}
+
+// PurposePolicy is the part of an AccessPolicy for one access purpose.
+type PurposePolicy struct {
+	// Categories are the PII categories the purpose justifies access to
+	Categories []PIICategory `yaml:"categories"`
+}
+
+// ConsentPolicy is the part of an AccessPolicy for applications the customer
+// has not given an active consent.
+type ConsentPolicy struct {
+	// Without is the least restrictive action such applications get: mask,
+	// redact or omit, or refuse to reject the read. reveal turns consent
+	// checks off. Defaults to mask.
+	Without PolicyAction `yaml:"without"`
+}
+
+// DefaultAccessPolicy is used until a policy file is loaded: admins see all
+// PII, managers see email and phone, and every other role sees masked values.
+// KYC review may access every category, fraud investigation identity and
+// contact details, and support contact details only. Applications without
+// consent see masked values.
+func DefaultAccessPolicy() *AccessPolicy {
+	return &AccessPolicy{
+		Default: ActionMask,
+		Consent: ConsentPolicy{Without: ActionMask},
+		Roles: map[string]RolePolicy{
+			"admin": {Default: ActionReveal},
+			"manager": {Categories: map[PIICategory]PolicyAction{
+				CategoryEmail: ActionReveal,
+				CategoryPhone: ActionReveal,
+			}},
+		},
+		Purposes: map[string]PurposePolicy{
+			"kyc_review": {Categories: piiCategories},
+			"fraud": {Categories: []PIICategory{
+				CategoryName, CategoryEmail, CategoryPhone, CategoryDOB, CategoryAddress,
+				CategoryNationalID, CategoryPassport, CategoryFinancial,
+			}},
+			"support": {Categories: []PIICategory{CategoryName, CategoryEmail, CategoryPhone}},
+		},
+	}
+}
+
+// LoadAccessPolicy reads and validates the YAML policy at path.
+func LoadAccessPolicy(path string) (*AccessPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAccessPolicy(data)
+}
+
+// ParseAccessPolicy parses and validates a YAML policy.
+func ParseAccessPolicy(data []byte) (*AccessPolicy, error) {
+	var policy AccessPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse access policy: %w", err)
+	}
+	if policy.Default == "" {
+		policy.Default = ActionMask
+	}
+	if policy.Consent.Without == "" {
+		policy.Consent.Without = ActionMask
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *AccessPolicy) validate() error {
+	if _, err := parseOption("default action", p.Default, policyActions); err != nil {
+		return err
+	}
+	for name, role := range p.Roles {
+		if role.Default != "" {
+			if _, err := parseOption("action", role.Default, policyActions); err != nil {
+				return fmt.Errorf("role %s: %w", name, err)
+			}
+		}
+		for category, action := range role.Categories {
+			if _, err := parseOption("category", category, piiCategories); err != nil {
+				return fmt.Errorf("role %s: %w", name, err)
+			}
+			if _, err := parseOption("action", action, policyActions); err != nil {
+				return fmt.Errorf("role %s, category %s: %w", name, category, err)
+			}
+		}
+		for path, action := range role.Fields {
+			if _, err := parseOption("action", action, policyActions); err != nil {
+				return fmt.Errorf("role %s, field %s: %w", name, path, err)
+			}
+		}
+		for category, mask := range role.Masks {
+			if _, err := parseOption("category", category, piiCategories); err != nil {
+				return fmt.Errorf("role %s: %w", name, err)
+			}
+			if _, err := ResolveMask(mask); err != nil {
+				return fmt.Errorf("role %s, category %s: %w", name, category, err)
+			}
+		}
+
+		// Every inherited role must exist and the chain must end
+		seen := map[string]bool{name: true}
+		for parent := role.Inherits; parent != ""; parent = p.Roles[parent].Inherits {
+			if _, ok := p.Roles[parent]; !ok {
+				return fmt.Errorf("role %s inherits unknown role %s", name, parent)
+			}
+			if seen[parent] {
+				return fmt.Errorf("role %s inherits from itself through %s", name, parent)
+			}
+			seen[parent] = true
+		}
+	}
+	if _, err := parseOption("consent action", p.Consent.Without, consentActions); err != nil {
+		return err
+	}
+	for name, purpose := range p.Purposes {
+		for _, category := range purpose.Categories {
+			if _, err := parseOption("category", category, piiCategories); err != nil {
+				return fmt.Errorf("purpose %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Action returns what viewer sees of the field at path described by tag.
+// Each role along the inheritance chain is asked in turn for its field,
+// category and default rules before the role it inherits from, so a child's
+// default overrides a parent's category rule.
+func (p *AccessPolicy) Action(viewer Viewer, path string, tag PIIDescriptor) PolicyAction {
+	for _, r := range p.chain(viewer.Role) {
+		if action, ok := r.Fields[path]; ok {
+			return p.limit(action, viewer, tag)
+		}
+		// Tokens are not PII; only field rules apply to them
+		if tag.Tokenized() {
+			continue
+		}
+		if action, ok := r.Categories[tag.Category]; ok {
+			return p.limit(action, viewer, tag)
+		}
+		if r.Default != "" {
+			return p.limit(r.Default, viewer, tag)
+		}
+	}
+	if tag.Tokenized() {
+		return ActionReveal
+	}
+	return p.limit(p.Default, viewer, tag)
+}
+
+// Mask returns the mask applied to the field described by tag when role sees
+// it masked: the first mask set for its category along the role's
+// inheritance chain, or else the mask of its tag.
+func (p *AccessPolicy) Mask(role string, tag PIIDescriptor) MaskSpec {
+	for _, r := range p.chain(role) {
+		if mask, ok := r.Masks[tag.Category]; ok {
+			return mask
+		}
+	}
+	return tag.Mask
+}
+
+// limit returns action, made an omission when the viewer declares no
+// purpose, at least a redaction when the viewer's purpose does not justify
+// access to the field's category, and at least the consent policy's action
+// when the viewer has no consent
+func (p *AccessPolicy) limit(action PolicyAction, viewer Viewer, tag PIIDescriptor) PolicyAction {
+	if tag.Tokenized() {
+		return action
+	}
+	if viewer.Purpose == "" {
+		return ActionOmit
+	}
+	if !p.Allows(viewer.Purpose, tag.Category) {
+		action = stricter(action, ActionRedact)
+	}
+	if !viewer.Consented {
+		without := p.Consent.Without
+		// Reads the policy refuses should not get here; never reveal more
+		// than a redaction if they do
+		if without == ActionRefuse {
+			without = ActionRedact
+		}
+		action = stricter(action, without)
+	}
+	return action
+}
+
+// stricter returns the more restrictive of two actions
+func stricter(a, b PolicyAction) PolicyAction {
+	if slices.Index(policyActions, a) < slices.Index(policyActions, b) {
+		return b
+	}
+	return a
+}
+
+// HasPurpose reports whether the policy defines purpose.
+func (p *AccessPolicy) HasPurpose(purpose string) bool {
+	_, ok := p.Purposes[purpose]
+	return ok
+}
+
+// Allows reports whether purpose justifies access to PII of category.
+func (p *AccessPolicy) Allows(purpose string, category PIICategory) bool {
+	return slices.Contains(p.Purposes[purpose].Categories, category)
+}
+
+// chain returns role followed by the roles it inherits from.
+func (p *AccessPolicy) chain(role string) []RolePolicy {
+	var chain []RolePolicy
+	for name := role; name != ""; {
+		r, ok := p.Roles[name]
+		// validate rejects cycles; the length check guards unvalidated policies
+		if !ok || len(chain) > len(p.Roles) {
+			break
+		}
+		chain = append(chain, r)
+		name = r.Inherits
+	}
+	return chain
+}
+
+var accessPolicy atomic.Pointer[AccessPolicy]
+
+func init() {
+	accessPolicy.Store(DefaultAccessPolicy())
+}
+
+// SetAccessPolicy replaces the policy used by SanitizeCustomerData, RedactPII
+// and the generated MaskPII methods. It is safe to call while requests are
+// being served.
+func SetAccessPolicy(policy *AccessPolicy) {
+	accessPolicy.Store(policy)
+}
+
+// CurrentAccessPolicy returns the policy in use.
+func CurrentAccessPolicy() *AccessPolicy {
+	return accessPolicy.Load()
+}
@@ -0,0 +1,220 @@
+package utils_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeropii/models"
+	"zeropii/utils"
+)
+
+// TestPolicyFileMatchesDefault checks that the shipped policy.yaml gives
+// every role the same view of a customer as the built-in default policy.
+func TestPolicyFileMatchesDefault(t *testing.T) {
+	policy, err := utils.LoadAccessPolicy("../policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer utils.SetAccessPolicy(utils.DefaultAccessPolicy())
+	for _, viewer := range testViewers {
+		want, got := loadSampleCustomer(t), loadSampleCustomer(t)
+		utils.SetAccessPolicy(utils.DefaultAccessPolicy())
+		utils.SanitizeCustomerData(want, viewer)
+		utils.SetAccessPolicy(policy)
+		utils.SanitizeCustomerData(got, viewer)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%+v: policy.yaml differs from the default policy", viewer)
+		}
+	}
+}
+
+func TestAccessPolicyAction(t *testing.T) {
+	policy, err := utils.ParseAccessPolicy([]byte(`
+default: redact
+roles:
+  support:
+    default: mask
+    categories:
+      phone: reveal
+    fields:
+      Pan.PanNumber: omit
+  lead:
+    inherits: support
+    categories:
+      email: reveal
+    fields:
+      Phone: redact
+purposes:
+  support:
+    categories: [email, phone, national_id]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryEmail}
+	phone := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryPhone}
+	pan := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryNationalID}
+	token := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryPassport, Enc: utils.EncToken}
+
+	tests := []struct {
+		role string
+		path string
+		tag  utils.PIIDescriptor
+		want utils.PolicyAction
+	}{
+		{"support", "Email", email, utils.ActionMask},
+		{"support", "Phone", phone, utils.ActionReveal},
+		{"support", "Pan.PanNumber", pan, utils.ActionOmit},
+		{"support", "Passport.PassportNumber", token, utils.ActionReveal},
+		{"lead", "Email", email, utils.ActionReveal},
+		{"lead", "Phone", phone, utils.ActionRedact},
+		{"lead", "Pan.PanNumber", pan, utils.ActionOmit},
+		{"lead", "Pan.PanDob", pan, utils.ActionMask},
+		{"unknown", "Email", email, utils.ActionRedact},
+	}
+	for _, tt := range tests {
+		viewer := utils.Viewer{Role: tt.role, Purpose: "support", Consented: true}
+		if got := policy.Action(viewer, tt.path, tt.tag); got != tt.want {
+			t.Errorf("Action(%+v, %q) = %s, want %s", viewer, tt.path, got, tt.want)
+		}
+	}
+}
+
+// A role's own rules, its default included, come before any rule of the
+// roles it inherits from.
+func TestAccessPolicyActionInheritance(t *testing.T) {
+	policy, err := utils.ParseAccessPolicy([]byte(`
+default: redact
+roles:
+  base:
+    categories:
+      dob: redact
+    fields:
+      Passport.PassportNumber: omit
+  child:
+    inherits: base
+    default: reveal
+  grandchild:
+    inherits: child
+    categories:
+      email: mask
+purposes:
+  kyc_review:
+    categories: [dob, email, name]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dob := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryDOB}
+	email := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryEmail}
+	token := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryPassport, Enc: utils.EncToken}
+
+	tests := []struct {
+		role string
+		path string
+		tag  utils.PIIDescriptor
+		want utils.PolicyAction
+	}{
+		{"base", "DOB", dob, utils.ActionRedact},
+		{"base", "Email", email, utils.ActionRedact},
+		{"child", "DOB", dob, utils.ActionReveal},
+		{"child", "Email", email, utils.ActionReveal},
+		{"grandchild", "DOB", dob, utils.ActionReveal},
+		{"grandchild", "Email", email, utils.ActionMask},
+		// Only field rules apply to tokens, wherever they are in the chain
+		{"grandchild", "Passport.PassportNumber", token, utils.ActionOmit},
+		{"grandchild", "Passport.Other", token, utils.ActionReveal},
+	}
+	for _, tt := range tests {
+		viewer := utils.Viewer{Role: tt.role, Purpose: "kyc_review", Consented: true}
+		if got := policy.Action(viewer, tt.path, tt.tag); got != tt.want {
+			t.Errorf("Action(%+v, %q) = %s, want %s", viewer, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAccessPolicyPurpose(t *testing.T) {
+	policy := utils.DefaultAccessPolicy()
+	email := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryEmail}
+	dob := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryDOB}
+	token := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryPassport, Enc: utils.EncToken}
+
+	tests := []struct {
+		viewer utils.Viewer
+		tag    utils.PIIDescriptor
+		want   utils.PolicyAction
+	}{
+		{utils.Viewer{Role: "admin", Purpose: "kyc_review", Consented: true}, dob, utils.ActionReveal},
+		{utils.Viewer{Role: "admin", Purpose: "support", Consented: true}, email, utils.ActionReveal},
+		{utils.Viewer{Role: "admin", Purpose: "support", Consented: true}, dob, utils.ActionRedact},
+		{utils.Viewer{Role: "viewer", Purpose: "support", Consented: true}, email, utils.ActionMask},
+		// No purpose is the most restrictive view
+		{utils.Viewer{Role: "admin", Consented: true}, email, utils.ActionOmit},
+		{utils.Viewer{Role: "viewer", Consented: true}, dob, utils.ActionOmit},
+		{utils.Viewer{Role: "admin", Purpose: "marketing", Consented: true}, email, utils.ActionRedact},
+		{utils.Viewer{Role: "admin"}, token, utils.ActionReveal},
+	}
+	for _, tt := range tests {
+		if got := policy.Action(tt.viewer, "", tt.tag); got != tt.want {
+			t.Errorf("Action(%+v, %s) = %s, want %s", tt.viewer, tt.tag.Category, got, tt.want)
+		}
+	}
+}
+
+func TestAccessPolicyConsent(t *testing.T) {
+	email := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryEmail}
+	token := utils.PIIDescriptor{Enabled: true, Category: utils.CategoryPassport, Enc: utils.EncToken}
+	admin := utils.Viewer{Role: "admin", Purpose: "kyc_review"}
+
+	tests := []struct {
+		without string
+		tag     utils.PIIDescriptor
+		want    utils.PolicyAction
+	}{
+		{"", email, utils.ActionMask},
+		{"reveal", email, utils.ActionReveal},
+		{"omit", email, utils.ActionOmit},
+		{"refuse", email, utils.ActionRedact},
+		{"omit", token, utils.ActionReveal},
+	}
+	for _, tt := range tests {
+		policy, err := utils.ParseAccessPolicy([]byte("roles: {admin: {default: reveal}}\npurposes: {kyc_review: {categories: [email]}}\nconsent: {without: " + tt.without + "}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := policy.Action(admin, "Email", tt.tag); got != tt.want {
+			t.Errorf("without consent (%q): Action = %s, want %s", tt.without, got, tt.want)
+		}
+		admin := admin
+		admin.Consented = true
+		if got := policy.Action(admin, "Email", tt.tag); got != utils.ActionReveal {
+			t.Errorf("with consent (%q): Action = %s, want reveal", tt.without, got)
+		}
+	}
+}
+
+func TestParseAccessPolicyRejectsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"action":   "default: hide",
+		"category": "roles: {viewer: {categories: {ssn: reveal}}}",
+		"unknown":  "roles: {viewer: {inherits: guest}}",
+		"itself":   "roles: {a: {inherits: b}, b: {inherits: a}}",
+		"purpose":  "purposes: {support: {categories: [ssn]}}",
+		"consent":  "consent: {without: hide}",
+	}
+	for want, policy := range tests {
+		_, err := utils.ParseAccessPolicy([]byte(policy))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseAccessPolicy(%q) = %v, want an error mentioning %q", policy, err, want)
+		}
+	}
+}
+
+func TestRedactPIIUsesPolicy(t *testing.T) {
+	customer := models.Customer{Email: "jane@example.com", Phone: "9876543210", DOB: "01-02-1990"}
+	utils.RedactPII(&customer, utils.Viewer{Role: "manager", Purpose: "support", Consented: true})
+	if customer.Email != "jane@example.com" || customer.Phone != "9876543210" || customer.DOB != "REDACTED" {
+		t.Errorf("RedactPII(manager) = %q, %q, %q", customer.Email, customer.Phone, customer.DOB)
+	}
+}
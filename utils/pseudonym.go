@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrNoPseudonymKey = errors.New("no pseudonymization key")
+
+// Pseudonymizer replaces PII with keyed pseudonyms for analytics exports. A
+// value always gets the same pseudonym under the same key, so exported tables
+// can still be joined on it, but the value cannot be recovered from it
+// without the key. Pseudonyms keep the type of the value: names become fake
+// names, emails addresses at example.invalid, PANs and Aadhaar numbers valid
+// looking numbers, dates of birth dates in the same year, and other values
+// keep their length, character classes and punctuation.
+//
+// Pseudonyms are chosen from a small space so they look real; distinct
+// values, names in particular, may share one.
+type Pseudonymizer struct {
+	key []byte
+}
+
+// NewPseudonymizer returns a pseudonymizer keyed with key, which must be at
+// least 32 bytes from a CSPRNG.
+func NewPseudonymizer(key []byte) (*Pseudonymizer, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("pseudonymization key must be at least 32 bytes")
+	}
+	if err := checkKeyStrength(key); err != nil {
+		return nil, fmt.Errorf("pseudonymization key: %w", err)
+	}
+	return &Pseudonymizer{key: append([]byte(nil), key...)}, nil
+}
+
+// NewPseudonymizerFromEnv returns a pseudonymizer keyed with PSEUDONYM_KEY,
+// 32 random bytes, hex or padded base64 encoded.
+func NewPseudonymizerFromEnv() (*Pseudonymizer, error) {
+	encoded := os.Getenv("PSEUDONYM_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("PSEUDONYM_KEY: %w", ErrNoPseudonymKey)
+	}
+	key, err := decodeKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("PSEUDONYM_KEY: %w", err)
+	}
+	p, err := NewPseudonymizer(key)
+	if err != nil {
+		return nil, fmt.Errorf("PSEUDONYM_KEY: %w", err)
+	}
+	return p, nil
+}
+
+// ForTenant returns a pseudonymizer with a key derived for tenant, so the
+// exports of different tenants cannot be joined with each other. The empty
+// tenant gets p itself.
+func (p *Pseudonymizer) ForTenant(tenant string) *Pseudonymizer {
+	if tenant == "" {
+		return p
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte("tenant\x00" + tenant))
+	return &Pseudonymizer{key: mac.Sum(nil)}
+}
+
+// Pseudonym returns the pseudonym of a value of category. The empty value is
+// its own pseudonym.
+func (p *Pseudonymizer) Pseudonym(category PIICategory, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return value
+	}
+	switch category {
+	case CategoryName:
+		return p.name(value)
+	case CategoryEmail:
+		return p.email(value)
+	case CategoryPhone:
+		return p.phone(value)
+	case CategoryDOB:
+		return p.date(value)
+	case CategoryNationalID:
+		return p.nationalID(value)
+	case CategoryAddress:
+		return p.address(value)
+	}
+	return p.shape(category, value)
+}
+
+// PseudonymizePII replaces every PII field of v with its pseudonym under p.
+func PseudonymizePII(v interface{}, p *Pseudonymizer) {
+	_ = walkPII(v, func(field piiField) error {
+		field.Value.SetString(p.Pseudonym(field.Tag.Category, field.Value.String()))
+		return nil
+	})
+}
+
+// pseudonymStream is the HMAC of a value expanded into a stream of bytes,
+// from which pseudonyms are built.
+type pseudonymStream struct {
+	mac     []byte
+	key     []byte
+	input   []byte
+	counter uint32
+	buf     []byte
+}
+
+// stream returns the byte stream of value, normalized for category so
+// equivalent inputs give the same pseudonym. The category is mixed in so
+// equal values of different categories get unrelated pseudonyms.
+func (p *Pseudonymizer) stream(category PIICategory, value string) *pseudonymStream {
+	input := []byte(string(category) + "\x00" + normalizePseudonymValue(category, value))
+	return &pseudonymStream{key: p.key, input: input}
+}
+
+// next returns the next byte of the stream.
+func (s *pseudonymStream) next() byte {
+	if len(s.buf) == 0 {
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write(s.input)
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], s.counter)
+		mac.Write(counter[:])
+		s.buf = mac.Sum(nil)
+		if s.counter == 0 {
+			s.mac = s.buf
+		}
+		s.counter++
+	}
+	b := s.buf[0]
+	s.buf = s.buf[1:]
+	return b
+}
+
+// intn returns an int in [0, n) taken from the next two bytes of the stream.
+func (s *pseudonymStream) intn(n int) int {
+	return int(uint16(s.next())<<8|uint16(s.next())) % n
+}
+
+// pick returns an element of choices taken from the stream.
+func (s *pseudonymStream) pick(choices []string) string {
+	return choices[s.intn(len(choices))]
+}
+
+// hex returns n hex digits identifying the value, taken from the first block of the stream.
+func (s *pseudonymStream) hex(n int) string {
+	if s.mac == nil {
+		s.next()
+	}
+	return hex.EncodeToString(s.mac)[:n]
+}
+
+// normalizePseudonymValue trims whitespace, lowercases emails and names,
+// strips phone number formatting and uppercases identifiers.
+func normalizePseudonymValue(category PIICategory, value string) string {
+	value = strings.TrimSpace(value)
+	switch category {
+	case CategoryEmail, CategoryName, CategoryAddress:
+		return strings.ToLower(value)
+	case CategoryPhone:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) || r == '+' {
+				return r
+			}
+			return -1
+		}, value)
+	case CategoryNationalID, CategoryPassport, CategoryDocument:
+		return strings.ToUpper(strings.Join(strings.Fields(value), ""))
+	}
+	return value
+}
+
+var (
+	givenNames = []string{
+		"Aarav", "Aditi", "Akash", "Ananya", "Arjun", "Asha", "Bhavna", "Chetan",
+		"Deepa", "Dev", "Divya", "Farhan", "Gauri", "Harish", "Isha", "Jatin",
+		"Kavya", "Kiran", "Lakshmi", "Manish", "Meera", "Neha", "Nikhil", "Pooja",
+		"Pranav", "Priya", "Rahul", "Riya", "Rohan", "Sanjay", "Shreya", "Sneha",
+		"Suresh", "Tanvi", "Uday", "Varun", "Vidya", "Vikram", "Yash", "Zoya",
+	}
+	familyNames = []string{
+		"Agarwal", "Bhat", "Chopra", "Das", "Desai", "Fernandes", "Ghosh", "Gupta",
+		"Iyer", "Jain", "Joshi", "Kapoor", "Khan", "Kulkarni", "Mehta", "Menon",
+		"Mishra", "Nair", "Pandey", "Patel", "Pillai", "Rao", "Reddy", "Saxena",
+		"Sen", "Shah", "Sharma", "Singh", "Sinha", "Thakur", "Varma", "Yadav",
+	}
+	placeNames = []string{
+		"Ashok", "Banyan", "Cedar", "Church", "Deccan", "Ganga", "Garden", "Hill",
+		"Jasmine", "Lake", "Lotus", "Mango", "Market", "Meadow", "Mill", "Neem",
+		"Orchid", "Palm", "Park", "River", "Sagar", "Station", "Temple", "Valley",
+	}
+)
+
+// name replaces each word with a given name, or a family name after the
+// first word. Words are pseudonymized one by one so a first name gets the
+// same pseudonym alone as within a full name.
+func (p *Pseudonymizer) name(value string) string {
+	words := strings.Fields(value)
+	for i, word := range words {
+		choices, role := familyNames, "family\x00"
+		if i == 0 {
+			choices, role = givenNames, "given\x00"
+		}
+		words[i] = p.stream(CategoryName, role+word).pick(choices)
+	}
+	return strings.Join(words, " ")
+}
+
+// email returns a made-up address at example.invalid, which can never be
+// delivered to.
+func (p *Pseudonymizer) email(value string) string {
+	s := p.stream(CategoryEmail, value)
+	return strings.ToLower(s.pick(givenNames)+"."+s.pick(familyNames)) + "." + s.hex(8) + "@example.invalid"
+}
+
+// phone replaces the digits of a phone number, keeping its formatting. The
+// first digit is never 0.
+func (p *Pseudonymizer) phone(value string) string {
+	s := p.stream(CategoryPhone, value)
+	first := true
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return r
+		}
+		if first {
+			first = false
+			return rune('1' + s.intn(9))
+		}
+		return rune('0' + s.intn(10))
+	}, value)
+}
+
+// date replaces a date with another day of the same year, in the same
+// format. Values that are not dates are pseudonymized by shape.
+func (p *Pseudonymizer) date(value string) string {
+	s := p.stream(CategoryDOB, value)
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		start := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		days := start.AddDate(1, 0, 0).Sub(start).Hours() / 24
+		return start.AddDate(0, 0, s.intn(int(days))).Format(layout)
+	}
+	return p.shape(CategoryDOB, value)
+}
+
+var (
+	panSyntax     = regexp.MustCompile(`^[A-Za-z]{5}[0-9]{4}[A-Za-z]$`)
+	aadhaarSyntax = regexp.MustCompile(`^[0-9]{4} ?[0-9]{4} ?[0-9]{4}$`)
+)
+
+// panHolderTypes are the valid fourth characters of a PAN, giving the type
+// of holder: P for a person, C for a company and so on.
+const panHolderTypes = "ABCFGHLJPT"
+
+// nationalID replaces a PAN with another valid-format PAN of the same holder
+// type and an Aadhaar number with another one with a valid Verhoeff check
+// digit, keeping its spacing. Other identifiers are pseudonymized by shape.
+func (p *Pseudonymizer) nationalID(value string) string {
+	s := p.stream(CategoryNationalID, value)
+	switch trimmed := strings.TrimSpace(value); {
+	case panSyntax.MatchString(trimmed):
+		holder := unicode.ToUpper(rune(trimmed[3]))
+		if !strings.ContainsRune(panHolderTypes, holder) {
+			holder = 'P'
+		}
+		pan := make([]byte, 0, 10)
+		for i := 0; i < 3; i++ {
+			pan = append(pan, byte('A'+s.intn(26)))
+		}
+		pan = append(pan, byte(holder), byte('A'+s.intn(26)))
+		for i := 0; i < 4; i++ {
+			pan = append(pan, byte('0'+s.intn(10)))
+		}
+		return string(append(pan, byte('A'+s.intn(26))))
+
+	case aadhaarSyntax.MatchString(trimmed):
+		// Aadhaar numbers never start with 0 or 1
+		digits := []byte{byte('2' + s.intn(8))}
+		for i := 0; i < 10; i++ {
+			digits = append(digits, byte('0'+s.intn(10)))
+		}
+		check, _ := VerhoeffCheckDigit(string(digits))
+		digits = append(digits, check)
+		if strings.Contains(trimmed, " ") {
+			return string(digits[:4]) + " " + string(digits[4:8]) + " " + string(digits[8:])
+		}
+		return string(digits)
+	}
+	return p.shape(CategoryNationalID, value)
+}
+
+// address replaces each word of letters with a place name, in the case of the
+// word, and pseudonymizes words with digits, such as house numbers and postal
+// codes, by shape.
+func (p *Pseudonymizer) address(value string) string {
+	words := strings.Fields(value)
+	for i, word := range words {
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			words[i] = p.shape(CategoryAddress, word)
+			continue
+		}
+		place := p.stream(CategoryAddress, word).pick(placeNames)
+		if strings.ToUpper(word) == word {
+			place = strings.ToUpper(place)
+		}
+		words[i] = place
+	}
+	return strings.Join(words, " ")
+}
+
+// shape replaces each digit with a digit and each letter with a letter of the
+// same case, keeping everything else.
+func (p *Pseudonymizer) shape(category PIICategory, value string) string {
+	s := p.stream(category, value)
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return rune('0' + s.intn(10))
+		case unicode.IsUpper(r):
+			return rune('A' + s.intn(26))
+		case unicode.IsLower(r):
+			return rune('a' + s.intn(26))
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,91 @@
+package utils_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"zeropii/utils"
+)
+
+func testPseudonymizer(t *testing.T) *utils.Pseudonymizer {
+	t.Helper()
+	p, err := utils.NewPseudonymizer(randomKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPseudonymFormats(t *testing.T) {
+	p := testPseudonymizer(t)
+	tests := []struct {
+		category utils.PIICategory
+		value    string
+		valid    func(pseudonym string) bool
+	}{
+		{utils.CategoryName, "Ethan Matthew Hunt", func(s string) bool { return len(strings.Fields(s)) == 3 }},
+		{utils.CategoryEmail, "ethan.hunt@example.com", regexp.MustCompile(`^[a-z]+\.[a-z]+\.[0-9a-f]{8}@example\.invalid$`).MatchString},
+		{utils.CategoryPhone, "+91 98765-43210", regexp.MustCompile(`^\+[1-9]\d \d{5}-\d{5}$`).MatchString},
+		{utils.CategoryDOB, "23/07/1987", func(s string) bool {
+			d, err := time.Parse("02/01/2006", s)
+			return err == nil && d.Year() == 1987
+		}},
+		{utils.CategoryDOB, "1987-07-23", regexp.MustCompile(`^1987-\d\d-\d\d$`).MatchString},
+		{utils.CategoryNationalID, "ABCPE1234F", regexp.MustCompile(`^[A-Z]{3}P[A-Z]\d{4}[A-Z]$`).MatchString},
+		{utils.CategoryNationalID, "ABCDE1234F", regexp.MustCompile(`^[A-Z]{3}P[A-Z]\d{4}[A-Z]$`).MatchString},
+		{utils.CategoryNationalID, "AAACZ1234F", regexp.MustCompile(`^[A-Z]{3}C[A-Z]\d{4}[A-Z]$`).MatchString},
+		{utils.CategoryNationalID, "2345 6789 0123", func(s string) bool {
+			return regexp.MustCompile(`^[2-9]\d{3} \d{4} \d{4}$`).MatchString(s) && utils.VerhoeffValid(strings.ReplaceAll(s, " ", ""))
+		}},
+		{utils.CategoryAddress, "221B Baker Street", regexp.MustCompile(`^\d{3}[A-Z] [A-Z][a-z]+ [A-Z][a-z]+$`).MatchString},
+		{utils.CategoryPassport, "N1234567", regexp.MustCompile(`^[A-Z]\d{7}$`).MatchString},
+		{utils.CategoryOther, "ab-12", regexp.MustCompile(`^[a-z]{2}-\d{2}$`).MatchString},
+	}
+	for _, tt := range tests {
+		pseudonym := p.Pseudonym(tt.category, tt.value)
+		if pseudonym == tt.value || !tt.valid(pseudonym) {
+			t.Errorf("Pseudonym(%s, %q) = %q", tt.category, tt.value, pseudonym)
+		}
+	}
+}
+
+func TestPseudonymStable(t *testing.T) {
+	p := testPseudonymizer(t)
+	tenant := p.ForTenant("partner-1")
+	for _, category := range []utils.PIICategory{utils.CategoryEmail, utils.CategoryPhone, utils.CategoryOther} {
+		if p.Pseudonym(category, "+91 98765 43210") != p.Pseudonym(category, "+91 98765 43210") {
+			t.Errorf("%s pseudonym is not stable", category)
+		}
+		if tenant.Pseudonym(category, "+91 98765 43210") != p.ForTenant("partner-1").Pseudonym(category, "+91 98765 43210") {
+			t.Errorf("%s pseudonym is not stable per tenant", category)
+		}
+	}
+
+	// Equivalent inputs normalize to the same pseudonym
+	if p.Pseudonym(utils.CategoryEmail, "Ethan.Hunt@Example.com ") != p.Pseudonym(utils.CategoryEmail, "ethan.hunt@example.com") {
+		t.Error("email pseudonym depends on case")
+	}
+	// A given name keeps its pseudonym within a full name
+	if first := strings.Fields(p.Pseudonym(utils.CategoryName, "Ethan Hunt"))[0]; first != p.Pseudonym(utils.CategoryName, "Ethan") {
+		t.Errorf("given name pseudonym %q differs within a full name", first)
+	}
+	// Values with the same pseudonym under one key differ under another
+	for _, other := range []*utils.Pseudonymizer{testPseudonymizer(t), tenant, p.ForTenant("partner-2")} {
+		if other.Pseudonym(utils.CategoryEmail, "ethan.hunt@example.com") == p.Pseudonym(utils.CategoryEmail, "ethan.hunt@example.com") {
+			t.Error("pseudonyms under different keys are equal")
+		}
+	}
+	if p.ForTenant("") != p {
+		t.Error("ForTenant(\"\") derived a key")
+	}
+}
+
+func TestNewPseudonymizerRejectsWeakKeys(t *testing.T) {
+	for _, key := range []string{"short", strings.Repeat("ab", 16)} {
+		if _, err := utils.NewPseudonymizer([]byte(key)); err == nil {
+			t.Errorf("NewPseudonymizer(%q) accepted a weak key", key)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ConsentReceiptVersion is the Kantara Initiative Consent Receipt
+// Specification version receipts follow.
+const ConsentReceiptVersion = "KI-CR-v1.1.0"
+
+// Consent statuses recorded in ConsentReceipt.ConsentStatus.
+const (
+	ConsentGranted   = "granted"
+	ConsentWithdrawn = "withdrawn"
+)
+
+// ErrInvalidReceipt is returned for a consent receipt that is malformed or
+// whose signature does not verify.
+var ErrInvalidReceipt = errors.New("invalid consent receipt")
+
+// ConsentReceipt is a record of a consent change in the shape of the Kantara
+// Initiative Consent Receipt Specification v1.1. ConsentStatus and
+// PreviousReceiptID extend it so that the receipts of a customer and
+// application chain into a verifiable history.
+type ConsentReceipt struct {
+	Version           string              `json:"version"`
+	Jurisdiction      string              `json:"jurisdiction"`
+	ConsentTimestamp  int64               `json:"consentTimestamp"`
+	CollectionMethod  string              `json:"collectionMethod"`
+	ConsentReceiptID  string              `json:"consentReceiptID"`
+	PublicKey         string              `json:"publicKey"`
+	Language          string              `json:"language"`
+	PIIPrincipalID    string              `json:"piiPrincipalId"`
+	PIIControllers    []ReceiptController `json:"piiControllers"`
+	PolicyURL         string              `json:"policyUrl"`
+	Services          []ReceiptService    `json:"services"`
+	Sensitive         bool                `json:"sensitive"`
+	SPICat            []PIICategory       `json:"spiCat"`
+	ConsentStatus     string              `json:"consentStatus"`
+	PreviousReceiptID string              `json:"previousReceiptID,omitempty"`
+}
+
+// ReceiptController is the organisation responsible for the PII.
+type ReceiptController struct {
+	PIIController    string         `json:"piiController"`
+	OnBehalf         bool           `json:"onBehalf"`
+	Contact          string         `json:"contact"`
+	Address          ReceiptAddress `json:"address"`
+	Email            string         `json:"email"`
+	Phone            string         `json:"phone"`
+	PIIControllerURL string         `json:"piiControllerUrl,omitempty"`
+}
+
+// ReceiptAddress is the postal address of a ReceiptController.
+type ReceiptAddress struct {
+	StreetAddress  string `json:"streetAddress"`
+	AddressCountry string `json:"addressCountry"`
+}
+
+// ReceiptService is an application consent was given to or withdrawn from.
+type ReceiptService struct {
+	Service  string           `json:"service"`
+	Purposes []ReceiptPurpose `json:"purposes"`
+}
+
+// ReceiptPurpose is one purpose a service processes PII for.
+type ReceiptPurpose struct {
+	Purpose              string        `json:"purpose"`
+	PurposeCategory      []string      `json:"purposeCategory"`
+	ConsentType          string        `json:"consentType"`
+	PIICategory          []PIICategory `json:"piiCategory"`
+	PrimaryPurpose       bool          `json:"primaryPurpose"`
+	Termination          string        `json:"termination"`
+	ThirdPartyDisclosure bool          `json:"thirdPartyDisclosure"`
+}
+
+// ReceiptIssuer completes consent receipts with the details of the PII
+// controller and signs them with an Ed25519 key.
+type ReceiptIssuer struct {
+	Controller   ReceiptController
+	Jurisdiction string
+	PolicyURL    string
+	Language     string
+
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// receiptKeyFile is the on-disk format of a receipt signing key file.
+type receiptKeyFile struct {
+	Seed string `json:"seed"` // base64 encoded 32-byte Ed25519 seed
+}
+
+// NewReceiptIssuer returns an issuer signing with key.
+func NewReceiptIssuer(key ed25519.PrivateKey, controller ReceiptController, jurisdiction, policyURL string) *ReceiptIssuer {
+	public := key.Public().(ed25519.PublicKey)
+	return &ReceiptIssuer{
+		Controller:   controller,
+		Jurisdiction: jurisdiction,
+		PolicyURL:    policyURL,
+		Language:     "en",
+		key:          key,
+		keyID:        "ed25519-" + KeyID(string(public)),
+	}
+}
+
+// NewReceiptIssuerFromEnv loads the signing key from RECEIPT_SIGNING_KEY, a
+// base64 encoded 32-byte seed, or else from the JSON key file at
+// RECEIPT_KEY_FILE (default receipt_key.json), which is created on first use.
+// The controller comes from CONSENT_CONTROLLER, CONSENT_CONTROLLER_CONTACT,
+// CONSENT_CONTROLLER_EMAIL, CONSENT_CONTROLLER_PHONE,
+// CONSENT_CONTROLLER_ADDRESS and CONSENT_CONTROLLER_URL, and receipts name
+// CONSENT_JURISDICTION (default IN) and CONSENT_POLICY_URL.
+func NewReceiptIssuerFromEnv() (*ReceiptIssuer, error) {
+	seed, err := loadReceiptSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	jurisdiction := os.Getenv("CONSENT_JURISDICTION")
+	if jurisdiction == "" {
+		jurisdiction = "IN"
+	}
+	controller := ReceiptController{
+		PIIController: os.Getenv("CONSENT_CONTROLLER"),
+		Contact:       os.Getenv("CONSENT_CONTROLLER_CONTACT"),
+		Address: ReceiptAddress{
+			StreetAddress:  os.Getenv("CONSENT_CONTROLLER_ADDRESS"),
+			AddressCountry: jurisdiction,
+		},
+		Email:            os.Getenv("CONSENT_CONTROLLER_EMAIL"),
+		Phone:            os.Getenv("CONSENT_CONTROLLER_PHONE"),
+		PIIControllerURL: os.Getenv("CONSENT_CONTROLLER_URL"),
+	}
+	if controller.PIIController == "" {
+		return nil, fmt.Errorf("CONSENT_CONTROLLER is not set")
+	}
+	return NewReceiptIssuer(ed25519.NewKeyFromSeed(seed), controller, jurisdiction, os.Getenv("CONSENT_POLICY_URL")), nil
+}
+
+func loadReceiptSeed() ([]byte, error) {
+	if encoded := os.Getenv("RECEIPT_SIGNING_KEY"); encoded != "" {
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("RECEIPT_SIGNING_KEY must be a base64 encoded %d-byte seed", ed25519.SeedSize)
+		}
+		return seed, nil
+	}
+
+	path := os.Getenv("RECEIPT_KEY_FILE")
+	if path == "" {
+		path = "receipt_key.json"
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = createReceiptKeyFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file receiptKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse key file %s: %w", path, err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(file.Seed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key file %s: seed must be a base64 encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return seed, nil
+}
+
+func createReceiptKeyFile(path string) ([]byte, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(receiptKeyFile{Seed: base64.StdEncoding.EncodeToString(seed)}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("create key file %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// KeyID identifies the signing key in the kid header of signed receipts.
+func (i *ReceiptIssuer) KeyID() string {
+	return i.keyID
+}
+
+// PublicKey returns the key receipts are verified with.
+func (i *ReceiptIssuer) PublicKey() ed25519.PublicKey {
+	return i.key.Public().(ed25519.PublicKey)
+}
+
+// JWK returns the public key as a JSON Web Key, for verifiers outside Go.
+func (i *ReceiptIssuer) JWK() map[string]string {
+	return map[string]string{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"alg": "EdDSA",
+		"use": "sig",
+		"kid": i.keyID,
+		"x":   base64.RawURLEncoding.EncodeToString(i.PublicKey()),
+	}
+}
+
+// Issue fills in the controller, jurisdiction, language, policy, public key
+// and, if unset, a new receipt ID, and returns the receipt signed as a
+// compact JWS with the EdDSA algorithm.
+func (i *ReceiptIssuer) Issue(receipt *ConsentReceipt) (string, error) {
+	receipt.Version = ConsentReceiptVersion
+	receipt.Jurisdiction = i.Jurisdiction
+	receipt.Language = i.Language
+	receipt.PolicyURL = i.PolicyURL
+	receipt.PIIControllers = []ReceiptController{i.Controller}
+	receipt.PublicKey = base64.StdEncoding.EncodeToString(i.PublicKey())
+	if receipt.ConsentReceiptID == "" {
+		receipt.ConsentReceiptID = uuid.New().String()
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": i.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(i.key, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyConsentReceipt checks the signature of a receipt returned by Issue
+// against publicKey and returns the receipt.
+func VerifyConsentReceipt(token string, publicKey ed25519.PublicKey) (*ConsentReceipt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidReceipt
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidReceipt
+	}
+	var fields struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &fields); err != nil || fields.Alg != "EdDSA" {
+		return nil, ErrInvalidReceipt
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidReceipt
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidReceipt
+	}
+	var receipt ConsentReceipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		return nil, ErrInvalidReceipt
+	}
+	return &receipt, nil
+}
@@ -0,0 +1,130 @@
+package utils_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeropii/models"
+	"zeropii/utils"
+)
+
+func testReceiptIssuer(t *testing.T) *utils.ReceiptIssuer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utils.NewReceiptIssuer(key, utils.ReceiptController{PIIController: "Zero PII"}, "IN", "https://example.com/privacy")
+}
+
+func TestConsentReceiptRoundTrip(t *testing.T) {
+	issuer := testReceiptIssuer(t)
+	receipt := &utils.ConsentReceipt{
+		ConsentTimestamp: 1760000000,
+		CollectionMethod: "api",
+		PIIPrincipalID:   "customer-1",
+		Services: []utils.ReceiptService{{
+			Service:  "lending-app",
+			Purposes: []utils.ReceiptPurpose{{Purpose: "kyc_review", ConsentType: "EXPLICIT", PIICategory: []utils.PIICategory{utils.CategoryEmail}}},
+		}},
+		ConsentStatus: utils.ConsentGranted,
+	}
+	signed, err := issuer.Issue(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Version != utils.ConsentReceiptVersion || receipt.ConsentReceiptID == "" || receipt.PIIControllers[0].PIIController != "Zero PII" {
+		t.Fatalf("Issue did not complete the receipt: %+v", receipt)
+	}
+
+	verified, err := utils.VerifyConsentReceipt(signed, issuer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(verified, receipt) {
+		t.Fatalf("VerifyConsentReceipt gave\n%+v\nwant\n%+v", verified, receipt)
+	}
+
+	// The public key in the JWK verifies the receipt too
+	x, err := base64.RawURLEncoding.DecodeString(issuer.JWK()["x"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.VerifyConsentReceipt(signed, ed25519.PublicKey(x)); err != nil {
+		t.Fatalf("JWK key: %v", err)
+	}
+}
+
+func TestConsentReceiptRejectsTampering(t *testing.T) {
+	issuer := testReceiptIssuer(t)
+	signed, err := issuer.Issue(&utils.ConsentReceipt{PIIPrincipalID: "customer-1", ConsentStatus: utils.ConsentGranted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(signed, ".")
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["consentStatus"] = utils.ConsentWithdrawn
+	payload, _ = json.Marshal(fields)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	for name, token := range map[string]string{
+		"tampered":  tampered,
+		"truncated": parts[0] + "." + parts[1],
+		"garbage":   "not a receipt",
+	} {
+		if _, err := utils.VerifyConsentReceipt(token, issuer.PublicKey()); !errors.Is(err, utils.ErrInvalidReceipt) {
+			t.Errorf("%s: err = %v, want ErrInvalidReceipt", name, err)
+		}
+	}
+	if _, err := utils.VerifyConsentReceipt(signed, testReceiptIssuer(t).PublicKey()); !errors.Is(err, utils.ErrInvalidReceipt) {
+		t.Errorf("other key: err = %v, want ErrInvalidReceipt", err)
+	}
+}
+
+func TestReceiptIssuerFromEnvKeepsKeyFile(t *testing.T) {
+	t.Setenv("RECEIPT_SIGNING_KEY", "")
+	t.Setenv("RECEIPT_KEY_FILE", filepath.Join(t.TempDir(), "receipt_key.json"))
+	t.Setenv("CONSENT_CONTROLLER", "Zero PII")
+
+	first, err := utils.NewReceiptIssuerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := utils.NewReceiptIssuerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.PublicKey().Equal(second.PublicKey()) || first.KeyID() != second.KeyID() {
+		t.Fatal("the key file was not reused")
+	}
+}
+
+func TestPIICategoriesOf(t *testing.T) {
+	all := utils.PIICategoriesOf(models.Customer{}, utils.SensitivityLow)
+	want := []utils.PIICategory{
+		utils.CategoryEmail, utils.CategoryPhone, utils.CategoryDOB, utils.CategoryAddress,
+		utils.CategoryNationalID, utils.CategoryPassport, utils.CategoryDocument,
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("PIICategoriesOf(low) = %v, want %v", all, want)
+	}
+	high := utils.PIICategoriesOf(models.Customer{}, utils.SensitivityHigh)
+	want = []utils.PIICategory{
+		utils.CategoryDOB, utils.CategoryAddress, utils.CategoryNationalID, utils.CategoryPassport, utils.CategoryDocument,
+	}
+	if !reflect.DeepEqual(high, want) {
+		t.Errorf("PIICategoriesOf(high) = %v, want %v", high, want)
+	}
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrKeyShredded         = errors.New("customer key has been shredded")
+	ErrCustomerKeyNotFound = errors.New("customer key not found")
+)
+
+// CustomerKeyStore persists one wrapped key per customer. Deleting a key must
+// leave a tombstone so the customer cannot silently get a new key afterwards.
+type CustomerKeyStore interface {
+	// GetCustomerKey returns ErrCustomerKeyNotFound if no key was ever created
+	// and ErrKeyShredded if it was deleted.
+	GetCustomerKey(ctx context.Context, customerID string) (*WrappedKey, error)
+	// PutCustomerKey stores a new key. It fails if the customer already has a
+	// key or a tombstone.
+	PutCustomerKey(ctx context.Context, customerID string, key *WrappedKey) error
+	// UpdateCustomerKey replaces the stored wrapping of an existing key.
+	UpdateCustomerKey(ctx context.Context, customerID string, key *WrappedKey) error
+	// DeleteCustomerKey destroys the key and leaves a tombstone.
+	DeleteCustomerKey(ctx context.Context, customerID string) error
+}
+
+// CustomerKeyManager gives every customer their own key, wrapped by the KEK
+// provider and kept in a CustomerKeyStore outside the customer document.
+// Record data keys are wrapped with the customer key, so deleting that one
+// key makes every copy of the customer's PII undecryptable, including copies
+// in backups of the customer collection.
+type CustomerKeyManager struct {
+	store CustomerKeyStore
+	kek   KeyProvider
+}
+
+// NewCustomerKeyManager creates a manager storing customer keys in store,
+// wrapped by kek.
+func NewCustomerKeyManager(store CustomerKeyStore, kek KeyProvider) *CustomerKeyManager {
+	return &CustomerKeyManager{store: store, kek: kek}
+}
+
+// CustomerKeyID is the key ID recorded in data keys wrapped by a customer key.
+func CustomerKeyID(customerID string) string {
+	return "customer:" + customerID
+}
+
+// ProviderFor returns a KeyProvider that wraps data keys with the customer's
+// key, creating the key on first use. It returns ErrKeyShredded for
+// customers whose key has been destroyed.
+func (m *CustomerKeyManager) ProviderFor(ctx context.Context, customerID string) (KeyProvider, error) {
+	return m.providerFor(ctx, customerID, true)
+}
+
+// ExistingProviderFor is ProviderFor for customers that already have a key.
+// It returns ErrCustomerKeyNotFound rather than creating one, so callers that
+// take a customer ID from a request cannot mint keys for made-up customers.
+func (m *CustomerKeyManager) ExistingProviderFor(ctx context.Context, customerID string) (KeyProvider, error) {
+	return m.providerFor(ctx, customerID, false)
+}
+
+func (m *CustomerKeyManager) providerFor(ctx context.Context, customerID string, create bool) (KeyProvider, error) {
+	if customerID == "" {
+		return nil, fmt.Errorf("customer ID is required")
+	}
+
+	wrapped, err := m.store.GetCustomerKey(ctx, customerID)
+	if create && errors.Is(err, ErrCustomerKeyNotFound) {
+		wrapped, err = m.createKey(ctx, customerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := m.kek.UnwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap customer key: %w", err)
+	}
+	defer clear(key)
+
+	kr := NewKeyring()
+	if err := kr.Rotate(CustomerKeyID(customerID), key); err != nil {
+		return nil, err
+	}
+	return &customerKeyProvider{keyring: kr, fallback: m.kek}, nil
+}
+
+func (m *CustomerKeyManager) createKey(ctx context.Context, customerID string) (*WrappedKey, error) {
+	key, wrapped, err := m.kek.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("generate customer key: %w", err)
+	}
+	clear(key)
+
+	if err := m.store.PutCustomerKey(ctx, customerID, wrapped); err != nil {
+		// Lost a race with another request creating the same key
+		if existing, getErr := m.store.GetCustomerKey(ctx, customerID); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// Rewrap re-wraps the stored customer key with the KEK provider's current
+// key. It reports whether the stored wrapping changed.
+func (m *CustomerKeyManager) Rewrap(ctx context.Context, customerID string) (bool, error) {
+	wrapped, err := m.store.GetCustomerKey(ctx, customerID)
+	if err != nil {
+		return false, err
+	}
+	rewrapped, err := m.kek.RewrapDataKey(ctx, wrapped)
+	if err != nil {
+		return false, err
+	}
+	if rewrapped.KeyID == wrapped.KeyID {
+		return false, nil
+	}
+	return true, m.store.UpdateCustomerKey(ctx, customerID, rewrapped)
+}
+
+// ActiveKEKID returns the ID of the KEK new customer keys are wrapped with,
+// so callers can tell when the KEK has been rotated.
+func (m *CustomerKeyManager) ActiveKEKID(ctx context.Context) (string, error) {
+	key, wrapped, err := m.kek.GenerateDataKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("generate probe key: %w", err)
+	}
+	clear(key)
+	return wrapped.KeyID, nil
+}
+
+// Shred destroys the customer's key and confirms it can no longer be loaded.
+func (m *CustomerKeyManager) Shred(ctx context.Context, customerID string) error {
+	if err := m.store.DeleteCustomerKey(ctx, customerID); err != nil {
+		return err
+	}
+	if _, err := m.store.GetCustomerKey(ctx, customerID); !errors.Is(err, ErrKeyShredded) {
+		return fmt.Errorf("customer key still readable after shred: %v", err)
+	}
+	return nil
+}
+
+// customerKeyProvider wraps data keys with a single customer's key. Data keys
+// wrapped directly by the KEK, before the customer had a key of their own,
+// are unwrapped through the fallback provider.
+type customerKeyProvider struct {
+	keyring  *Keyring
+	fallback KeyProvider
+}
+
+func (p *customerKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, *WrappedKey, error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := p.WrapDataKey(ctx, dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, wrapped, nil
+}
+
+func (p *customerKeyProvider) WrapDataKey(ctx context.Context, key []byte) (*WrappedKey, error) {
+	ciphertext, err := p.keyring.Encrypt(string(key))
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{KeyID: p.keyring.ActiveKeyID(), Ciphertext: ciphertext}, nil
+}
+
+func (p *customerKeyProvider) UnwrapDataKey(ctx context.Context, wrapped *WrappedKey) ([]byte, error) {
+	if wrapped == nil {
+		return nil, ErrMalformedEnvelope
+	}
+	if wrapped.KeyID != p.keyring.ActiveKeyID() {
+		return p.fallback.UnwrapDataKey(ctx, wrapped)
+	}
+	dek, err := p.keyring.Decrypt(wrapped.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return []byte(dek), nil
+}
+
+// RewrapDataKey moves a data key onto the customer key, including data keys
+// that were wrapped by the KEK directly.
+func (p *customerKeyProvider) RewrapDataKey(ctx context.Context, wrapped *WrappedKey) (*WrappedKey, error) {
+	dek, err := p.UnwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+	return p.WrapDataKey(ctx, dek)
+}
+
+// ReadablePII returns the paths of the PII fields of data that can be read
+// without the record's data key, by decrypting each one with keyring alone
+// and, if tokens is not nil, detokenizing each token through the vault.
+// Crypto-shredding does not erase these fields: deterministic values are
+// encrypted with the shared keyring so they stay comparable across records,
+// and values never moved onto a data key are encrypted with keyring keys.
+// Tokens are readable until their owner's key is destroyed, so pass a nil
+// vault to check a record before shredding it and the vault to verify it
+// after. Format-preserving values need the data key by construction and are
+// never listed.
+func ReadablePII(ctx context.Context, data interface{}, keyring *Keyring, tokens *TokenVault) []string {
+	id := recordIDOf(data)
+	var readable []string
+	_ = walkPII(data, func(field piiField) error {
+		value := field.Value.String()
+		switch {
+		case value == "":
+			return nil
+		case field.Tag.Tokenized() && !IsEnvelope(value):
+			if tokens == nil {
+				return nil
+			}
+			if _, _, err := tokens.Detokenize(ctx, value); err == nil {
+				readable = append(readable, field.Path)
+			}
+			return nil
+		case field.Tag.Enc == EncFPE && !IsEnvelope(value):
+			return nil
+		}
+		binding := fieldBinding{recordID: id, path: field.Path}
+		if _, err := decryptValue(keyring, value, binding, field.Name, field.Tag); err == nil {
+			readable = append(readable, field.Path)
+		}
+		return nil
+	})
+	return readable
+}
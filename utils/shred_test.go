@@ -0,0 +1,288 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"zeropii/utils"
+	"zeropii/utils/internal/piifixture"
+)
+
+// memoryKeyStore is an in-memory CustomerKeyStore; a nil entry is a tombstone.
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*utils.WrappedKey
+}
+
+func (s *memoryKeyStore) GetCustomerKey(ctx context.Context, customerID string) (*utils.WrappedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[customerID]
+	switch {
+	case !ok:
+		return nil, utils.ErrCustomerKeyNotFound
+	case key == nil:
+		return nil, utils.ErrKeyShredded
+	}
+	return key, nil
+}
+
+func (s *memoryKeyStore) PutCustomerKey(ctx context.Context, customerID string, key *utils.WrappedKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[customerID]; ok {
+		return errors.New("customer key exists")
+	}
+	s.keys[customerID] = key
+	return nil
+}
+
+func (s *memoryKeyStore) UpdateCustomerKey(ctx context.Context, customerID string, key *utils.WrappedKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[customerID] == nil {
+		return utils.ErrCustomerKeyNotFound
+	}
+	s.keys[customerID] = key
+	return nil
+}
+
+func (s *memoryKeyStore) DeleteCustomerKey(ctx context.Context, customerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[customerID] = nil
+	return nil
+}
+
+// memoryTokenStore is an in-memory TokenStore.
+type memoryTokenStore struct {
+	mu      sync.Mutex
+	entries map[string]*utils.TokenEntry
+}
+
+func (s *memoryTokenStore) GetToken(ctx context.Context, token string) (*utils.TokenEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[token]; ok {
+		return entry, nil
+	}
+	return nil, utils.ErrTokenNotFound
+}
+
+func (s *memoryTokenStore) FindTokenByFingerprint(ctx context.Context, fingerprint string) (*utils.TokenEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.Fingerprint == fingerprint {
+			return entry, nil
+		}
+	}
+	return nil, utils.ErrTokenNotFound
+}
+
+func (s *memoryTokenStore) PutToken(ctx context.Context, entry *utils.TokenEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[entry.Token]; ok {
+		return utils.ErrTokenExists
+	}
+	copied := *entry
+	s.entries[entry.Token] = &copied
+	return nil
+}
+
+func (s *memoryTokenStore) ForgetFingerprints(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.Owner == owner {
+			entry.Fingerprint = "forgotten:" + entry.Token
+		}
+	}
+	return nil
+}
+
+func (s *memoryTokenStore) DeleteTokens(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, entry := range s.entries {
+		if entry.Owner == owner {
+			delete(s.entries, token)
+		}
+	}
+	return nil
+}
+
+func newCustomerKeys(t *testing.T) (*utils.CustomerKeyManager, *memoryKeyStore, utils.KeyProvider) {
+	t.Helper()
+	kek, err := utils.NewLocalKeyProvider(filepath.Join(t.TempDir(), "kek.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryKeyStore{keys: map[string]*utils.WrappedKey{}}
+	return utils.NewCustomerKeyManager(store, kek), store, kek
+}
+
+func TestCustomerKeyManager(t *testing.T) {
+	ctx := context.Background()
+	keys, store, kek := newCustomerKeys(t)
+
+	if _, err := keys.ProviderFor(ctx, ""); err == nil {
+		t.Error("ProviderFor accepted an empty customer ID")
+	}
+	provider, err := keys.ProviderFor(ctx, "cust-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := store.keys["cust-1"]
+	again, err := keys.ProviderFor(ctx, "cust-1")
+	if err != nil || store.keys["cust-1"] != stored {
+		t.Fatalf("second ProviderFor replaced the customer key: %v", err)
+	}
+
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.KeyID != utils.CustomerKeyID("cust-1") {
+		t.Errorf("data key wrapped by %q, want the customer key", wrapped.KeyID)
+	}
+	if unwrapped, err := again.UnwrapDataKey(ctx, wrapped); err != nil || string(unwrapped) != string(dek) {
+		t.Fatalf("UnwrapDataKey = %x, %v", unwrapped, err)
+	}
+
+	// Data keys wrapped by the KEK before the customer had a key are moved
+	// onto the customer key
+	legacyDEK, legacy, err := kek.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := provider.RewrapDataKey(ctx, legacy)
+	if err != nil || moved.KeyID != utils.CustomerKeyID("cust-1") {
+		t.Fatalf("RewrapDataKey(KEK-wrapped) = %+v, %v", moved, err)
+	}
+	if unwrapped, err := provider.UnwrapDataKey(ctx, moved); err != nil || string(unwrapped) != string(legacyDEK) {
+		t.Fatalf("UnwrapDataKey(moved) = %x, %v", unwrapped, err)
+	}
+
+	if err := keys.Shred(ctx, "cust-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.ProviderFor(ctx, "cust-1"); !errors.Is(err, utils.ErrKeyShredded) {
+		t.Errorf("ProviderFor after Shred = %v, want ErrKeyShredded", err)
+	}
+	if _, err := keys.Rewrap(ctx, "cust-1"); !errors.Is(err, utils.ErrKeyShredded) {
+		t.Errorf("Rewrap after Shred = %v, want ErrKeyShredded", err)
+	}
+}
+
+func TestReadablePII(t *testing.T) {
+	ctx := context.Background()
+	keys, _, kek := newCustomerKeys(t)
+	keyring := baseKeyring(t)
+	vault := utils.NewTokenVault(&memoryTokenStore{entries: map[string]*utils.TokenEntry{}}, keyring, kek, keys)
+
+	provider, err := keys.ProviderFor(ctx, "rec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := newRecord(t).(*piifixture.Record)
+	tokenizing := keyring.WithTokenizer(vault.ForOwner(ctx, "rec-1"))
+	if _, err := utils.EncryptRecordPII(ctx, record, tokenizing, provider); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deterministic fields use the shared keyring, so shredding cannot erase
+	// them; the token is readable until the customer key is destroyed
+	if got, want := utils.ReadablePII(ctx, record, keyring, nil), []string{"Aliases", "Aliases"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadablePII before shredding = %v, want %v", got, want)
+	}
+	if got := utils.ReadablePII(ctx, record, keyring, vault); len(got) != 3 || got[2] != "Passport" {
+		t.Errorf("ReadablePII with the vault = %v, want the aliases and the passport token", got)
+	}
+
+	record.Aliases = nil
+	if err := keys.Shred(ctx, "rec-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := utils.ReadablePII(ctx, record, keyring, vault); len(got) != 0 {
+		t.Errorf("ReadablePII after shredding = %v, want nothing", got)
+	}
+}
+
+func TestTokenVaultForgetOwner(t *testing.T) {
+	ctx := context.Background()
+	keys, _, kek := newCustomerKeys(t)
+	vault := utils.NewTokenVault(&memoryTokenStore{entries: map[string]*utils.TokenEntry{}}, baseKeyring(t), kek, keys)
+	if _, err := keys.ProviderFor(ctx, "cust-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := vault.Tokenize(ctx, "cust-1", "Passport", "N1111111", utils.TokenFormatRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := vault.Tokenize(ctx, "cust-1", "Passport", "N1111111", utils.TokenFormatRandom); err != nil || again != token {
+		t.Fatalf("Tokenize is not stable: %q, %v", again, err)
+	}
+	if err := vault.ForgetOwner(ctx, "cust-1"); err != nil {
+		t.Fatal(err)
+	}
+	// A forgotten value no longer leads back to its token
+	if again, err := vault.Tokenize(ctx, "cust-1", "Passport", "N1111111", utils.TokenFormatRandom); err != nil || again == token {
+		t.Errorf("Tokenize after ForgetOwner = %q, %v, want a new token", again, err)
+	}
+}
+
+func TestTokenVaultDeleteOwner(t *testing.T) {
+	ctx := context.Background()
+	keys, _, kek := newCustomerKeys(t)
+	vault := utils.NewTokenVault(&memoryTokenStore{entries: map[string]*utils.TokenEntry{}}, baseKeyring(t), kek, keys)
+	for _, owner := range []string{"cust-1", "cust-2"} {
+		if _, err := keys.ProviderFor(ctx, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := vault.Tokenize(ctx, "cust-1", "Passport", "N1111111", utils.TokenFormatRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := vault.Tokenize(ctx, "cust-2", "Passport", "N1111111", utils.TokenFormatRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.DeleteOwner(ctx, "cust-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := vault.Detokenize(ctx, deleted); !errors.Is(err, utils.ErrTokenNotFound) {
+		t.Errorf("Detokenize after DeleteOwner = %v, want ErrTokenNotFound", err)
+	}
+	if _, value, err := vault.Detokenize(ctx, kept); err != nil || value != "N1111111" {
+		t.Errorf("Detokenize of another owner's token = %q, %v", value, err)
+	}
+}
+
+func TestTokenVaultNeverCreatesCustomerKeys(t *testing.T) {
+	ctx := context.Background()
+	keys, store, kek := newCustomerKeys(t)
+	vault := utils.NewTokenVault(&memoryTokenStore{entries: map[string]*utils.TokenEntry{}}, baseKeyring(t), kek, keys)
+
+	if _, err := vault.Tokenize(ctx, "made-up", "Passport", "N1111111", utils.TokenFormatRandom); !errors.Is(err, utils.ErrCustomerKeyNotFound) {
+		t.Errorf("Tokenize for a customer without a key = %v, want ErrCustomerKeyNotFound", err)
+	}
+	if _, ok := store.keys["made-up"]; ok {
+		t.Error("Tokenize created a customer key")
+	}
+
+	if _, err := keys.ProviderFor(ctx, "cust-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Tokenize(ctx, "cust-1", "Passport", "N1111111", utils.TokenFormatRandom); err != nil {
+		t.Errorf("Tokenize for an existing customer: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// AES-SIV (RFC 5297) deterministic authenticated encryption. The synthetic IV
+// is a CMAC over the associated data and plaintext, so the same inputs under
+// the same key always produce the same ciphertext.
+
+const sivBlockSize = aes.BlockSize
+
+var errSIVAuth = errors.New("siv: message authentication failed")
+
+// sivKey derives the 64-byte AES-SIV key (CMAC key || CTR key, AES-256 each)
+// from a 32-byte keyring key, so the same key is never used for both GCM and SIV.
+func sivKey(key []byte) ([]byte, error) {
+	derived := make([]byte, 64)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("zeropii aes-siv")), derived); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// sivSeal returns V || C for plaintext under key (32 or 64 bytes).
+func sivSeal(key, plaintext []byte, associatedData ...[]byte) ([]byte, error) {
+	macKey, ctrKey := key[:len(key)/2], key[len(key)/2:]
+
+	v, err := s2v(macKey, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, sivBlockSize+len(plaintext))
+	copy(out, v)
+	if err := sivCTR(ctrKey, v, out[sivBlockSize:], plaintext); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sivOpen verifies and decrypts V || C.
+func sivOpen(key, ciphertext []byte, associatedData ...[]byte) ([]byte, error) {
+	if len(ciphertext) < sivBlockSize {
+		return nil, errSIVAuth
+	}
+	macKey, ctrKey := key[:len(key)/2], key[len(key)/2:]
+	v := ciphertext[:sivBlockSize]
+
+	plaintext := make([]byte, len(ciphertext)-sivBlockSize)
+	if err := sivCTR(ctrKey, v, plaintext, ciphertext[sivBlockSize:]); err != nil {
+		return nil, err
+	}
+
+	expected, err := s2v(macKey, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(v, expected) != 1 {
+		return nil, errSIVAuth
+	}
+	return plaintext, nil
+}
+
+// sivCTR runs AES-CTR with the synthetic IV, whose bits 31 and 63 are cleared.
+func sivCTR(key, v, dst, src []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	q := make([]byte, sivBlockSize)
+	copy(q, v)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(block, q).XORKeyStream(dst, src)
+	return nil
+}
+
+// s2v is the RFC 5297 string-to-vector PRF over the associated data strings
+// followed by the plaintext.
+func s2v(key, plaintext []byte, associatedData [][]byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := cmac(block, make([]byte, sivBlockSize))
+	for _, ad := range associatedData {
+		d = dbl(d)
+		xorInto(d, cmac(block, ad))
+	}
+
+	var t []byte
+	if len(plaintext) >= sivBlockSize {
+		t = append([]byte(nil), plaintext...)
+		xorInto(t[len(t)-sivBlockSize:], d)
+	} else {
+		t = dbl(d)
+		padded := make([]byte, sivBlockSize)
+		copy(padded, plaintext)
+		padded[len(plaintext)] = 0x80
+		xorInto(t, padded)
+	}
+	return cmac(block, t), nil
+}
+
+// cmac computes AES-CMAC (RFC 4493) of msg.
+func cmac(block cipher.Block, msg []byte) []byte {
+	k1 := make([]byte, sivBlockSize)
+	block.Encrypt(k1, k1)
+	k1 = dbl(k1)
+	k2 := dbl(k1)
+
+	n := (len(msg) + sivBlockSize - 1) / sivBlockSize
+	complete := n > 0 && len(msg)%sivBlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, sivBlockSize)
+	if complete {
+		copy(last, msg[(n-1)*sivBlockSize:])
+		xorInto(last, k1)
+	} else {
+		rest := msg[(n-1)*sivBlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorInto(last, k2)
+	}
+
+	x := make([]byte, sivBlockSize)
+	for i := 0; i < n-1; i++ {
+		xorInto(x, msg[i*sivBlockSize:(i+1)*sivBlockSize])
+		block.Encrypt(x, x)
+	}
+	xorInto(x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// dbl multiplies a block by x in GF(2^128).
+func dbl(in []byte) []byte {
+	out := make([]byte, sivBlockSize)
+	var carry byte
+	for i := sivBlockSize - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+	if carry != 0 {
+		out[sivBlockSize-1] ^= 0x87
+	}
+	return out
+}
+
+func xorInto(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
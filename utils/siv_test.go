@@ -0,0 +1,123 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"zeropii/utils"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sivVectors are the examples of RFC 5297 appendix A. The nonce of A.2 is
+// passed as the last associated data string, as the RFC specifies.
+var sivVectors = []struct {
+	name           string
+	key            string
+	associatedData []string
+	plaintext      string
+	output         string
+}{
+	{
+		name:           "A.1 deterministic",
+		key:            "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+		associatedData: []string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+		plaintext:      "11223344 55667788 99aabbcc ddee",
+		output:         "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+	},
+	{
+		name: "A.2 nonce-based",
+		key:  "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+		associatedData: []string{
+			"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+			"10203040 50607080 90a0",
+			"09f91102 9d74e35b d84156c5 635688c0",
+		},
+		plaintext: "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+		output: "7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17 dba77ceb 094fa663 " +
+			"b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+	},
+}
+
+func TestSIVVectors(t *testing.T) {
+	for _, v := range sivVectors {
+		t.Run(v.name, func(t *testing.T) {
+			key, plaintext, want := unhex(t, v.key), unhex(t, v.plaintext), unhex(t, v.output)
+			var ad [][]byte
+			for _, s := range v.associatedData {
+				ad = append(ad, unhex(t, s))
+			}
+
+			got, err := utils.SIVSeal(key, plaintext, ad...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("seal = %x, want %x", got, want)
+			}
+			opened, err := utils.SIVOpen(key, want, ad...)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Fatalf("open = %x, %v, want %x", opened, err, plaintext)
+			}
+		})
+	}
+}
+
+func TestSIVRejectsTampering(t *testing.T) {
+	v := sivVectors[0]
+	key, ciphertext := unhex(t, v.key), unhex(t, v.output)
+	ad := unhex(t, v.associatedData[0])
+
+	for i := range ciphertext {
+		damaged := append([]byte(nil), ciphertext...)
+		damaged[i] ^= 0x01
+		if _, err := utils.SIVOpen(key, damaged, ad); err == nil {
+			t.Errorf("open accepted a flipped bit in byte %d", i)
+		}
+	}
+	if _, err := utils.SIVOpen(key, ciphertext[:15], ad); err == nil {
+		t.Error("open accepted a truncated synthetic IV")
+	}
+
+	wrongAD := append([]byte(nil), ad...)
+	wrongAD[0] ^= 0x01
+	for name, ads := range map[string][][]byte{
+		"wrong":   {wrongAD},
+		"missing": nil,
+		"extra":   {ad, ad},
+	} {
+		if _, err := utils.SIVOpen(key, ciphertext, ads...); err == nil {
+			t.Errorf("open accepted %s associated data", name)
+		}
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	keyring := baseKeyring(t)
+	a, err := keyring.EncryptDeterministic("ethan", []byte("Aliases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := keyring.EncryptDeterministic("ethan", []byte("Aliases"))
+	if err != nil || a != b {
+		t.Fatalf("equal inputs give %q and %q (%v)", a, b, err)
+	}
+	if other, _ := keyring.EncryptDeterministic("ethan", []byte("Nickname")); other == a {
+		t.Error("different associated data gives the same ciphertext")
+	}
+	if plaintext, err := keyring.DecryptDeterministic(a, []byte("Aliases")); err != nil || plaintext != "ethan" {
+		t.Errorf("DecryptDeterministic = %q, %v", plaintext, err)
+	}
+	if _, err := keyring.DecryptDeterministic(a, []byte("Nickname")); err == nil {
+		t.Error("DecryptDeterministic accepted the wrong field")
+	}
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Chunked AEAD streaming following the STREAM construction (Hoang et al.,
+// "Online Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance").
+// The plaintext is split into streamChunkSize chunks, each sealed with
+// AES-256-GCM under the nonce
+//
+//	prefix (7 bytes) | chunk counter (4 bytes, big endian) | last chunk flag (1 byte)
+//
+// so chunks cannot be reordered, dropped or truncated without detection, and
+// only one chunk is held in memory at a time. The stream starts with
+// streamVersion and the random nonce prefix.
+const (
+	streamVersion    byte = 1
+	streamChunkSize       = 64 * 1024
+	streamPrefixSize      = 7
+	streamHeaderSize      = 1 + streamPrefixSize
+	streamTagSize         = 16
+)
+
+var ErrStreamCorrupt = errors.New("encrypted stream is corrupt, truncated or was altered")
+
+// EncryptedStreamSize returns the size of the stream produced for
+// plaintextSize bytes of input.
+func EncryptedStreamSize(plaintextSize int64) int64 {
+	chunks := (plaintextSize + streamChunkSize - 1) / streamChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return streamHeaderSize + plaintextSize + chunks*streamTagSize
+}
+
+// NewEncryptingReader returns a reader yielding the encrypted stream of r
+// under a 32-byte key. associatedData is bound to every chunk.
+func NewEncryptingReader(r io.Reader, key, associatedData []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	header[0] = streamVersion
+	if _, err := io.ReadFull(rand.Reader, header[1:]); err != nil {
+		return nil, err
+	}
+
+	s := &streamSealer{
+		src:    r,
+		aead:   aead,
+		prefix: header[1:],
+		aad:    associatedData,
+		in:     make([]byte, streamChunkSize+1),
+	}
+	s.out = header
+	return s, nil
+}
+
+// NewDecryptingReader returns a reader yielding the plaintext of an
+// encrypted stream. Read returns ErrStreamCorrupt if any chunk fails
+// authentication or the stream was cut short; callers must not trust data
+// already read once that happens.
+func NewDecryptingReader(r io.Reader, key, associatedData []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrStreamCorrupt
+	}
+	if header[0] != streamVersion {
+		return nil, fmt.Errorf("%w: stream version %d", ErrUnsupportedCipher, header[0])
+	}
+
+	return &streamOpener{
+		src:    r,
+		aead:   aead,
+		prefix: header[1:],
+		aad:    associatedData,
+		in:     make([]byte, streamChunkSize+streamTagSize+1),
+	}, nil
+}
+
+// streamNonce builds the nonce of chunk counter.
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, gcmNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	if last {
+		nonce[gcmNonceSize-1] = 1
+	}
+	return nonce
+}
+
+type streamSealer struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	aad     []byte
+	counter uint32
+	// in holds up to one chunk plus one byte of read-ahead, which tells
+	// whether the chunk is the last one
+	in   []byte
+	n    int
+	out  []byte
+	done bool
+}
+
+func (s *streamSealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *streamSealer) sealChunk() error {
+	m, err := io.ReadFull(s.src, s.in[s.n:])
+	s.n += m
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+	if s.counter == ^uint32(0) {
+		return fmt.Errorf("stream too long")
+	}
+
+	chunk := s.in[:min(s.n, streamChunkSize)]
+	s.out = s.aead.Seal(s.out[:0], streamNonce(s.prefix, s.counter, last), chunk, s.aad)
+	s.counter++
+
+	// Keep the read-ahead byte for the next chunk
+	rest := copy(s.in, s.in[len(chunk):s.n])
+	s.n = rest
+	s.done = last
+	return nil
+}
+
+type streamOpener struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	aad     []byte
+	counter uint32
+	in      []byte
+	n       int
+	out     []byte
+	done    bool
+}
+
+func (s *streamOpener) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *streamOpener) openChunk() error {
+	m, err := io.ReadFull(s.src, s.in[s.n:])
+	s.n += m
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+
+	chunk := s.in[:min(s.n, streamChunkSize+streamTagSize)]
+	plaintext, err := s.aead.Open(s.out[:0], streamNonce(s.prefix, s.counter, last), chunk, s.aad)
+	if err != nil {
+		return ErrStreamCorrupt
+	}
+	s.out = plaintext
+	s.counter++
+
+	rest := copy(s.in, s.in[len(chunk):s.n])
+	s.n = rest
+	s.done = last
+	return nil
+}
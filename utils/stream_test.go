@@ -0,0 +1,113 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"zeropii/utils"
+)
+
+const (
+	chunkSize       = 64 * 1024
+	headerSize      = 8
+	sealedChunkSize = chunkSize + 16
+)
+
+// sealStream returns the encrypted stream of plaintext.
+func sealStream(t *testing.T, plaintext, key, ad []byte) []byte {
+	t.Helper()
+	r, err := utils.NewEncryptingReader(bytes.NewReader(plaintext), key, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+// openStream returns the plaintext of an encrypted stream.
+func openStream(sealed, key, ad []byte) ([]byte, error) {
+	r, err := utils.NewDecryptingReader(bytes.NewReader(sealed), key, ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := randomKey(t)
+	ad := []byte("cust-1/passport")
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		sealed := sealStream(t, plaintext, key, ad)
+		if int64(len(sealed)) != utils.EncryptedStreamSize(int64(size)) {
+			t.Errorf("%d bytes: stream is %d bytes, EncryptedStreamSize says %d", size, len(sealed), utils.EncryptedStreamSize(int64(size)))
+		}
+		opened, err := openStream(sealed, key, ad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("%d bytes: round trip = %d bytes, %v", size, len(opened), err)
+		}
+	}
+}
+
+func TestStreamRejectsAlteredStreams(t *testing.T) {
+	key := randomKey(t)
+	ad := []byte("cust-1/passport")
+	plaintext := make([]byte, 2*chunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	sealed := sealStream(t, plaintext, key, ad)
+	chunk := func(i int) []byte {
+		start := headerSize + i*sealedChunkSize
+		return sealed[start:min(start+sealedChunkSize, len(sealed))]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{sealed[:headerSize]}, parts...), nil)
+	}
+	flipped := func(offset int) []byte {
+		altered := bytes.Clone(sealed)
+		altered[offset] ^= 1
+		return altered
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		key    []byte
+		ad     []byte
+	}{
+		{"final chunk dropped", join(chunk(0), chunk(1)), key, ad},
+		{"final chunk cut short", sealed[:len(sealed)-1], key, ad},
+		{"only the header", sealed[:headerSize], key, ad},
+		{"chunks reordered", join(chunk(1), chunk(0), chunk(2)), key, ad},
+		{"chunk repeated", join(chunk(0), chunk(0), chunk(1), chunk(2)), key, ad},
+		{"byte flipped in the nonce prefix", flipped(1), key, ad},
+		{"byte flipped in a middle chunk", flipped(headerSize + sealedChunkSize + 10), key, ad},
+		{"byte flipped in a tag", flipped(len(sealed) - 1), key, ad},
+		{"other associated data", sealed, key, []byte("cust-2/passport")},
+		{"other key", sealed, randomKey(t), ad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openStream(tt.stream, tt.key, tt.ad); !errors.Is(err, utils.ErrStreamCorrupt) {
+				t.Errorf("opening the stream = %v, want ErrStreamCorrupt", err)
+			}
+		})
+	}
+
+	if _, err := openStream(sealed[:headerSize-1], key, ad); !errors.Is(err, utils.ErrStreamCorrupt) {
+		t.Errorf("opening a short header = %v, want ErrStreamCorrupt", err)
+	}
+	if _, err := openStream(flipped(0), key, ad); !errors.Is(err, utils.ErrUnsupportedCipher) {
+		t.Errorf("opening an unknown stream version = %v, want ErrUnsupportedCipher", err)
+	}
+}
@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrTokenExists        = errors.New("token already exists")
+	ErrNoTokenizer        = errors.New("keyring has no tokenizer")
+	ErrUnknownTokenFormat = errors.New("unknown token format")
+)
+
+// TokenFormat selects the shape of the tokens handed out for a value.
+type TokenFormat string
+
+const (
+	// TokenFormatRandom tokens are opaque strings such as tok_9aZ...
+	TokenFormatRandom TokenFormat = "random"
+	// TokenFormatPreserving tokens are random characters of the same class
+	// as each character of the value, so they pass the same format checks.
+	TokenFormatPreserving TokenFormat = "format_preserving"
+)
+
+const (
+	tokenPrefix     = "tok_"
+	tokenAttempts   = 5
+	minTokenEntropy = 6 // format-preserving tokens need at least 10^6 possible values
+)
+
+// TokenEntry is a vault record mapping a token back to its encrypted value.
+// Value is encrypted with a data key of its own, wrapped by the owning
+// customer's key when there is an owner, so shredding the customer also
+// makes their tokens undecryptable.
+type TokenEntry struct {
+	Token       string      `json:"token" bson:"_id"`
+	Fingerprint string      `json:"-" bson:"fingerprint"`
+	Field       string      `json:"field" bson:"field"`
+	Owner       string      `json:"owner,omitempty" bson:"owner,omitempty"`
+	Format      TokenFormat `json:"format" bson:"format"`
+	Value       string      `json:"-" bson:"value"`
+	DataKey     *WrappedKey `json:"-" bson:"data_key"`
+	CreatedAt   time.Time   `json:"created_at" bson:"created_at"`
+}
+
+// TokenStore persists vault entries.
+type TokenStore interface {
+	// GetToken returns ErrTokenNotFound if the token does not exist.
+	GetToken(ctx context.Context, token string) (*TokenEntry, error)
+	// FindTokenByFingerprint returns ErrTokenNotFound if no token was issued
+	// for the fingerprint.
+	FindTokenByFingerprint(ctx context.Context, fingerprint string) (*TokenEntry, error)
+	// PutToken stores a new entry. It fails with ErrTokenExists if the token
+	// or the fingerprint is already stored.
+	PutToken(ctx context.Context, entry *TokenEntry) error
+	// ForgetFingerprints replaces the fingerprints of the owner's entries
+	// with values derived from nothing but the token, so the entries can no
+	// longer be found by value.
+	ForgetFingerprints(ctx context.Context, owner string) error
+	// DeleteTokens deletes the owner's entries.
+	DeleteTokens(ctx context.Context, owner string) error
+}
+
+// Tokenizer swaps a field value for a token.
+type Tokenizer interface {
+	Tokenize(field, value string, format TokenFormat) (string, error)
+}
+
+// TokenVault swaps PII for tokens and keeps the plaintext encrypted in a
+// TokenStore. Tokenizing the same value for the same owner, field and format
+// returns the existing token, so tokens are stable references.
+type TokenVault struct {
+	store   TokenStore
+	keyring *Keyring
+	kek     KeyProvider
+	keys    *CustomerKeyManager
+}
+
+// NewTokenVault creates a vault storing entries in store. Entries with an
+// owner are wrapped by that customer's key from keys, other entries by kek.
+// The keyring's blind index key is used to find existing tokens.
+func NewTokenVault(store TokenStore, keyring *Keyring, kek KeyProvider, keys *CustomerKeyManager) *TokenVault {
+	return &TokenVault{store: store, keyring: keyring, kek: kek, keys: keys}
+}
+
+// Tokenize returns the token for value, issuing a new one if needed. owner is
+// the customer the value belongs to, or empty for values without one; an
+// owner must already have a customer key.
+func (v *TokenVault) Tokenize(ctx context.Context, owner, field, value string, format TokenFormat) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if format != TokenFormatRandom && format != TokenFormatPreserving {
+		return "", fmt.Errorf("%w: %q", ErrUnknownTokenFormat, format)
+	}
+	if format == TokenFormatPreserving && tokenEntropy(value) < minTokenEntropy {
+		return "", ErrFPEValueTooShort
+	}
+
+	fingerprint, err := v.fingerprint(owner, field, value, format)
+	if err != nil {
+		return "", err
+	}
+	if existing, err := v.store.FindTokenByFingerprint(ctx, fingerprint); err == nil {
+		return existing.Token, nil
+	} else if !errors.Is(err, ErrTokenNotFound) {
+		return "", err
+	}
+
+	provider, err := v.providerFor(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	dek, wrapped, err := provider.GenerateDataKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("generate data key: %w", err)
+	}
+	defer clear(dek)
+
+	kr, err := v.keyring.withDataKey(dataKeyID, dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := kr.Encrypt(value)
+	if err != nil {
+		return "", err
+	}
+
+	entry := &TokenEntry{
+		Fingerprint: fingerprint,
+		Field:       field,
+		Owner:       owner,
+		Format:      format,
+		Value:       ciphertext,
+		DataKey:     wrapped,
+		CreatedAt:   time.Now().UTC(),
+	}
+	for attempt := 0; attempt < tokenAttempts; attempt++ {
+		if entry.Token, err = newToken(value, format); err != nil {
+			return "", err
+		}
+		err = v.store.PutToken(ctx, entry)
+		if err == nil {
+			return entry.Token, nil
+		}
+		if !errors.Is(err, ErrTokenExists) {
+			return "", err
+		}
+		// Either the token collided, or another request tokenized the same
+		// value first and its token should be returned instead
+		if existing, findErr := v.store.FindTokenByFingerprint(ctx, fingerprint); findErr == nil {
+			return existing.Token, nil
+		}
+	}
+	return "", fmt.Errorf("could not issue a unique token after %d attempts", tokenAttempts)
+}
+
+// Detokenize returns the vault entry of token and its decrypted value. It
+// returns ErrKeyShredded if the owning customer has been erased.
+func (v *TokenVault) Detokenize(ctx context.Context, token string) (*TokenEntry, string, error) {
+	entry, err := v.store.GetToken(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+	provider, err := v.providerFor(ctx, entry.Owner)
+	if err != nil {
+		return entry, "", err
+	}
+	dek, err := provider.UnwrapDataKey(ctx, entry.DataKey)
+	if err != nil {
+		return entry, "", err
+	}
+	defer clear(dek)
+
+	kr, err := v.keyring.withDataKey(dataKeyID, dek)
+	if err != nil {
+		return entry, "", err
+	}
+	value, err := kr.Decrypt(entry.Value)
+	if err != nil {
+		return entry, "", err
+	}
+	return entry, value, nil
+}
+
+// ForgetOwner unlinks the tokens of an erased customer from their values.
+// Fingerprints are keyed with the shared blind index key rather than the
+// customer key, so without this they would still confirm a guessed value
+// after the customer is shredded.
+func (v *TokenVault) ForgetOwner(ctx context.Context, owner string) error {
+	if owner == "" {
+		return fmt.Errorf("owner is required")
+	}
+	return v.store.ForgetFingerprints(ctx, owner)
+}
+
+// DeleteOwner deletes the tokens of owner, for customers whose creation
+// failed after their fields were tokenized.
+func (v *TokenVault) DeleteOwner(ctx context.Context, owner string) error {
+	if owner == "" {
+		return fmt.Errorf("owner is required")
+	}
+	return v.store.DeleteTokens(ctx, owner)
+}
+
+// ForOwner returns a Tokenizer issuing tokens owned by customerID, for use
+// with Keyring.WithTokenizer.
+func (v *TokenVault) ForOwner(ctx context.Context, customerID string) Tokenizer {
+	return &ownerTokenizer{ctx: ctx, vault: v, owner: customerID}
+}
+
+// providerFor returns the provider wrapping the entries of owner. The vault
+// never creates customer keys: an owner without one gets ErrCustomerKeyNotFound.
+func (v *TokenVault) providerFor(ctx context.Context, owner string) (KeyProvider, error) {
+	if owner == "" {
+		return v.kek, nil
+	}
+	return v.keys.ExistingProviderFor(ctx, owner)
+}
+
+// fingerprint is a keyed HMAC identifying a value without revealing it. It
+// is separated from blind indexes so vault fingerprints never match them.
+func (v *TokenVault) fingerprint(owner, field, value string, format TokenFormat) (string, error) {
+	v.keyring.mu.RLock()
+	key := v.keyring.indexKey
+	v.keyring.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoIndexKey
+	}
+	mac := hmac.New(sha256.New, key)
+	for _, part := range []string{"token", owner, field, string(format), value} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type ownerTokenizer struct {
+	ctx   context.Context
+	vault *TokenVault
+	owner string
+}
+
+func (t *ownerTokenizer) Tokenize(field, value string, format TokenFormat) (string, error) {
+	return t.vault.Tokenize(t.ctx, t.owner, field, value, format)
+}
+
+// newToken generates a random token in the given format.
+func newToken(value string, format TokenFormat) (string, error) {
+	if format == TokenFormatRandom {
+		raw := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+			return "", err
+		}
+		return tokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+	}
+
+	for {
+		runes := []rune(value)
+		for i, r := range runes {
+			for _, class := range fpeClasses {
+				if !class.contains(r) {
+					continue
+				}
+				n, err := rand.Int(rand.Reader, big.NewInt(int64(class.radix)))
+				if err != nil {
+					return "", err
+				}
+				runes[i] = class.first + rune(n.Int64())
+				break
+			}
+		}
+		// A token must never reveal the value it stands for
+		if token := string(runes); token != value {
+			return token, nil
+		}
+	}
+}
+
+// tokenEntropy returns log10 of the number of format-preserving tokens for value.
+func tokenEntropy(value string) float64 {
+	var entropy float64
+	for _, r := range value {
+		for _, class := range fpeClasses {
+			if class.contains(r) {
+				entropy += math.Log10(float64(class.radix))
+				break
+			}
+		}
+	}
+	return entropy
+}
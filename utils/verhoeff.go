@@ -0,0 +1,59 @@
+package utils
+
+// Verhoeff checksum tables: the multiplication table of the dihedral group
+// D5, the position permutation and the inverses.
+var (
+	verhoeffD = [10][10]byte{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8},
+		{5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2},
+		{7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffP = [8][10]byte{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 6, 8, 7, 0},
+		{4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5},
+		{7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+	verhoeffInv = [10]byte{0, 4, 3, 2, 1, 5, 6, 7, 8, 9}
+)
+
+// VerhoeffValid reports whether digits, ending with its check digit, passes
+// the Verhoeff checksum used by Aadhaar numbers.
+func VerhoeffValid(digits string) bool {
+	c, ok := verhoeff(digits, 0)
+	return ok && c == 0
+}
+
+// VerhoeffCheckDigit returns the Verhoeff check digit to append to digits.
+func VerhoeffCheckDigit(digits string) (byte, bool) {
+	c, ok := verhoeff(digits, 1)
+	return '0' + verhoeffInv[c], ok
+}
+
+// verhoeff runs the checksum over digits from the right, with the rightmost
+// digit at position offset
+func verhoeff(digits string, offset int) (byte, bool) {
+	if digits == "" {
+		return 0, false
+	}
+	var c byte
+	for i := 0; i < len(digits); i++ {
+		d := digits[len(digits)-1-i]
+		if d < '0' || d > '9' {
+			return 0, false
+		}
+		c = verhoeffD[c][verhoeffP[(i+offset)%8][d-'0']]
+	}
+	return c, true
+}
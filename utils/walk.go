@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// piiField is a string leaf tagged pii:"true", as visited by walkPII.
+type piiField struct {
+	// Path is the dotted path of the field from the root struct, e.g.
+	// "Passport.PassportDob". Slice and array elements share the path of
+	// their field, map values add their key as a segment, and pointers and
+	// interfaces add nothing.
+	Path string
+	// Name is the Go name of the tagged struct field.
+	Name string
+	Tag  PIIDescriptor
+	// Value is the settable string.
+	Value reflect.Value
+	// Parent is the struct declaring the field, for sibling lookups such as
+	// the blind index field.
+	Parent reflect.Value
+}
+
+// walkPII calls visit for every string tagged pii:"true" reachable from the
+// struct that data points to. It follows nested structs, pointers,
+// interfaces, slices, arrays and maps, so every operation built on it covers
+// exactly the same fields. A tagged field may itself be a string, a pointer
+// to one, or a slice or map of strings; each string is visited with the tag.
+func walkPII(data interface{}, visit func(field piiField) error) error {
+	val := reflect.ValueOf(data)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected pointer to a struct")
+	}
+	w := &piiWalker{visit: visit, seen: map[pointerKey]bool{pointerKeyOf(val): true}, plan: planFor}
+	return w.walkStruct(val.Elem(), "")
+}
+
+type piiWalker struct {
+	visit func(field piiField) error
+	// seen holds visited pointers so cyclic data is walked once
+	seen map[pointerKey]bool
+	// plan returns the plan of a struct type; planFor outside of benchmarks
+	plan func(reflect.Type) *typePlan
+}
+
+// pointerKey identifies a visited pointer by its type as well as its
+// address: a pointer to a struct and a pointer to its first field share an
+// address, and both must be walked.
+type pointerKey struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+func pointerKeyOf(v reflect.Value) pointerKey {
+	return pointerKey{typ: v.Type(), addr: v.Pointer()}
+}
+
+func (w *piiWalker) walkStruct(val reflect.Value, path string) error {
+	for _, field := range w.plan(val.Type()).fields {
+		leaf := piiField{
+			Path:   joinPath(path, field.path),
+			Name:   field.name,
+			Tag:    field.tag,
+			Parent: val.FieldByIndex(field.parent),
+		}
+		if err := w.walkValue(val.FieldByIndex(field.index), leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkValue walks v, which belongs to the struct field described by leaf.
+// Strings are visited only if that field is tagged.
+func (w *piiWalker) walkValue(v reflect.Value, leaf piiField) error {
+	switch v.Kind() {
+	case reflect.String:
+		if leaf.Tag.Enabled && v.CanSet() {
+			leaf.Value = v
+			return w.visit(leaf)
+		}
+
+	case reflect.Struct:
+		return w.walkStruct(v, leaf.Path)
+
+	case reflect.Ptr:
+		if v.IsNil() || w.seen[pointerKeyOf(v)] {
+			return nil
+		}
+		w.seen[pointerKeyOf(v)] = true
+		return w.walkValue(v.Elem(), leaf)
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := v.Elem()
+		if elem.Kind() == reflect.Ptr {
+			return w.walkValue(elem, leaf)
+		}
+		// Values stored directly in an interface are not settable, so walk
+		// a copy and store it back
+		copied := reflect.New(elem.Type()).Elem()
+		copied.Set(elem)
+		if err := w.walkValue(copied, leaf); err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.Set(copied)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := w.walkValue(v.Index(i), leaf); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		// Sorted keys keep the visiting order stable
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			// Map values are not settable either
+			copied := reflect.New(v.Type().Elem()).Elem()
+			copied.Set(v.MapIndex(key))
+
+			entry := leaf
+			entry.Path = joinPath(leaf.Path, fmt.Sprint(key.Interface()))
+			if err := w.walkValue(copied, entry); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, copied)
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// legacyWalkPII is walkPII without type plans: every struct field is looked
+// up and its pii tag parsed on every walk. It is a rewrite for the walker
+// benchmarks, not the walker the plans replaced, so it only approximates
+// what walks cost before.
+func legacyWalkPII(data interface{}, visit func(field piiField) error) error {
+	val := reflect.ValueOf(data)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected pointer to a struct")
+	}
+	w := &legacyWalker{visit: visit, seen: map[pointerKey]bool{pointerKeyOf(val): true}}
+	return w.walkStruct(val.Elem(), "")
+}
+
+type legacyWalker struct {
+	visit func(field piiField) error
+	// seen holds visited pointers so cyclic data is walked once
+	seen map[pointerKey]bool
+}
+
+func (w *legacyWalker) walkStruct(val reflect.Value, path string) error {
+	typ := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		fieldType := typ.Field(i)
+		// Unexported fields cannot be set
+		if fieldType.PkgPath != "" {
+			continue
+		}
+
+		leaf := piiField{
+			Path:   joinPath(path, fieldType.Name),
+			Name:   fieldType.Name,
+			Tag:    parsePIITag(fieldType),
+			Parent: val,
+		}
+		if err := w.walkValue(val.Field(i), leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkValue walks v, which belongs to the struct field described by leaf.
+// Strings are visited only if that field is tagged.
+func (w *legacyWalker) walkValue(v reflect.Value, leaf piiField) error {
+	switch v.Kind() {
+	case reflect.String:
+		if leaf.Tag.Enabled && v.CanSet() {
+			leaf.Value = v
+			return w.visit(leaf)
+		}
+
+	case reflect.Struct:
+		return w.walkStruct(v, leaf.Path)
+
+	case reflect.Ptr:
+		if v.IsNil() || w.seen[pointerKeyOf(v)] {
+			return nil
+		}
+		w.seen[pointerKeyOf(v)] = true
+		return w.walkValue(v.Elem(), leaf)
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := v.Elem()
+		if elem.Kind() == reflect.Ptr {
+			return w.walkValue(elem, leaf)
+		}
+		// Values stored directly in an interface are not settable, so walk
+		// a copy and store it back
+		copied := reflect.New(elem.Type()).Elem()
+		copied.Set(elem)
+		if err := w.walkValue(copied, leaf); err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.Set(copied)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := w.walkValue(v.Index(i), leaf); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		// Sorted keys keep the visiting order stable
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			// Map values are not settable either
+			copied := reflect.New(v.Type().Elem()).Elem()
+			copied.Set(v.MapIndex(key))
+
+			entry := leaf
+			entry.Path = joinPath(leaf.Path, fmt.Sprint(key.Interface()))
+			if err := w.walkValue(copied, entry); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, copied)
+		}
+	}
+	return nil
+}
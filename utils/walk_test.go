@@ -0,0 +1,146 @@
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"zeropii/utils"
+)
+
+type walkContact struct {
+	Phone string `pii:"category=phone"`
+	Name  string `pii:"category=name"`
+	Note  string
+}
+
+type walkNode struct {
+	ID       string                  `bson:"_id"`
+	Name     string                  `pii:"category=name"`
+	Nickname *string                 `pii:"category=name"`
+	Contact  *walkContact            `json:"contact"`
+	Labels   map[string]string       `pii:"category=other"`
+	ByKind   map[string]*walkContact `json:"by_kind"`
+	Any      interface{}             `json:"any"`
+	Next     *walkNode               `json:"next"`
+}
+
+// walkers are the planned walker and the walker it replaced, which must
+// agree on every shape.
+var walkers = map[string]func(interface{}, func(path, value string)) error{
+	"plan":   utils.WalkPII,
+	"legacy": utils.LegacyWalkPII,
+}
+
+func visited(t *testing.T, walk func(interface{}, func(path, value string)) error, data interface{}) []string {
+	t.Helper()
+	var visits []string
+	if err := walk(data, func(path, value string) { visits = append(visits, path+"="+value) }); err != nil {
+		t.Fatal(err)
+	}
+	return visits
+}
+
+func TestWalkPIIShapes(t *testing.T) {
+	nickname := "E"
+	tests := []struct {
+		name string
+		data func() interface{}
+		want []string
+	}{
+		{
+			name: "nil pointers, maps and interfaces",
+			data: func() interface{} { return &walkNode{Name: "ethan"} },
+			want: []string{"Name=ethan"},
+		},
+		{
+			name: "pointers and maps",
+			data: func() interface{} {
+				return &walkNode{
+					Nickname: &nickname,
+					Contact:  &walkContact{Phone: "98765", Name: "luther", Note: "n"},
+					Labels:   map[string]string{"work": "w", "home": "h"},
+					ByKind:   map[string]*walkContact{"emergency": {Phone: "12345"}, "none": nil},
+				}
+			},
+			want: []string{
+				"Name=", "Nickname=E", "Contact.Phone=98765", "Contact.Name=luther",
+				"Labels.home=h", "Labels.work=w",
+				"ByKind.emergency.Phone=12345", "ByKind.emergency.Name=",
+			},
+		},
+		{
+			name: "interfaces holding values and pointers",
+			data: func() interface{} {
+				return &walkNode{Any: []interface{}{walkContact{Phone: "1"}, &walkContact{Phone: "2"}, nil, "untagged"}}
+			},
+			want: []string{"Name=", "Any.Phone=1", "Any.Name=", "Any.Phone=2", "Any.Name="},
+		},
+		{
+			name: "cycles",
+			data: func() interface{} {
+				a := &walkNode{Name: "a"}
+				b := &walkNode{Name: "b", Next: a}
+				a.Next = b
+				c := &walkNode{Name: "c"}
+				c.Next = c
+				b.Any = c
+				return a
+			},
+			want: []string{"Name=a", "Next.Name=b", "Next.Any.Name=c"},
+		},
+	}
+	for _, tt := range tests {
+		for name, walk := range walkers {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				if got := visited(t, walk, tt.data()); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("visited\n%q\nwant\n%q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// A pointer to a struct and a pointer to its first field share an address;
+// walking one must not hide the other.
+func TestWalkPIISharedAddress(t *testing.T) {
+	type aliased struct {
+		Phone   *string `pii:"category=phone"`
+		Contact *walkContact
+	}
+	contact := &walkContact{Phone: "98765", Name: "luther"}
+	data := &aliased{Phone: &contact.Phone, Contact: contact}
+
+	want := []string{"Phone=98765", "Contact.Phone=98765", "Contact.Name=luther"}
+	for name, walk := range walkers {
+		if got := visited(t, walk, data); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s walker visited %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Values reached through maps and interfaces are not settable in place;
+// the walker must store what it changed back.
+func TestWalkPIIWritesBack(t *testing.T) {
+	keyring := testKeyring(t)
+	original := func() *walkNode {
+		return &walkNode{
+			ID:     "node-1",
+			Labels: map[string]string{"home": "h"},
+			ByKind: map[string]*walkContact{"emergency": {Phone: "12345"}},
+			Any:    walkContact{Name: "luther"},
+		}
+	}
+	node := original()
+	if err := utils.EncryptStructPII(node, keyring); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.IsRandomized(node.Labels["home"]) || !utils.IsRandomized(node.ByKind["emergency"].Phone) || !utils.IsRandomized(node.Any.(walkContact).Name) {
+		t.Fatalf("map and interface values were not encrypted: %+v", node)
+	}
+	if err := utils.DecryptStructPII(node, keyring); err != nil {
+		t.Fatal(err)
+	}
+	if want := original(); !reflect.DeepEqual(node, want) {
+		t.Errorf("round trip = %+v, want %+v", node, want)
+	}
+}